@@ -1,5 +1,7 @@
 package adapter
 
+import "github.com/rnetx/cdns/utils/metrics"
+
 type Starter interface {
 	Start() error
 }
@@ -8,6 +10,16 @@ type Closer interface {
 	Close() error
 }
 
+// Validator checks what a component looks up from the core and loads from local files, without any network
+// or background loops. It is called by the config check, and by Start before starting.
+type Validator interface {
+	Validate() error
+}
+
+type MetricsCollector interface {
+	CollectMetrics(w *metrics.Writer)
+}
+
 func Start(v any) error {
 	starter, isStarter := v.(Starter)
 	if isStarter {
@@ -16,6 +28,14 @@ func Start(v any) error {
 	return nil
 }
 
+func Validate(v any) error {
+	validator, isValidator := v.(Validator)
+	if isValidator {
+		return validator.Validate()
+	}
+	return nil
+}
+
 func Close(v any) error {
 	closer, isCloser := v.(Closer)
 	if isCloser {
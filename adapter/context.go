@@ -56,6 +56,12 @@ type DNSContext struct {
 	respUpstreamTag string
 	mark            uint64
 	metadata        map[string]string
+	trace           *Trace
+	//
+	fallbackResp    *dns.Msg
+	fallbackTimeout time.Duration
+	fallbackOwner   string
+	fallbackKey     string
 }
 
 func NewDNSContext(ctx context.Context, listener string, clientIP netip.Addr, req *dns.Msg) *DNSContext {
@@ -111,6 +117,7 @@ func (c *DNSContext) Clone() *DNSContext {
 		clientIP: c.clientIP,
 		req:      c.req.Copy(),
 		mark:     c.mark,
+		trace:    c.trace,
 	}
 	if c.resp != nil {
 		newDNSContext.resp = c.resp.Copy()
@@ -160,9 +167,37 @@ func (c *DNSContext) SetMark(mark uint64) {
 	c.mark = mark
 }
 
+// SetFallbackRespMsg sets the response used by the upstream step of the workflow if the upstream fails,
+// or does not respond within timeout (0 for no limit), such as a stale cached response. It is not cloned.
+// owner is the tag of the plugin which sets it, key is what the owner knows the response by, such as its cache key.
+func (c *DNSContext) SetFallbackRespMsg(resp *dns.Msg, timeout time.Duration, owner string, key string) {
+	c.fallbackResp = resp
+	c.fallbackTimeout = timeout
+	c.fallbackOwner = owner
+	c.fallbackKey = key
+}
+
+func (c *DNSContext) FallbackRespMsg() (*dns.Msg, time.Duration) {
+	return c.fallbackResp, c.fallbackTimeout
+}
+
+// FallbackOwner returns the owner and the key of the fallback response
+func (c *DNSContext) FallbackOwner() (string, string) {
+	return c.fallbackOwner, c.fallbackKey
+}
+
 func (c *DNSContext) Metadata() map[string]string {
 	if c.metadata == nil {
 		c.metadata = make(map[string]string)
 	}
 	return c.metadata
 }
+
+// Trace returns nil if tracing is not enabled.
+func (c *DNSContext) Trace() *Trace {
+	return c.trace
+}
+
+func (c *DNSContext) SetTrace(trace *Trace) {
+	c.trace = trace
+}
@@ -1,6 +1,9 @@
 package adapter
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type Core interface {
 	Closer
@@ -17,3 +20,10 @@ type Core interface {
 	GetPluginExecutors() []PluginExecutor
 	GetTimeFunc() func() time.Time
 }
+
+// ErrPartialReload is wrapped by Reloader.Reload when the new config is applied but some listeners failed to start
+var ErrPartialReload = errors.New("reload partially failed")
+
+type Reloader interface {
+	Reload() error
+}
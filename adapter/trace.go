@@ -0,0 +1,54 @@
+package adapter
+
+import (
+	"sync"
+	"time"
+)
+
+// Trace records how a request goes through workflows, attached to DNSContext when tracing is enabled.
+type Trace struct {
+	lock      sync.Mutex
+	startTime time.Time
+	events    []TraceEvent
+}
+
+type TraceEvent struct {
+	// Microseconds since the trace began
+	Time int64 `json:"time"`
+	// Microseconds the step takes
+	Cost     int64  `json:"cost"`
+	Workflow string `json:"workflow,omitempty"`
+	Path     string `json:"path"`
+	// rule, match or exec
+	Type     string `json:"type"`
+	Rule     string `json:"rule,omitempty"`
+	Matched  *bool  `json:"matched,omitempty"`
+	Invert   bool   `json:"invert,omitempty"`
+	Return   string `json:"return,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func NewTrace() *Trace {
+	return &Trace{
+		startTime: time.Now(),
+	}
+}
+
+// Add records event, which began at startTime.
+func (t *Trace) Add(startTime time.Time, event TraceEvent) {
+	event.Time = startTime.Sub(t.startTime).Microseconds()
+	event.Cost = time.Since(startTime).Microseconds()
+	t.lock.Lock()
+	t.events = append(t.events, event)
+	t.lock.Unlock()
+}
+
+// Events returns events in the order they finished.
+func (t *Trace) Events() []TraceEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	events := make([]TraceEvent, len(t.events))
+	copy(events, t.events)
+	return events
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,16 +13,20 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/constant"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/utils/metrics"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"github.com/miekg/dns"
 )
 
 var filterRegexp *regexp.Regexp
@@ -46,6 +51,7 @@ type APIServer struct {
 	debug  bool
 
 	listener net.Listener
+	router   atomic.Value
 
 	broadcastLogger *log.BroadcastLogger
 }
@@ -120,6 +126,125 @@ func (s *APIServer) versionInfo() http.HandlerFunc {
 	}
 }
 
+func (s *APIServer) reloadHandler(reloader adapter.Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := reloader.Reload()
+		if err != nil {
+			s.logger.ErrorfContext(r.Context(), "reload failed: %s", err)
+			// The new config is in use if the reload partially failed
+			raw, _ := json.Marshal(map[string]any{"error": err.Error(), "partial": errors.Is(err, adapter.ErrPartialReload)})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(raw)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+const traceTimeout = 20 * time.Second
+
+// traceHandler runs a synthetic query through a workflow with tracing enabled
+func (s *APIServer) traceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(code int, err error) {
+			raw, _ := json.Marshal(map[string]any{"error": err.Error()})
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			w.Write(raw)
+		}
+		query := r.URL.Query()
+		workflowTag := query.Get("workflow")
+		workflow := s.core.GetWorkflow(workflowTag)
+		if workflow == nil {
+			writeError(http.StatusNotFound, fmt.Errorf("workflow [%s] not found", workflowTag))
+			return
+		}
+		name := query.Get("name")
+		if name == "" {
+			writeError(http.StatusBadRequest, fmt.Errorf("missing name"))
+			return
+		}
+		qType := dns.TypeA
+		if t := query.Get("type"); t != "" {
+			var ok bool
+			qType, ok = dns.StringToType[strings.ToUpper(t)]
+			if !ok {
+				writeError(http.StatusBadRequest, fmt.Errorf("invalid type: %s", t))
+				return
+			}
+		}
+		clientIP := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+		if ip := query.Get("client-ip"); ip != "" {
+			var err error
+			clientIP, err = netip.ParseAddr(ip)
+			if err != nil {
+				writeError(http.StatusBadRequest, fmt.Errorf("invalid client-ip: %s", ip))
+				return
+			}
+		}
+		req := &dns.Msg{}
+		req.SetQuestion(dns.Fqdn(name), qType)
+		req.RecursionDesired = true
+		ctx, cancel := context.WithTimeout(s.ctx, traceTimeout)
+		defer cancel()
+		dnsCtx := adapter.NewDNSContext(ctx, query.Get("listener"), clientIP, req)
+		dnsCtx.SetTrace(adapter.NewTrace())
+		ctx = adapter.SaveLogContext(dnsCtx.Context(), dnsCtx)
+		returnMode, err := workflow.Exec(ctx, dnsCtx)
+		data := map[string]any{
+			"workflow": workflowTag,
+			"return":   returnMode.String(),
+			"upstream": dnsCtx.RespUpstreamTag(),
+			"cost":     dnsCtx.Duration().Milliseconds(),
+			"trace":    dnsCtx.Trace().Events(),
+		}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		if respMsg := dnsCtx.RespMsg(); respMsg != nil {
+			data["response"] = respMsg.String()
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(raw)
+		}
+	}
+}
+
+func (s *APIServer) metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mw := metrics.NewWriter()
+		collect := func(v any) {
+			collector, isCollector := v.(adapter.MetricsCollector)
+			if isCollector {
+				collector.CollectMetrics(mw)
+			}
+		}
+		for _, l := range s.core.GetListeners() {
+			collect(l)
+		}
+		for _, u := range s.core.GetUpstreams() {
+			collect(u)
+		}
+		for _, wf := range s.core.GetWorkflows() {
+			collect(wf)
+		}
+		for _, pm := range s.core.GetPluginMatchers() {
+			collect(pm)
+		}
+		for _, pe := range s.core.GetPluginExecutors() {
+			collect(pe)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		mw.WriteTo(w)
+	}
+}
+
 func (s *APIServer) debugHTTPHandler() http.Handler {
 	router := chi.NewRouter()
 	router.HandleFunc("/pprof", pprof.Index)
@@ -280,9 +405,23 @@ func (s *APIServer) initHTTPRouter() http.Handler {
 			r.Mount("/log", s.logWebsocketHandler())
 		}
 		r.Mount("/version", s.versionInfo())
+		reloader, isReloader := s.core.(adapter.Reloader)
+		if isReloader {
+			r.Post("/reload", s.reloadHandler(reloader))
+		}
+		r.Get("/trace", s.traceHandler())
+		r.Get("/metrics", s.metricsHandler())
 		upstreamRouter := chi.NewRouter()
 		upstreams := s.core.GetUpstreams()
 		for _, u := range upstreams {
+			// Mount before the statistics route, which replaces the GET route of the mounted handler
+			apiHandler, isAPIHandler := u.(adapter.APIHandler)
+			if isAPIHandler && apiHandler != nil {
+				httpHandler := apiHandler.APIHandler()
+				if httpHandler != nil {
+					upstreamRouter.Mount("/"+u.Tag(), httpHandler)
+				}
+			}
 			upstreamRouter.Get("/"+u.Tag(), func(u adapter.Upstream) func(w http.ResponseWriter, r *http.Request) {
 				return func(w http.ResponseWriter, r *http.Request) {
 					data := map[string]any{
@@ -397,14 +536,24 @@ func (s *APIServer) Start() error {
 		err = fmt.Errorf("failed to listen: %w", err)
 		return err
 	}
+	s.router.Store(s.initHTTPRouter())
 	httpServer := &http.Server{
-		Handler: s.initHTTPRouter(),
+		Handler: s,
 	}
 	go httpServer.Serve(s.listener)
 	s.logger.Infof("api server started: %s", s.listen)
 	return nil
 }
 
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// Reload rebuilds routes after upstreams or plugins of the core are replaced.
+func (s *APIServer) Reload() {
+	s.router.Store(s.initHTTPRouter())
+}
+
 func (s *APIServer) Close() error {
 	s.listener.Close()
 	return nil
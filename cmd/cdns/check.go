@@ -0,0 +1,39 @@
+package cdns
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rnetx/cdns/core"
+
+	"github.com/spf13/cobra"
+)
+
+var checkCommand = &cobra.Command{
+	Use: "check",
+	Run: func(_ *cobra.Command, _ []string) {
+		code := check()
+		if code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func check() int {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read config file failed: %s, error: %s\n", configPath, err)
+		return 1
+	}
+	errs := core.Check(context.Background(), raw)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		fmt.Fprintf(os.Stderr, "config check failed: %d error(s)\n", len(errs))
+		return 1
+	}
+	fmt.Println("config check passed")
+	return 0
+}
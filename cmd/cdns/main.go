@@ -2,6 +2,7 @@ package cdns
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
@@ -40,18 +41,27 @@ func init() {
 	//
 	MainCommand.PersistentFlags().StringVarP(&configPath, "config", "c", "config.yaml", "config file path")
 	MainCommand.AddCommand(versionCommand)
+	MainCommand.AddCommand(checkCommand)
+	MainCommand.AddCommand(queryCommand)
 }
 
-func run() int {
-	raw, err := os.ReadFile(configPath)
+func readOptions(path string) (core.Options, error) {
+	var options core.Options
+	raw, err := os.ReadFile(path)
 	if err != nil {
-		log.DefaultLogger.Errorf("read config file failed: %s, error: %s", configPath, err)
-		return 1
+		return options, fmt.Errorf("read config file failed: %s, error: %s", path, err)
 	}
-	var options core.Options
 	err = yaml.Unmarshal(raw, &options)
 	if err != nil {
-		log.DefaultLogger.Errorf("parse config file failed: %s, error: %s", configPath, err)
+		return options, fmt.Errorf("parse config file failed: %s, error: %s", path, err)
+	}
+	return options, nil
+}
+
+func run() int {
+	options, err := readOptions(configPath)
+	if err != nil {
+		log.DefaultLogger.Error(err)
 		return 1
 	}
 	ctx, cancel := context.WithCancel(context.Background())
@@ -61,13 +71,16 @@ func run() int {
 		log.DefaultLogger.Error(err)
 		return 1
 	}
+	c.SetOptionsLoader(func() (core.Options, error) {
+		return readOptions(configPath)
+	})
 	coreLogger.Infof("cdns %s", constant.Version)
 	coreLogger.Infof("plugin matcher: %s", strings.Join(plugin.PluginMatcherTypes(), ", "))
 	coreLogger.Infof("plugin executor: %s", strings.Join(plugin.PluginExecutorTypes(), ", "))
 	if constant.ListenerEnablePainc {
 		coreLogger.Infof("debug: listener enable painc")
 	}
-	go signalHandle(cancel, coreLogger)
+	go signalHandle(cancel, c, coreLogger)
 	err = c.Run()
 	if err != nil {
 		return 1
@@ -75,10 +88,32 @@ func run() int {
 	return 0
 }
 
-func signalHandle(cancel context.CancelFunc, logger log.Logger) {
+func signalHandle(cancel context.CancelFunc, c *core.Core, logger log.Logger) {
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, os.Interrupt)
-	<-signalChan
-	logger.Warn("receive signal, exiting...")
-	cancel()
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt)
+	if reopenLogSignal != nil {
+		signal.Notify(signalChan, reopenLogSignal)
+	}
+	for sig := range signalChan {
+		if sig == syscall.SIGHUP {
+			logger.Info("receive signal, reloading...")
+			err := c.Reload()
+			if err != nil {
+				logger.Error(err)
+			}
+			continue
+		}
+		if reopenLogSignal != nil && sig == reopenLogSignal {
+			err := c.ReopenLog()
+			if err != nil {
+				logger.Errorf("reopen log file failed: %s", err)
+			} else {
+				logger.Info("log file reopened")
+			}
+			continue
+		}
+		logger.Warn("receive signal, exiting...")
+		cancel()
+		return
+	}
 }
@@ -0,0 +1,105 @@
+package cdns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/core"
+	"github.com/rnetx/cdns/log"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+var queryCommand = &cobra.Command{
+	Use:  "query <name> [type]",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(_ *cobra.Command, args []string) {
+		code := query(args)
+		if code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+var (
+	queryUpstream string
+	queryWorkflow string
+	queryClientIP string
+	queryListener string
+)
+
+func init() {
+	queryCommand.Flags().StringVar(&queryUpstream, "upstream", "", "upstream tag")
+	queryCommand.Flags().StringVar(&queryWorkflow, "workflow", "", "workflow tag")
+	queryCommand.Flags().StringVar(&queryClientIP, "client-ip", "127.0.0.1", "client ip")
+	queryCommand.Flags().StringVar(&queryListener, "listener", "", "listener tag")
+}
+
+func query(args []string) int {
+	if (queryUpstream == "") == (queryWorkflow == "") {
+		log.DefaultLogger.Error("one of --upstream and --workflow must be set")
+		return 1
+	}
+	clientIP, err := netip.ParseAddr(queryClientIP)
+	if err != nil {
+		log.DefaultLogger.Errorf("invalid client ip: %s", queryClientIP)
+		return 1
+	}
+	qType := dns.TypeA
+	if len(args) > 1 {
+		var ok bool
+		qType, ok = dns.StringToType[strings.ToUpper(args[1])]
+		if !ok {
+			log.DefaultLogger.Errorf("invalid query type: %s", args[1])
+			return 1
+		}
+	}
+	options, err := readOptions(configPath)
+	if err != nil {
+		log.DefaultLogger.Error(err)
+		return 1
+	}
+	// Only the target and its dependencies are used, log to stderr and skip api and ntp
+	options.Log.Output = "stderr"
+	options.API = nil
+	options.NTP = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, _, err := core.NewCore(ctx, options)
+	if err != nil {
+		log.DefaultLogger.Error(err)
+		return 1
+	}
+	defer c.Close()
+	req := &dns.Msg{}
+	req.SetQuestion(dns.Fqdn(args[0]), qType)
+	req.RecursionDesired = true
+	dnsCtx := adapter.NewDNSContext(ctx, queryListener, clientIP, req)
+	t := time.Now()
+	err = c.Query(dnsCtx, queryUpstream, queryWorkflow)
+	if err != nil {
+		log.DefaultLogger.Error(err)
+		return 1
+	}
+	duration := time.Since(t)
+	respMsg := dnsCtx.RespMsg()
+	if respMsg == nil {
+		fmt.Println(";; no response")
+	} else {
+		fmt.Println(respMsg.String())
+	}
+	if tag := dnsCtx.RespUpstreamTag(); tag != "" {
+		fmt.Printf(";; UPSTREAM: %s\n", tag)
+	}
+	fmt.Printf(";; Query time: %d msec\n", duration.Milliseconds())
+	if respMsg == nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package cdns
+
+import "os"
+
+var reopenLogSignal os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package cdns
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenLogSignal reopens the log file, for external tools like logrotate
+var reopenLogSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/api"
+	"github.com/rnetx/cdns/listener"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/ntp"
+	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/workflow"
+
+	"gopkg.in/yaml.v3"
+)
+
+type checkOptions struct {
+	Log             yaml.Node   `yaml:"log,omitempty"`
+	API             yaml.Node   `yaml:"api,omitempty"`
+	Upstreams       []yaml.Node `yaml:"upstreams,omitempty"`
+	Workflows       []yaml.Node `yaml:"workflows,omitempty"`
+	Listeners       []yaml.Node `yaml:"listeners,omitempty"`
+	PluginMatchers  []yaml.Node `yaml:"plugin-matchers,omitempty"`
+	PluginExecutors []yaml.Node `yaml:"plugin-executors,omitempty"`
+	NTP             yaml.Node   `yaml:"ntp,omitempty"`
+}
+
+type checkWorkflowOptions struct {
+	Tag   string    `yaml:"tag"`
+	Rules yaml.Node `yaml:"rules"`
+}
+
+type checkRule struct {
+	index   int
+	options workflow.RuleOptions
+}
+
+// checkComponent is an upstream or a plugin created by the checker, validated after all components are created
+type checkComponent struct {
+	path string
+	v    any
+}
+
+type checker struct {
+	g          *graph
+	errs       []error
+	components []checkComponent
+}
+
+// Check validates the config like NewCore and Run, without opening any sockets or starting background loops.
+// All errors are returned with the YAML path of the failed item, e.g. `workflows[2].rules[5].exec[1].plugin`.
+func Check(ctx context.Context, raw []byte) []error {
+	var o checkOptions
+	err := yaml.Unmarshal(raw, &o)
+	if err != nil {
+		return []error{fmt.Errorf("parse config file failed: %s", err)}
+	}
+	nopLogger := log.NewNopLogger()
+	c := &Core{
+		ctx:         ctx,
+		rootLogger:  nopLogger,
+		coreLogger:  nopLogger,
+		basicLogger: nopLogger,
+	}
+	k := &checker{g: newEmptyGraph(c)}
+	defer k.g.close(nil)
+	k.check(o)
+	return k.errs
+}
+
+func (k *checker) add(path string, err error) {
+	k.errs = append(k.errs, fmt.Errorf("%s: %s", path, err))
+}
+
+func (k *checker) decode(path string, node *yaml.Node, v any) bool {
+	err := node.Decode(v)
+	if err != nil {
+		k.add(path, fmt.Errorf("line %d: %s", node.Line, err))
+		return false
+	}
+	return true
+}
+
+func (k *checker) check(o checkOptions) {
+	g := k.g
+	// Log
+	if !o.Log.IsZero() {
+		var logOptions LogOptions
+		if k.decode("log", &o.Log, &logOptions) {
+			if logOptions.Level != "" {
+				_, err := log.ParseLevelString(logOptions.Level)
+				if err != nil {
+					k.add("log.level", err)
+				}
+			}
+			switch logOptions.Format {
+			case "text", "json", "":
+			default:
+				k.add("log.format", fmt.Errorf("invalid log format: %s", logOptions.Format))
+			}
+		}
+	}
+	// API
+	if !o.API.IsZero() {
+		var apiOptions api.Options
+		if k.decode("api", &o.API, &apiOptions) {
+			_, err := api.NewAPIServer(g.ctx, g, g.basicLogger, apiOptions)
+			if err != nil {
+				k.add("api", err)
+			}
+		}
+	}
+	// Upstreams
+	if len(o.Upstreams) == 0 {
+		k.add("upstreams", fmt.Errorf("missing upstreams"))
+	}
+	for i := range o.Upstreams {
+		path := fmt.Sprintf("upstreams[%d]", i)
+		var upstreamOptions upstream.Options
+		if k.decode(path, &o.Upstreams[i], &upstreamOptions) {
+			err := g.addUpstream(upstreamOptions, nil)
+			if err != nil {
+				k.add(path, err)
+			} else {
+				k.components = append(k.components, checkComponent{path: path, v: g.upstreamMap[upstreamOptions.Tag]})
+			}
+		}
+	}
+	_, err := sortUpstream(g.upstreams)
+	if err != nil {
+		k.add("upstreams", err)
+	}
+	// Workflows
+	if len(o.Workflows) == 0 {
+		k.add("workflows", fmt.Errorf("missing workflows"))
+	}
+	workflowRules := make(map[int][]checkRule, len(o.Workflows))
+	for i := range o.Workflows {
+		path := fmt.Sprintf("workflows[%d]", i)
+		var workflowOptions checkWorkflowOptions
+		if !k.decode(path, &o.Workflows[i], &workflowOptions) {
+			continue
+		}
+		ruleNodes := []*yaml.Node{&workflowOptions.Rules}
+		if workflowOptions.Rules.Kind == yaml.SequenceNode {
+			ruleNodes = workflowOptions.Rules.Content
+		}
+		var (
+			rules  utils.Listable[workflow.RuleOptions]
+			failed bool
+		)
+		for j, node := range ruleNodes {
+			if node.IsZero() {
+				continue
+			}
+			var ruleOptions workflow.RuleOptions
+			if !k.decode(fmt.Sprintf("%s.rules[%d]", path, j), node, &ruleOptions) {
+				failed = true
+				continue
+			}
+			rules = append(rules, ruleOptions)
+			workflowRules[i] = append(workflowRules[i], checkRule{index: j, options: ruleOptions})
+		}
+		if failed && len(rules) == 0 {
+			continue
+		}
+		err := g.addWorkflow(workflow.WorkflowOptions{
+			Tag:   workflowOptions.Tag,
+			Rules: rules,
+		})
+		if err != nil {
+			k.add(path, err)
+		}
+	}
+	// Listeners
+	if len(o.Listeners) == 0 {
+		k.add("listeners", fmt.Errorf("missing listeners"))
+	}
+	for i := range o.Listeners {
+		path := fmt.Sprintf("listeners[%d]", i)
+		var listenerOptions listener.Options
+		if k.decode(path, &o.Listeners[i], &listenerOptions) {
+			err := g.addListener(listenerOptions, nil)
+			if err != nil {
+				k.add(path, err)
+			}
+		}
+	}
+	// Plugins
+	for i := range o.PluginMatchers {
+		path := fmt.Sprintf("plugin-matchers[%d]", i)
+		var pluginMatcherOptions plugin.PluginMatcherOptions
+		if k.decode(path, &o.PluginMatchers[i], &pluginMatcherOptions) {
+			err := g.addPluginMatcher(pluginMatcherOptions, nil)
+			if err != nil {
+				k.add(path, err)
+			} else {
+				k.components = append(k.components, checkComponent{path: path, v: g.pluginMatcherMap[pluginMatcherOptions.Tag]})
+			}
+		}
+	}
+	for i := range o.PluginExecutors {
+		path := fmt.Sprintf("plugin-executors[%d]", i)
+		var pluginExecutorOptions plugin.PluginExecutorOptions
+		if k.decode(path, &o.PluginExecutors[i], &pluginExecutorOptions) {
+			err := g.addPluginExecutor(pluginExecutorOptions, nil)
+			if err != nil {
+				k.add(path, err)
+			} else {
+				k.components = append(k.components, checkComponent{path: path, v: g.pluginExecutorMap[pluginExecutorOptions.Tag]})
+			}
+		}
+	}
+	// NTP
+	if !o.NTP.IsZero() {
+		var ntpOptions ntp.NTPOptions
+		if k.decode("ntp", &o.NTP, &ntpOptions) {
+			ntpServer, err := ntp.NewNTPServer(g.ctx, g, g.basicLogger, ntpOptions)
+			if err != nil {
+				k.add("ntp", err)
+			} else if ntpServer.UpstreamTag() != "" && g.upstreamMap[ntpServer.UpstreamTag()] == nil {
+				k.add("ntp.upstream", fmt.Errorf("upstream [%s] not found", ntpServer.UpstreamTag()))
+			}
+		}
+	}
+	// Components are validated without starting them, after all of them are created
+	for _, component := range k.components {
+		err := adapter.Validate(component.v)
+		if err != nil {
+			k.add(component.path, err)
+		}
+	}
+	// Workflow rules, checked after all components are validated
+	for i := range o.Workflows {
+		for _, rule := range workflowRules[i] {
+			for _, err := range workflow.CheckRule(g.ctx, g, rule.options) {
+				if err.Path == "" {
+					k.add(fmt.Sprintf("workflows[%d].rules[%d]", i, rule.index), err.Err)
+				} else {
+					k.add(fmt.Sprintf("workflows[%d].rules[%d].%s", i, rule.index, err.Path), err.Err)
+				}
+			}
+		}
+	}
+}
@@ -2,9 +2,13 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rnetx/cdns/adapter"
@@ -12,12 +16,9 @@ import (
 	"github.com/rnetx/cdns/listener"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/ntp"
-	"github.com/rnetx/cdns/plugin"
 	"github.com/rnetx/cdns/plugin/executor"
 	"github.com/rnetx/cdns/plugin/matcher"
-	"github.com/rnetx/cdns/upstream"
-	"github.com/rnetx/cdns/utils"
-	"github.com/rnetx/cdns/workflow"
+	"github.com/rnetx/cdns/utils/rotate"
 
 	"github.com/logrusorgru/aurora/v4"
 )
@@ -27,35 +28,38 @@ func init() {
 	executor.Do()
 }
 
-var _ adapter.Core = (*Core)(nil)
+var (
+	_ adapter.Core     = (*Core)(nil)
+	_ adapter.Reloader = (*Core)(nil)
+)
 
 type Core struct {
-	ctx        context.Context
-	rootLogger log.Logger
-	coreLogger log.Logger
-	logOutput  io.Writer
+	ctx         context.Context
+	rootLogger  log.Logger
+	coreLogger  log.Logger
+	basicLogger log.Logger
+	logOutput   io.Writer
+	options     Options
 	//
 	apiServer *api.APIServer
 	//
-	listeners         []adapter.Listener
-	listenerMap       map[string]adapter.Listener
-	upstreams         []adapter.Upstream
-	upstreamMap       map[string]adapter.Upstream
-	workflows         []adapter.Workflow
-	workflowMap       map[string]adapter.Workflow
-	pluginMatchers    []adapter.PluginMatcher
-	pluginMatcherMap  map[string]adapter.PluginMatcher
-	pluginExecutors   []adapter.PluginExecutor
-	pluginExecutorMap map[string]adapter.PluginExecutor
+	graph atomic.Pointer[graph]
 	//
 	ntpServer *ntp.NTPServer
+	//
+	reloadLock       sync.Mutex
+	running          bool
+	startedListeners []adapter.Listener
+	optionsLoader    func() (Options, error)
+	retireWait       sync.WaitGroup
 }
 
-func NewCore(ctx context.Context, options Options) (adapter.Core, log.Logger, error) {
+func NewCore(ctx context.Context, options Options) (*Core, log.Logger, error) {
 	var (
 		logOutput  io.Writer
 		rootLogger log.Logger
 	)
+	disableColor := options.Log.DisableColor
 	if options.Log.Disabled {
 		rootLogger = log.NewNopLogger()
 	} else {
@@ -73,142 +77,51 @@ func NewCore(ctx context.Context, options Options) (adapter.Core, log.Logger, er
 		case "stderr", "Stderr":
 			logOutput = os.Stderr
 		default:
-			options.Log.DisableColor = true
-			f, err := os.OpenFile(options.Log.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+			disableColor = true
+			var rotateOptions rotate.Options
+			if options.Log.Rotate != nil {
+				rotateOptions = rotate.Options{
+					MaxSize:    int64(options.Log.Rotate.MaxSize),
+					MaxAge:     time.Duration(options.Log.Rotate.MaxAge),
+					MaxBackups: options.Log.Rotate.MaxBackups,
+					Compress:   options.Log.Rotate.Compress,
+				}
+			}
+			f, err := rotate.Open(options.Log.Output, rotateOptions)
 			if err != nil {
 				return nil, nil, fmt.Errorf("open log file failed: %s", err)
 			}
 			logOutput = f
 		}
-		rootLogger = log.NewSimpleLogger(logOutput, level, options.Log.DisableTimestamp, options.Log.DisableColor)
+		switch options.Log.Format {
+		case "text", "":
+			rootLogger = log.NewSimpleLogger(logOutput, level, options.Log.DisableTimestamp, disableColor)
+		case "json":
+			rootLogger = log.NewJSONLogger(logOutput, level, options.Log.DisableTimestamp)
+		default:
+			return nil, nil, fmt.Errorf("invalid log format: %s", options.Log.Format)
+		}
 	}
 	c := &Core{
 		ctx:        ctx,
 		rootLogger: rootLogger,
 		coreLogger: log.NewTagLogger(rootLogger, "core", aurora.RedFg),
 		logOutput:  logOutput,
+		options:    options,
 	}
-	basicLogger := c.rootLogger
+	c.basicLogger = c.rootLogger
 	var broadcastLogger *log.BroadcastLogger
 	if !options.Log.Disabled && options.API != nil {
-		broadcastLogger = log.NewBroadcastLogger(basicLogger)
-		basicLogger = broadcastLogger
-	}
-	if len(options.Upstreams) == 0 {
-		return nil, nil, fmt.Errorf("missing upstreams")
+		broadcastLogger = log.NewBroadcastLogger(c.basicLogger)
+		c.basicLogger = broadcastLogger
 	}
-	c.upstreams = make([]adapter.Upstream, 0, len(options.Upstreams))
-	c.upstreamMap = make(map[string]adapter.Upstream, len(options.Upstreams))
-	for i, upstreamOptions := range options.Upstreams {
-		tag := upstreamOptions.Tag
-		if tag == "" {
-			return nil, nil, fmt.Errorf("create upstream[%d] failed: missing upstream tag", i)
-		}
-		_, ok := c.upstreamMap[tag]
-		if ok {
-			return nil, nil, fmt.Errorf("create upstream[%d] failed: duplicate upstream tag: %s", i, tag)
-		}
-		upstreamLogger := log.NewTagLogger(basicLogger, fmt.Sprintf("upstream/%s", tag), aurora.GreenFg)
-		u, err := upstream.NewUpstream(c.ctx, c, upstreamLogger, tag, upstreamOptions)
-		if err != nil {
-			return nil, nil, fmt.Errorf("create upstream[%d] failed: %s", i, err)
-		}
-		c.upstreams = append(c.upstreams, u)
-		c.upstreamMap[tag] = u
-	}
-	var err error
-	c.upstreams, err = sortUpstream(c.upstreams)
+	g, err := newGraph(c, options, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("sort upstreams failed: %s", err)
-	}
-	if len(options.Workflows) == 0 {
-		return nil, nil, fmt.Errorf("missing workflows")
-	}
-	c.workflows = make([]adapter.Workflow, 0, len(options.Workflows))
-	c.workflowMap = make(map[string]adapter.Workflow, len(options.Workflows))
-	for i, workflowOptions := range options.Workflows {
-		tag := workflowOptions.Tag
-		if tag == "" {
-			return nil, nil, fmt.Errorf("create workflow[%d] failed: missing workflow tag", i)
-		}
-		_, ok := c.workflowMap[tag]
-		if ok {
-			return nil, nil, fmt.Errorf("create workflow[%d] failed: duplicate workflow tag: %s", i, tag)
-		}
-		workflowLogger := log.NewTagLogger(basicLogger, fmt.Sprintf("workflow/%s", tag), aurora.CyanFg)
-		w, err := workflow.NewWorkflow(c.ctx, c, workflowLogger, tag, workflowOptions)
-		if err != nil {
-			return nil, nil, fmt.Errorf("create workflow[%d] failed: %s", i, err)
-		}
-		c.workflows = append(c.workflows, w)
-		c.workflowMap[tag] = w
-	}
-	if len(options.Listeners) == 0 {
-		return nil, nil, fmt.Errorf("missing listeners")
-	}
-	c.listeners = make([]adapter.Listener, 0, len(options.Listeners))
-	c.listenerMap = make(map[string]adapter.Listener, len(options.Listeners))
-	for i, listenerOptions := range options.Listeners {
-		tag := listenerOptions.Tag
-		if tag == "" {
-			return nil, nil, fmt.Errorf("create listener[%d] failed: missing listener tag", i)
-		}
-		_, ok := c.listenerMap[tag]
-		if ok {
-			return nil, nil, fmt.Errorf("create listener[%d] failed: duplicate listener tag: %s", i, tag)
-		}
-		listenerLogger := log.NewTagLogger(basicLogger, fmt.Sprintf("listener/%s", tag), aurora.YellowFg)
-		l, err := listener.NewListener(c.ctx, c, listenerLogger, tag, listenerOptions)
-		if err != nil {
-			return nil, nil, fmt.Errorf("create listener[%d] failed: %s", i, err)
-		}
-		c.listeners = append(c.listeners, l)
-		c.listenerMap[tag] = l
-	}
-	if len(options.PluginMatchers) > 0 {
-		c.pluginMatchers = make([]adapter.PluginMatcher, 0, len(options.PluginMatchers))
-		c.pluginMatcherMap = make(map[string]adapter.PluginMatcher, len(options.PluginMatchers))
-		for i, pluginMatcherOptions := range options.PluginMatchers {
-			tag := pluginMatcherOptions.Tag
-			if tag == "" {
-				return nil, nil, fmt.Errorf("create plugin matcher[%d] failed: missing plugin matcher tag", i)
-			}
-			_, ok := c.pluginMatcherMap[tag]
-			if ok {
-				return nil, nil, fmt.Errorf("create plugin matcher[%d] failed: duplicate plugin matcher tag: %s", i, tag)
-			}
-			pluginMatcherLogger := log.NewTagLogger(basicLogger, fmt.Sprintf("plugin-matcher/%s", tag), aurora.MagentaFg)
-			pm, err := plugin.NewPluginMatcher(c.ctx, c, pluginMatcherLogger, tag, pluginMatcherOptions.Type, pluginMatcherOptions.Args)
-			if err != nil {
-				return nil, nil, fmt.Errorf("create plugin matcher[%d] failed: %s", i, err)
-			}
-			c.pluginMatchers = append(c.pluginMatchers, pm)
-			c.pluginMatcherMap[tag] = pm
-		}
-	}
-	if len(options.PluginExecutors) > 0 {
-		c.pluginExecutors = make([]adapter.PluginExecutor, 0, len(options.PluginExecutors))
-		c.pluginExecutorMap = make(map[string]adapter.PluginExecutor, len(options.PluginExecutors))
-		for i, pluginExecutorOptions := range options.PluginExecutors {
-			tag := pluginExecutorOptions.Tag
-			if tag == "" {
-				return nil, nil, fmt.Errorf("create plugin executor[%d] failed: missing plugin executor tag", i)
-			}
-			_, ok := c.pluginExecutorMap[tag]
-			if ok {
-				return nil, nil, fmt.Errorf("create plugin executor[%d] failed: duplicate plugin executor tag: %s", i, tag)
-			}
-			pluginExecutorLogger := log.NewTagLogger(basicLogger, fmt.Sprintf("plugin-executor/%s", tag), aurora.BlueFg)
-			pe, err := plugin.NewPluginExecutor(c.ctx, c, pluginExecutorLogger, tag, pluginExecutorOptions.Type, pluginExecutorOptions.Args)
-			if err != nil {
-				return nil, nil, fmt.Errorf("create plugin executor[%d] failed: %s", i, err)
-			}
-			c.pluginExecutors = append(c.pluginExecutors, pe)
-			c.pluginExecutorMap[tag] = pe
-		}
+		return nil, nil, err
 	}
+	c.graph.Store(g)
 	if options.API != nil {
-		apiServerLogger := log.NewTagLogger(basicLogger, "api-server", aurora.RedFg)
+		apiServerLogger := log.NewTagLogger(c.basicLogger, "api-server", aurora.RedFg)
 		c.apiServer, err = api.NewAPIServer(c.ctx, c, apiServerLogger, *options.API)
 		if err != nil {
 			return nil, nil, fmt.Errorf("create api server failed: %s", err)
@@ -216,7 +129,7 @@ func NewCore(ctx context.Context, options Options) (adapter.Core, log.Logger, er
 		c.apiServer.SetBroadcastLogger(broadcastLogger)
 	}
 	if options.NTP != nil {
-		ntpServerLogger := log.NewTagLogger(basicLogger, "ntp", aurora.CyanFg)
+		ntpServerLogger := log.NewTagLogger(c.basicLogger, "ntp", aurora.CyanFg)
 		ntpServer, err := ntp.NewNTPServer(c.ctx, c, ntpServerLogger, *options.NTP)
 		if err != nil {
 			return nil, nil, err
@@ -237,40 +150,32 @@ func (c *Core) Close() error {
 	return nil
 }
 
+// ReopenLog reopens the log file, it does nothing if the log output is not a file
+func (c *Core) ReopenLog() error {
+	f, isFile := c.logOutput.(*rotate.File)
+	if isFile {
+		return f.Reopen()
+	}
+	return nil
+}
+
 func (c *Core) Run() error {
 	c.coreLogger.Info("core is starting...")
 	defer c.coreLogger.Info("core is stopped")
 	t := time.Now()
 	var err error
-	// Upstreams
-	upstreamStack := utils.NewStack[adapter.Upstream](len(c.upstreams))
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	// Upstreams, Plugins, Workflows
+	err = c.graph.Load().start()
+	if err != nil {
+		c.coreLogger.Fatal(err)
+		return err
+	}
 	defer func() {
-		var err error
-		for upstreamStack.Len() > 0 {
-			u := upstreamStack.Pop()
-			closer, isCloser := u.(adapter.Closer)
-			if isCloser {
-				err = closer.Close()
-				if err != nil {
-					c.coreLogger.Errorf("close upstream[%s] failed: %s", u.Tag(), err)
-				} else {
-					c.coreLogger.Infof("close upstream[%s] success", u.Tag())
-				}
-			}
-		}
+		c.graph.Load().close(nil)
+		c.retireWait.Wait()
 	}()
-	for _, u := range c.upstreams {
-		starter, isStarter := u.(adapter.Starter)
-		if isStarter {
-			err = starter.Start()
-			if err != nil {
-				err = fmt.Errorf("start upstream[%s] failed: %s", u.Tag(), err)
-				c.coreLogger.Fatal(err)
-				return err
-			}
-		}
-		upstreamStack.Push(u)
-	}
 	// NTP
 	if c.ntpServer != nil {
 		err = c.ntpServer.Start()
@@ -288,101 +193,20 @@ func (c *Core) Run() error {
 			}
 		}()
 	}
-	if len(c.pluginMatchers) > 0 {
-		pluginMatcherStack := utils.NewStack[adapter.PluginMatcher](len(c.pluginMatchers))
-		defer func() {
-			var err error
-			for pluginMatcherStack.Len() > 0 {
-				pm := pluginMatcherStack.Pop()
-				closer, isCloser := pm.(adapter.Closer)
-				if isCloser {
-					err = closer.Close()
-					if err != nil {
-						c.coreLogger.Errorf("close plugin matcher[%s] failed: %s", pm.Tag(), err)
-					} else {
-						c.coreLogger.Infof("close plugin matcher[%s] success", pm.Tag())
-					}
-				}
-			}
-		}()
-		for _, pm := range c.pluginMatchers {
-			starter, isStarter := pm.(adapter.Starter)
-			if isStarter {
-				err = starter.Start()
-				if err != nil {
-					err = fmt.Errorf("start plugin matcher[%s] failed: %s", pm.Tag(), err)
-					c.coreLogger.Fatal(err)
-					return err
-				}
-			}
-			pluginMatcherStack.Push(pm)
-		}
-	}
-	if len(c.pluginExecutors) > 0 {
-		pluginExecutorStack := utils.NewStack[adapter.PluginExecutor](len(c.pluginExecutors))
-		defer func() {
-			var err error
-			for pluginExecutorStack.Len() > 0 {
-				pe := pluginExecutorStack.Pop()
-				closer, isCloser := pe.(adapter.Closer)
-				if isCloser {
-					err = closer.Close()
-					if err != nil {
-						c.coreLogger.Errorf("close plugin executor[%s] failed: %s", pe.Tag(), err)
-					} else {
-						c.coreLogger.Infof("close plugin executor[%s] success", pe.Tag())
-					}
-				}
-			}
-		}()
-		for _, pe := range c.pluginExecutors {
-			starter, isStarter := pe.(adapter.Starter)
-			if isStarter {
-				err = starter.Start()
-				if err != nil {
-					err = fmt.Errorf("start plugin executor[%s] failed: %s", pe.Tag(), err)
-					c.coreLogger.Fatal(err)
-					return err
-				}
-			}
-			pluginExecutorStack.Push(pe)
+	// Listeners
+	defer func() {
+		for i := len(c.startedListeners) - 1; i >= 0; i-- {
+			c.closeListener(c.startedListeners[i])
 		}
-	}
-	for _, w := range c.workflows {
-		err = w.Check()
+		c.startedListeners = nil
+	}()
+	for _, l := range c.graph.Load().listeners {
+		err = c.startListener(l)
 		if err != nil {
-			err = fmt.Errorf("check workflow[%s] failed: %s", w.Tag(), err)
 			c.coreLogger.Fatal(err)
 			return err
 		}
-	}
-	listenerStack := utils.NewStack[adapter.Listener](len(c.listeners))
-	defer func() {
-		var err error
-		for listenerStack.Len() > 0 {
-			l := listenerStack.Pop()
-			closer, isCloser := l.(adapter.Closer)
-			if isCloser {
-				err = closer.Close()
-				if err != nil {
-					c.coreLogger.Errorf("close listener[%s] failed: %s", l.Tag(), err)
-				} else {
-					c.coreLogger.Infof("close listener[%s] success", l.Tag())
-				}
-			}
-		}
-	}()
-	for _, l := range c.listeners {
-		starter, isStarter := l.(adapter.Starter)
-		if isStarter {
-			err = starter.Start()
-			if err != nil {
-				err = fmt.Errorf("start listener[%s] failed: %s", l.Tag(), err)
-				c.coreLogger.Fatal(err)
-				return err
-			}
-		}
-		listenerStack.Push(l)
+		c.startedListeners = append(c.startedListeners, l)
 	}
 	if c.apiServer != nil {
 		defer func() {
@@ -402,11 +226,198 @@ func (c *Core) Run() error {
 	}
 	duration := time.Since(t)
 	c.coreLogger.Infof("core is started, cost: %dms", duration.Milliseconds())
+	c.running = true
+	c.reloadLock.Unlock()
 	<-c.ctx.Done()
+	c.reloadLock.Lock()
+	c.running = false
 	c.coreLogger.Info("core is stopping...")
 	return nil
 }
 
+func (c *Core) startListener(l adapter.Listener) error {
+	starter, isStarter := l.(adapter.Starter)
+	if isStarter {
+		err := starter.Start()
+		if err != nil {
+			return fmt.Errorf("start listener[%s] failed: %s", l.Tag(), err)
+		}
+	}
+	return nil
+}
+
+func (c *Core) closeListener(l adapter.Listener) {
+	closer, isCloser := l.(adapter.Closer)
+	if isCloser {
+		err := closer.Close()
+		if err != nil {
+			c.coreLogger.Errorf("close listener[%s] failed: %s", l.Tag(), err)
+		} else {
+			c.coreLogger.Infof("close listener[%s] success", l.Tag())
+		}
+	}
+}
+
+func containsListener(listeners []adapter.Listener, l adapter.Listener) bool {
+	for _, ll := range listeners {
+		if ll == l {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOptionsLoader sets the function used by Reload to load new options, e.g. by reading the config file again.
+func (c *Core) SetOptionsLoader(loader func() (Options, error)) {
+	c.optionsLoader = loader
+}
+
+func (c *Core) Reload() error {
+	if c.optionsLoader == nil {
+		return fmt.Errorf("reload failed: missing options loader")
+	}
+	options, err := c.optionsLoader()
+	if err != nil {
+		return fmt.Errorf("reload failed: %s", err)
+	}
+	return c.ReloadOptions(options)
+}
+
+// ReloadOptions builds upstreams, workflows and plugins from options and swaps them in.
+// Listeners whose options did not change keep running, requests in flight finish on the old components.
+// Log, API and NTP options can not be reloaded.
+func (c *Core) ReloadOptions(options Options) error {
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	if !c.running {
+		return fmt.Errorf("reload failed: core is not running")
+	}
+	c.coreLogger.Info("core is reloading...")
+	t := time.Now()
+	if !reflect.DeepEqual(c.options.Log, options.Log) || !reflect.DeepEqual(c.options.API, options.API) || !reflect.DeepEqual(c.options.NTP, options.NTP) {
+		c.coreLogger.Warn("log, api and ntp options can not be reloaded, restart to apply them")
+	}
+	old := c.graph.Load()
+	g, err := newGraph(c, options, old)
+	if err != nil {
+		return fmt.Errorf("reload failed: %s", err)
+	}
+	if c.ntpServer != nil && c.ntpServer.UpstreamTag() != "" && g.upstreamMap[c.ntpServer.UpstreamTag()] == nil {
+		g.close(g.reused)
+		return fmt.Errorf("reload failed: ntp upstream [%s] not found", c.ntpServer.UpstreamTag())
+	}
+	// Reused components look up the new graph from now on, workflow checks may look them up
+	g.own()
+	err = g.start()
+	if err != nil {
+		old.own()
+		return fmt.Errorf("reload failed: %s", err)
+	}
+	c.graph.Store(g)
+	c.options.Upstreams = options.Upstreams
+	c.options.Workflows = options.Workflows
+	c.options.Listeners = options.Listeners
+	c.options.PluginMatchers = options.PluginMatchers
+	c.options.PluginExecutors = options.PluginExecutors
+	listenerErr := c.reloadListeners(g, old)
+	if c.apiServer != nil {
+		c.apiServer.Reload()
+	}
+	// Wait for requests in flight on the old graph, components reused by the new graph keep running
+	c.retireWait.Add(1)
+	go func() {
+		defer c.retireWait.Done()
+		timer := time.NewTimer(old.maxDealTimeout)
+		defer timer.Stop()
+		select {
+		case <-c.ctx.Done():
+		case <-timer.C:
+		}
+		old.close(g.reused)
+	}()
+	if listenerErr != nil {
+		return fmt.Errorf("%w: %w", adapter.ErrPartialReload, listenerErr)
+	}
+	duration := time.Since(t)
+	c.coreLogger.Infof("core is reloaded, cost: %dms", duration.Milliseconds())
+	return nil
+}
+
+// reloadListeners starts the new or changed listeners of g and closes the listeners of old they replace.
+// A changed listener on a different address is started before the old one is closed, so that it keeps serving
+// if the new one fails. A changed listener on the same address has to be closed first, and is created again
+// from the old options if the new one fails. Listeners which are kept in place of failed ones are recorded in g.
+func (c *Core) reloadListeners(g *graph, old *graph) error {
+	// Removed listeners are closed first, a new listener may use the same address
+	for i := len(c.startedListeners) - 1; i >= 0; i-- {
+		l := c.startedListeners[i]
+		if g.listenerMap[l.Tag()] == nil {
+			c.closeListener(l)
+		}
+	}
+	var errs []error
+	startedListeners := make([]adapter.Listener, 0, len(g.listeners))
+	for i, l := range g.listeners {
+		tag := l.Tag()
+		oldListener := old.listenerMap[tag]
+		if oldListener == l {
+			if containsListener(c.startedListeners, l) {
+				startedListeners = append(startedListeners, l)
+			}
+			continue
+		}
+		oldRunning := oldListener != nil && containsListener(c.startedListeners, oldListener)
+		oldOptions := old.listenerOptions[tag]
+		sameAddress := oldRunning && oldOptions.ListenAddress() == g.listenerOptions[tag].ListenAddress()
+		if sameAddress {
+			c.closeListener(oldListener)
+		}
+		err := c.startListener(l)
+		if err == nil {
+			c.coreLogger.Infof("start listener[%s] success", tag)
+			if oldRunning && !sameAddress {
+				c.closeListener(oldListener)
+			}
+			startedListeners = append(startedListeners, l)
+			continue
+		}
+		c.coreLogger.Error(err)
+		errs = append(errs, err)
+		if !oldRunning {
+			continue
+		}
+		// Keep the old listener, if its workflow is still there
+		if g.workflowMap[oldOptions.Workflow] == nil {
+			if !sameAddress {
+				c.closeListener(oldListener)
+			}
+			continue
+		}
+		if sameAddress {
+			listenerLogger := log.NewTagLogger(c.basicLogger, fmt.Sprintf("listener/%s", tag), aurora.YellowFg)
+			oldListener, err = listener.NewListener(c.ctx, c, listenerLogger, tag, oldOptions)
+			if err == nil {
+				err = c.startListener(oldListener)
+			}
+			if err != nil {
+				err = fmt.Errorf("restore listener[%s] failed: %s", tag, err)
+				c.coreLogger.Error(err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		c.coreLogger.Warnf("listener[%s] keeps the old options", tag)
+		g.listenerLock.Lock()
+		g.listeners[i] = oldListener
+		g.listenerMap[tag] = oldListener
+		g.listenerOptions[tag] = oldOptions
+		g.listenerLock.Unlock()
+		startedListeners = append(startedListeners, oldListener)
+	}
+	c.startedListeners = startedListeners
+	return errors.Join(errs...)
+}
+
 func (c *Core) RootLogger() log.Logger {
 	return c.rootLogger
 }
@@ -419,41 +430,41 @@ func (c *Core) GetTimeFunc() func() time.Time {
 }
 
 func (c *Core) GetListener(tag string) adapter.Listener {
-	return c.listenerMap[tag]
+	return c.graph.Load().GetListener(tag)
 }
 
 func (c *Core) GetListeners() []adapter.Listener {
-	return c.listeners
+	return c.graph.Load().GetListeners()
 }
 
 func (c *Core) GetUpstream(tag string) adapter.Upstream {
-	return c.upstreamMap[tag]
+	return c.graph.Load().GetUpstream(tag)
 }
 
 func (c *Core) GetUpstreams() []adapter.Upstream {
-	return c.upstreams
+	return c.graph.Load().GetUpstreams()
 }
 
 func (c *Core) GetWorkflow(tag string) adapter.Workflow {
-	return c.workflowMap[tag]
+	return c.graph.Load().GetWorkflow(tag)
 }
 
 func (c *Core) GetWorkflows() []adapter.Workflow {
-	return c.workflows
+	return c.graph.Load().GetWorkflows()
 }
 
 func (c *Core) GetPluginMatcher(tag string) adapter.PluginMatcher {
-	return c.pluginMatcherMap[tag]
+	return c.graph.Load().GetPluginMatcher(tag)
 }
 
 func (c *Core) GetPluginMatchers() []adapter.PluginMatcher {
-	return c.pluginMatchers
+	return c.graph.Load().GetPluginMatchers()
 }
 
 func (c *Core) GetPluginExecutor(tag string) adapter.PluginExecutor {
-	return c.pluginExecutorMap[tag]
+	return c.graph.Load().GetPluginExecutor(tag)
 }
 
 func (c *Core) GetPluginExecutors() []adapter.PluginExecutor {
-	return c.pluginExecutors
+	return c.graph.Load().GetPluginExecutors()
 }
@@ -0,0 +1,656 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/listener"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/workflow"
+
+	"github.com/logrusorgru/aurora/v4"
+)
+
+var _ adapter.Core = (*graph)(nil)
+
+// graph is a set of upstreams, workflows, plugins and listeners built from one config.
+// It is passed as adapter.Core to the workflows it owns, upstreams and plugins get a componentCore,
+// so that they resolve each other inside the same graph while it is being built or replaced.
+type graph struct {
+	*Core
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// listenerLock guards listeners, which are updated after the graph is swapped in if a listener fails to start
+	listenerLock          sync.RWMutex
+	listeners             []adapter.Listener
+	listenerMap           map[string]adapter.Listener
+	listenerOptions       map[string]listener.Options
+	upstreams             []adapter.Upstream
+	upstreamMap           map[string]adapter.Upstream
+	upstreamOptions       map[string]upstream.Options
+	workflows             []adapter.Workflow
+	workflowMap           map[string]adapter.Workflow
+	pluginMatchers        []adapter.PluginMatcher
+	pluginMatcherMap      map[string]adapter.PluginMatcher
+	pluginMatcherOptions  map[string]plugin.PluginMatcherOptions
+	pluginExecutors       []adapter.PluginExecutor
+	pluginExecutorMap     map[string]adapter.PluginExecutor
+	pluginExecutorOptions map[string]plugin.PluginExecutorOptions
+
+	// cores is keyed by componentKey of upstreams and plugins
+	cores map[string]*componentCore
+	// reused is keyed by componentKey, the components are taken over from the old graph and already started
+	reused map[string]bool
+	// checkedWorkflows are checked before start to find what a query depends on, they are skipped by start
+	checkedWorkflows map[string]bool
+
+	maxDealTimeout time.Duration
+	// lookupHook is called on every lookup of upstreams, workflows and plugins, used to find what a query depends on
+	lookupHook func(kind string, tag string)
+
+	upstreamStack       *utils.Stack[adapter.Upstream]
+	pluginMatcherStack  *utils.Stack[adapter.PluginMatcher]
+	pluginExecutorStack *utils.Stack[adapter.PluginExecutor]
+}
+
+func componentKey(kind string, tag string) string {
+	return kind + "/" + tag
+}
+
+// componentCore is the adapter.Core of an upstream or a plugin. It follows the graph which owns the component,
+// so that a component reused after reload looks up the components of the new graph.
+// It also owns the context of the component, which lives as long as the component instead of the graph.
+type componentCore struct {
+	*Core
+	graph  atomic.Pointer[graph]
+	ctx    context.Context
+	cancel context.CancelFunc
+	// dependencies are the componentKeys looked up while the component is created and started
+	recording    atomic.Bool
+	lock         sync.Mutex
+	dependencies []string
+}
+
+func (g *graph) newComponentCore(key string) *componentCore {
+	ctx, cancel := context.WithCancel(g.Core.ctx)
+	cc := &componentCore{
+		Core:   g.Core,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	cc.recording.Store(true)
+	cc.graph.Store(g)
+	g.cores[key] = cc
+	return cc
+}
+
+func (cc *componentCore) lookup(kind string, tag string) *graph {
+	if cc.recording.Load() {
+		cc.lock.Lock()
+		cc.dependencies = append(cc.dependencies, componentKey(kind, tag))
+		cc.lock.Unlock()
+	}
+	g := cc.graph.Load()
+	if g.lookupHook != nil {
+		g.lookupHook(kind, tag)
+	}
+	return g
+}
+
+func (cc *componentCore) GetListener(tag string) adapter.Listener {
+	return cc.graph.Load().GetListener(tag)
+}
+
+func (cc *componentCore) GetListeners() []adapter.Listener {
+	return cc.graph.Load().GetListeners()
+}
+
+func (cc *componentCore) GetUpstream(tag string) adapter.Upstream {
+	return cc.lookup("upstream", tag).upstreamMap[tag]
+}
+
+func (cc *componentCore) GetUpstreams() []adapter.Upstream {
+	return cc.graph.Load().upstreams
+}
+
+func (cc *componentCore) GetWorkflow(tag string) adapter.Workflow {
+	return cc.lookup("workflow", tag).workflowMap[tag]
+}
+
+func (cc *componentCore) GetWorkflows() []adapter.Workflow {
+	return cc.graph.Load().workflows
+}
+
+func (cc *componentCore) GetPluginMatcher(tag string) adapter.PluginMatcher {
+	return cc.lookup("plugin-matcher", tag).pluginMatcherMap[tag]
+}
+
+func (cc *componentCore) GetPluginMatchers() []adapter.PluginMatcher {
+	return cc.graph.Load().pluginMatchers
+}
+
+func (cc *componentCore) GetPluginExecutor(tag string) adapter.PluginExecutor {
+	return cc.lookup("plugin-executor", tag).pluginExecutorMap[tag]
+}
+
+func (cc *componentCore) GetPluginExecutors() []adapter.PluginExecutor {
+	return cc.graph.Load().pluginExecutors
+}
+
+// newGraph creates all components of options. Listeners, upstreams and plugins of old with the same options are reused.
+func newGraph(c *Core, options Options, old *graph) (*graph, error) {
+	g := newEmptyGraph(c)
+	err := g.init(options, old)
+	if err != nil {
+		g.close(g.reused)
+		return nil, err
+	}
+	return g, nil
+}
+
+func newEmptyGraph(c *Core) *graph {
+	ctx, cancel := context.WithCancel(c.ctx)
+	return &graph{
+		Core:                  c,
+		ctx:                   ctx,
+		cancel:                cancel,
+		listenerMap:           make(map[string]adapter.Listener),
+		listenerOptions:       make(map[string]listener.Options),
+		upstreamMap:           make(map[string]adapter.Upstream),
+		upstreamOptions:       make(map[string]upstream.Options),
+		workflowMap:           make(map[string]adapter.Workflow),
+		pluginMatcherMap:      make(map[string]adapter.PluginMatcher),
+		pluginMatcherOptions:  make(map[string]plugin.PluginMatcherOptions),
+		pluginExecutorMap:     make(map[string]adapter.PluginExecutor),
+		pluginExecutorOptions: make(map[string]plugin.PluginExecutorOptions),
+		cores:                 make(map[string]*componentCore),
+		reused:                make(map[string]bool),
+		checkedWorkflows:      make(map[string]bool),
+	}
+}
+
+func (g *graph) init(options Options, old *graph) error {
+	if len(options.Upstreams) == 0 {
+		return fmt.Errorf("missing upstreams")
+	}
+	reusable := reusableUpstreams(options.Upstreams, old)
+	for i, upstreamOptions := range options.Upstreams {
+		var reuse *graph
+		if reusable[upstreamOptions.Tag] {
+			reuse = old
+		}
+		err := g.addUpstream(upstreamOptions, reuse)
+		if err != nil {
+			return fmt.Errorf("create upstream[%d] failed: %s", i, err)
+		}
+	}
+	var err error
+	g.upstreams, err = sortUpstream(g.upstreams)
+	if err != nil {
+		return fmt.Errorf("sort upstreams failed: %s", err)
+	}
+	if len(options.Workflows) == 0 {
+		return fmt.Errorf("missing workflows")
+	}
+	for i, workflowOptions := range options.Workflows {
+		err := g.addWorkflow(workflowOptions)
+		if err != nil {
+			return fmt.Errorf("create workflow[%d] failed: %s", i, err)
+		}
+	}
+	if len(options.Listeners) == 0 {
+		return fmt.Errorf("missing listeners")
+	}
+	for i, listenerOptions := range options.Listeners {
+		err := g.addListener(listenerOptions, old)
+		if err != nil {
+			return fmt.Errorf("create listener[%d] failed: %s", i, err)
+		}
+	}
+	for i, pluginMatcherOptions := range options.PluginMatchers {
+		err := g.addPluginMatcher(pluginMatcherOptions, old)
+		if err != nil {
+			return fmt.Errorf("create plugin matcher[%d] failed: %s", i, err)
+		}
+	}
+	for i, pluginExecutorOptions := range options.PluginExecutors {
+		err := g.addPluginExecutor(pluginExecutorOptions, old)
+		if err != nil {
+			return fmt.Errorf("create plugin executor[%d] failed: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// reusableUpstreams returns the tags of the upstreams of old with the same options, whose dependencies are reusable too
+func reusableUpstreams(options []upstream.Options, old *graph) map[string]bool {
+	reusable := make(map[string]bool)
+	if old == nil {
+		return reusable
+	}
+	for _, upstreamOptions := range options {
+		oldOptions, ok := old.upstreamOptions[upstreamOptions.Tag]
+		if ok && old.cores[componentKey("upstream", upstreamOptions.Tag)] != nil && reflect.DeepEqual(oldOptions, upstreamOptions) {
+			reusable[upstreamOptions.Tag] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for tag := range reusable {
+			for _, dependency := range old.upstreamMap[tag].Dependencies() {
+				if !reusable[dependency] {
+					delete(reusable, tag)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return reusable
+}
+
+// reusable reports whether every component looked up by the component of old is the same in g
+func (g *graph) reusable(old *graph, key string) bool {
+	cc := old.cores[key]
+	if cc == nil {
+		return false
+	}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	for _, dependency := range cc.dependencies {
+		kind, tag, _ := strings.Cut(dependency, "/")
+		if g.component(kind, tag) != old.component(kind, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// component returns nil if the component is not found
+func (g *graph) component(kind string, tag string) any {
+	var c any
+	switch kind {
+	case "upstream":
+		c = g.upstreamMap[tag]
+	case "workflow":
+		c = g.workflowMap[tag]
+	case "plugin-matcher":
+		c = g.pluginMatcherMap[tag]
+	case "plugin-executor":
+		c = g.pluginExecutorMap[tag]
+	}
+	return c
+}
+
+// reuse takes over the component of old, its core follows g after own is called
+func (g *graph) reuse(old *graph, key string) {
+	g.cores[key] = old.cores[key]
+	g.reused[key] = true
+}
+
+// own points the cores of all components to g
+func (g *graph) own() {
+	for _, cc := range g.cores {
+		cc.graph.Store(g)
+	}
+}
+
+// addUpstream reuses the upstream of old if old is not nil
+func (g *graph) addUpstream(options upstream.Options, old *graph) error {
+	tag := options.Tag
+	if tag == "" {
+		return fmt.Errorf("missing upstream tag")
+	}
+	_, ok := g.upstreamMap[tag]
+	if ok {
+		return fmt.Errorf("duplicate upstream tag: %s", tag)
+	}
+	key := componentKey("upstream", tag)
+	var u adapter.Upstream
+	if old != nil {
+		u = old.upstreamMap[tag]
+		g.reuse(old, key)
+	} else {
+		upstreamLogger := log.NewTagLogger(g.basicLogger, fmt.Sprintf("upstream/%s", tag), aurora.GreenFg)
+		cc := g.newComponentCore(key)
+		var err error
+		u, err = upstream.NewUpstream(cc.ctx, cc, upstreamLogger, tag, options)
+		if err != nil {
+			return err
+		}
+	}
+	g.upstreams = append(g.upstreams, u)
+	g.upstreamMap[tag] = u
+	g.upstreamOptions[tag] = options
+	return nil
+}
+
+func (g *graph) addWorkflow(options workflow.WorkflowOptions) error {
+	tag := options.Tag
+	if tag == "" {
+		return fmt.Errorf("missing workflow tag")
+	}
+	_, ok := g.workflowMap[tag]
+	if ok {
+		return fmt.Errorf("duplicate workflow tag: %s", tag)
+	}
+	workflowLogger := log.NewTagLogger(g.basicLogger, fmt.Sprintf("workflow/%s", tag), aurora.CyanFg)
+	w, err := workflow.NewWorkflow(g.ctx, g, workflowLogger, tag, options)
+	if err != nil {
+		return err
+	}
+	g.workflows = append(g.workflows, w)
+	g.workflowMap[tag] = w
+	return nil
+}
+
+func (g *graph) addListener(options listener.Options, old *graph) error {
+	tag := options.Tag
+	if tag == "" {
+		return fmt.Errorf("missing listener tag")
+	}
+	_, ok := g.listenerMap[tag]
+	if ok {
+		return fmt.Errorf("duplicate listener tag: %s", tag)
+	}
+	if g.workflowMap[options.Workflow] == nil {
+		return fmt.Errorf("workflow [%s] not found", options.Workflow)
+	}
+	dealTimeout := options.DealTimeout
+	if dealTimeout <= 0 {
+		dealTimeout = listener.DefaultDealTimeout
+	}
+	if dealTimeout > g.maxDealTimeout {
+		g.maxDealTimeout = dealTimeout
+	}
+	var l adapter.Listener
+	if old != nil {
+		// A listener which failed to start is created again
+		oldOptions, ok := old.listenerOptions[tag]
+		if ok && reflect.DeepEqual(oldOptions, options) && containsListener(g.Core.startedListeners, old.listenerMap[tag]) {
+			l = old.listenerMap[tag]
+		}
+	}
+	if l == nil {
+		// Listeners always use the core, so they follow the graph after reload
+		listenerLogger := log.NewTagLogger(g.basicLogger, fmt.Sprintf("listener/%s", tag), aurora.YellowFg)
+		var err error
+		l, err = listener.NewListener(g.Core.ctx, g.Core, listenerLogger, tag, options)
+		if err != nil {
+			return err
+		}
+	}
+	g.listeners = append(g.listeners, l)
+	g.listenerMap[tag] = l
+	g.listenerOptions[tag] = options
+	return nil
+}
+
+// addPluginMatcher reuses the plugin matcher of old with the same options, if what it looked up is the same in g.
+// The running args loaded by the old workflows are freed when the old graph is closed.
+func (g *graph) addPluginMatcher(options plugin.PluginMatcherOptions, old *graph) error {
+	tag := options.Tag
+	if tag == "" {
+		return fmt.Errorf("missing plugin matcher tag")
+	}
+	_, ok := g.pluginMatcherMap[tag]
+	if ok {
+		return fmt.Errorf("duplicate plugin matcher tag: %s", tag)
+	}
+	key := componentKey("plugin-matcher", tag)
+	var pm adapter.PluginMatcher
+	if old != nil {
+		oldOptions, ok := old.pluginMatcherOptions[tag]
+		if ok && reflect.DeepEqual(oldOptions, options) && g.reusable(old, key) {
+			pm = old.pluginMatcherMap[tag]
+			g.reuse(old, key)
+		}
+	}
+	if pm == nil {
+		pluginMatcherLogger := log.NewTagLogger(g.basicLogger, fmt.Sprintf("plugin-matcher/%s", tag), aurora.MagentaFg)
+		cc := g.newComponentCore(key)
+		var err error
+		pm, err = plugin.NewPluginMatcher(cc.ctx, cc, pluginMatcherLogger, tag, options.Type, options.Args)
+		if err != nil {
+			return err
+		}
+	}
+	g.pluginMatchers = append(g.pluginMatchers, pm)
+	g.pluginMatcherMap[tag] = pm
+	g.pluginMatcherOptions[tag] = options
+	return nil
+}
+
+// addPluginExecutor reuses the plugin executor of old with the same options, if what it looked up is the same in g
+func (g *graph) addPluginExecutor(options plugin.PluginExecutorOptions, old *graph) error {
+	tag := options.Tag
+	if tag == "" {
+		return fmt.Errorf("missing plugin executor tag")
+	}
+	_, ok := g.pluginExecutorMap[tag]
+	if ok {
+		return fmt.Errorf("duplicate plugin executor tag: %s", tag)
+	}
+	key := componentKey("plugin-executor", tag)
+	var pe adapter.PluginExecutor
+	if old != nil {
+		oldOptions, ok := old.pluginExecutorOptions[tag]
+		if ok && reflect.DeepEqual(oldOptions, options) && g.reusable(old, key) {
+			pe = old.pluginExecutorMap[tag]
+			g.reuse(old, key)
+		}
+	}
+	if pe == nil {
+		pluginExecutorLogger := log.NewTagLogger(g.basicLogger, fmt.Sprintf("plugin-executor/%s", tag), aurora.BlueFg)
+		cc := g.newComponentCore(key)
+		var err error
+		pe, err = plugin.NewPluginExecutor(cc.ctx, cc, pluginExecutorLogger, tag, options.Type, options.Args)
+		if err != nil {
+			return err
+		}
+	}
+	g.pluginExecutors = append(g.pluginExecutors, pe)
+	g.pluginExecutorMap[tag] = pe
+	g.pluginExecutorOptions[tag] = options
+	return nil
+}
+
+// start starts upstreams and plugins, then checks workflows. Reused components are already started.
+// On failure, everything started is closed.
+func (g *graph) start() error {
+	err := g.start0()
+	if err != nil {
+		g.close(g.reused)
+	}
+	return err
+}
+
+// started stops recording the dependencies of a component
+func (g *graph) started(key string) {
+	if cc := g.cores[key]; cc != nil {
+		cc.recording.Store(false)
+	}
+}
+
+func (g *graph) start0() error {
+	var err error
+	g.upstreamStack = utils.NewStack[adapter.Upstream](len(g.upstreams))
+	for _, u := range g.upstreams {
+		key := componentKey("upstream", u.Tag())
+		starter, isStarter := u.(adapter.Starter)
+		if isStarter && !g.reused[key] {
+			err = starter.Start()
+			if err != nil {
+				return fmt.Errorf("start upstream[%s] failed: %s", u.Tag(), err)
+			}
+		}
+		g.started(key)
+		g.upstreamStack.Push(u)
+	}
+	g.pluginMatcherStack = utils.NewStack[adapter.PluginMatcher](len(g.pluginMatchers))
+	for _, pm := range g.pluginMatchers {
+		key := componentKey("plugin-matcher", pm.Tag())
+		starter, isStarter := pm.(adapter.Starter)
+		if isStarter && !g.reused[key] {
+			err = starter.Start()
+			if err != nil {
+				return fmt.Errorf("start plugin matcher[%s] failed: %s", pm.Tag(), err)
+			}
+		}
+		g.started(key)
+		g.pluginMatcherStack.Push(pm)
+	}
+	g.pluginExecutorStack = utils.NewStack[adapter.PluginExecutor](len(g.pluginExecutors))
+	for _, pe := range g.pluginExecutors {
+		key := componentKey("plugin-executor", pe.Tag())
+		starter, isStarter := pe.(adapter.Starter)
+		if isStarter && !g.reused[key] {
+			err = starter.Start()
+			if err != nil {
+				return fmt.Errorf("start plugin executor[%s] failed: %s", pe.Tag(), err)
+			}
+		}
+		g.started(key)
+		g.pluginExecutorStack.Push(pe)
+	}
+	for _, w := range g.workflows {
+		if g.checkedWorkflows[w.Tag()] {
+			continue
+		}
+		err = w.Check()
+		if err != nil {
+			return fmt.Errorf("check workflow[%s] failed: %s", w.Tag(), err)
+		}
+	}
+	return nil
+}
+
+// close closes plugins and upstreams in reverse order of start and cancels their contexts.
+// Components with keys in keep are left running, they are reused by another graph. Listeners are owned by the core.
+func (g *graph) close(keep map[string]bool) {
+	var err error
+	if g.pluginExecutorStack != nil {
+		for g.pluginExecutorStack.Len() > 0 {
+			pe := g.pluginExecutorStack.Pop()
+			if keep[componentKey("plugin-executor", pe.Tag())] {
+				continue
+			}
+			closer, isCloser := pe.(adapter.Closer)
+			if isCloser {
+				err = closer.Close()
+				if err != nil {
+					g.coreLogger.Errorf("close plugin executor[%s] failed: %s", pe.Tag(), err)
+				} else {
+					g.coreLogger.Infof("close plugin executor[%s] success", pe.Tag())
+				}
+			}
+		}
+	}
+	if g.pluginMatcherStack != nil {
+		for g.pluginMatcherStack.Len() > 0 {
+			pm := g.pluginMatcherStack.Pop()
+			if keep[componentKey("plugin-matcher", pm.Tag())] {
+				continue
+			}
+			closer, isCloser := pm.(adapter.Closer)
+			if isCloser {
+				err = closer.Close()
+				if err != nil {
+					g.coreLogger.Errorf("close plugin matcher[%s] failed: %s", pm.Tag(), err)
+				} else {
+					g.coreLogger.Infof("close plugin matcher[%s] success", pm.Tag())
+				}
+			}
+		}
+	}
+	if g.upstreamStack != nil {
+		for g.upstreamStack.Len() > 0 {
+			u := g.upstreamStack.Pop()
+			if keep[componentKey("upstream", u.Tag())] {
+				continue
+			}
+			closer, isCloser := u.(adapter.Closer)
+			if isCloser {
+				err = closer.Close()
+				if err != nil {
+					g.coreLogger.Errorf("close upstream[%s] failed: %s", u.Tag(), err)
+				} else {
+					g.coreLogger.Infof("close upstream[%s] success", u.Tag())
+				}
+			}
+		}
+	}
+	// Components which are not started are canceled too
+	for key, cc := range g.cores {
+		if !keep[key] {
+			cc.cancel()
+		}
+	}
+	g.cancel()
+}
+
+func (g *graph) GetListener(tag string) adapter.Listener {
+	g.listenerLock.RLock()
+	defer g.listenerLock.RUnlock()
+	return g.listenerMap[tag]
+}
+
+func (g *graph) GetListeners() []adapter.Listener {
+	g.listenerLock.RLock()
+	defer g.listenerLock.RUnlock()
+	return g.listeners
+}
+
+func (g *graph) GetUpstream(tag string) adapter.Upstream {
+	if g.lookupHook != nil {
+		g.lookupHook("upstream", tag)
+	}
+	return g.upstreamMap[tag]
+}
+
+func (g *graph) GetUpstreams() []adapter.Upstream {
+	return g.upstreams
+}
+
+func (g *graph) GetWorkflow(tag string) adapter.Workflow {
+	if g.lookupHook != nil {
+		g.lookupHook("workflow", tag)
+	}
+	return g.workflowMap[tag]
+}
+
+func (g *graph) GetWorkflows() []adapter.Workflow {
+	return g.workflows
+}
+
+func (g *graph) GetPluginMatcher(tag string) adapter.PluginMatcher {
+	if g.lookupHook != nil {
+		g.lookupHook("plugin-matcher", tag)
+	}
+	return g.pluginMatcherMap[tag]
+}
+
+func (g *graph) GetPluginMatchers() []adapter.PluginMatcher {
+	return g.pluginMatchers
+}
+
+func (g *graph) GetPluginExecutor(tag string) adapter.PluginExecutor {
+	if g.lookupHook != nil {
+		g.lookupHook("plugin-executor", tag)
+	}
+	return g.pluginExecutorMap[tag]
+}
+
+func (g *graph) GetPluginExecutors() []adapter.PluginExecutor {
+	return g.pluginExecutors
+}
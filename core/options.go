@@ -6,6 +6,7 @@ import (
 	"github.com/rnetx/cdns/ntp"
 	"github.com/rnetx/cdns/plugin"
 	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
 	"github.com/rnetx/cdns/workflow"
 )
 
@@ -24,6 +25,16 @@ type LogOptions struct {
 	Disabled         bool   `yaml:"disabled,omitempty"`
 	Level            string `yaml:"level,omitempty"`
 	Output           string `yaml:"output,omitempty"`
+	Format           string `yaml:"format,omitempty"`
 	DisableTimestamp bool   `yaml:"disable-timestamp,omitempty"`
 	DisableColor     bool   `yaml:"disable-color,omitempty"`
+
+	Rotate *LogRotateOptions `yaml:"rotate,omitempty"`
+}
+
+type LogRotateOptions struct {
+	MaxSize    utils.ByteSize `yaml:"max-size,omitempty"`
+	MaxAge     utils.Duration `yaml:"max-age,omitempty"`
+	MaxBackups int            `yaml:"max-backups,omitempty"`
+	Compress   bool           `yaml:"compress,omitempty"`
 }
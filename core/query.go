@@ -0,0 +1,206 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/workflow"
+)
+
+type queryDependencies struct {
+	upstreams       map[string]struct{}
+	workflows       map[string]struct{}
+	pluginMatchers  map[string]struct{}
+	pluginExecutors map[string]struct{}
+}
+
+func newQueryDependencies() *queryDependencies {
+	return &queryDependencies{
+		upstreams:       make(map[string]struct{}),
+		workflows:       make(map[string]struct{}),
+		pluginMatchers:  make(map[string]struct{}),
+		pluginExecutors: make(map[string]struct{}),
+	}
+}
+
+func (d *queryDependencies) len() int {
+	return len(d.upstreams) + len(d.workflows) + len(d.pluginMatchers) + len(d.pluginExecutors)
+}
+
+func (d *queryDependencies) has(kind string, tag string) bool {
+	var ok bool
+	switch kind {
+	case "upstream":
+		_, ok = d.upstreams[tag]
+	case "workflow":
+		_, ok = d.workflows[tag]
+	case "plugin-matcher":
+		_, ok = d.pluginMatchers[tag]
+	case "plugin-executor":
+		_, ok = d.pluginExecutors[tag]
+	}
+	return ok
+}
+
+func (d *queryDependencies) add(kind string, tag string) {
+	switch kind {
+	case "upstream":
+		d.upstreams[tag] = struct{}{}
+	case "workflow":
+		d.workflows[tag] = struct{}{}
+	case "plugin-matcher":
+		d.pluginMatchers[tag] = struct{}{}
+	case "plugin-executor":
+		d.pluginExecutors[tag] = struct{}{}
+	}
+}
+
+// Query runs dnsCtx through the upstream or workflow, only the components it depends on are started.
+// Only one of upstreamTag and workflowTag should be set. Query can not be used with Run.
+func (c *Core) Query(dnsCtx *adapter.DNSContext, upstreamTag string, workflowTag string) error {
+	g := c.graph.Load()
+	d := newQueryDependencies()
+	switch {
+	case upstreamTag != "":
+		if g.upstreamMap[upstreamTag] == nil {
+			return fmt.Errorf("upstream [%s] not found", upstreamTag)
+		}
+		d.add("upstream", upstreamTag)
+	case workflowTag != "":
+		if g.workflowMap[workflowTag] == nil {
+			return fmt.Errorf("workflow [%s] not found", workflowTag)
+		}
+		d.add("workflow", workflowTag)
+	default:
+		return fmt.Errorf("missing upstream or workflow")
+	}
+	err := g.findDependencies(d)
+	if err != nil {
+		g.close(nil)
+		return err
+	}
+	g.prune(d)
+	err = g.start()
+	if err != nil {
+		return err
+	}
+	defer g.close(nil)
+	ctx := adapter.SaveLogContext(dnsCtx.Context(), dnsCtx)
+	if upstreamTag != "" {
+		u := g.upstreamMap[upstreamTag]
+		respMsg, err := u.Exchange(ctx, dnsCtx.ReqMsg())
+		if err != nil {
+			return fmt.Errorf("upstream [%s] exchange failed: %s", upstreamTag, err)
+		}
+		dnsCtx.SetRespMsg(respMsg)
+		dnsCtx.SetRespUpstreamTag(upstreamTag)
+		return nil
+	}
+	_, err = g.workflowMap[workflowTag].Exec(ctx, dnsCtx)
+	if err != nil {
+		return fmt.Errorf("workflow [%s] exec failed: %s", workflowTag, err)
+	}
+	return nil
+}
+
+// findDependencies adds what the components in d depend on to d, until nothing is added.
+// Lookups of the constructors are recorded by the component cores, lookups of Start are recorded by validating the components,
+// and references of workflows, including upstreams used by plugin running args, are recorded by checking them.
+// Workflows can only be checked once, so the checked workflows are skipped by start.
+func (g *graph) findDependencies(d *queryDependencies) error {
+	g.lookupHook = d.add
+	defer func() {
+		g.lookupHook = nil
+	}()
+	validated := make(map[string]bool)
+	for {
+		n := d.len()
+		for tag := range d.workflows {
+			if g.checkedWorkflows[tag] {
+				continue
+			}
+			g.checkedWorkflows[tag] = true
+			w, ok := g.workflowMap[tag].(*workflow.Workflow)
+			if !ok {
+				continue
+			}
+			errs := w.CheckAll()
+			if len(errs) > 0 {
+				return fmt.Errorf("check workflow[%s] failed: %s", tag, errs[0])
+			}
+		}
+		for key, cc := range g.cores {
+			kind, tag, _ := strings.Cut(key, "/")
+			if !d.has(kind, tag) {
+				continue
+			}
+			if !validated[key] {
+				validated[key] = true
+				err := adapter.Validate(g.component(kind, tag))
+				if err != nil {
+					return fmt.Errorf("validate %s[%s] failed: %s", kind, tag, err)
+				}
+			}
+			cc.lock.Lock()
+			dependencies := cc.dependencies
+			cc.lock.Unlock()
+			for _, dependency := range dependencies {
+				kind, tag, _ := strings.Cut(dependency, "/")
+				d.add(kind, tag)
+			}
+		}
+		for tag := range d.upstreams {
+			u := g.upstreamMap[tag]
+			if u == nil {
+				continue
+			}
+			for _, dependency := range u.Dependencies() {
+				d.add("upstream", dependency)
+			}
+		}
+		if d.len() == n {
+			return nil
+		}
+	}
+}
+
+// prune removes the components not in d, so that start only starts what a query depends on
+func (g *graph) prune(d *queryDependencies) {
+	upstreams := make([]adapter.Upstream, 0, len(d.upstreams))
+	for _, u := range g.upstreams {
+		if _, ok := d.upstreams[u.Tag()]; ok {
+			upstreams = append(upstreams, u)
+		} else {
+			delete(g.upstreamMap, u.Tag())
+		}
+	}
+	g.upstreams = upstreams
+	workflows := make([]adapter.Workflow, 0, len(d.workflows))
+	for _, w := range g.workflows {
+		if _, ok := d.workflows[w.Tag()]; ok {
+			workflows = append(workflows, w)
+		} else {
+			delete(g.workflowMap, w.Tag())
+		}
+	}
+	g.workflows = workflows
+	pluginMatchers := make([]adapter.PluginMatcher, 0, len(d.pluginMatchers))
+	for _, pm := range g.pluginMatchers {
+		if _, ok := d.pluginMatchers[pm.Tag()]; ok {
+			pluginMatchers = append(pluginMatchers, pm)
+		} else {
+			delete(g.pluginMatcherMap, pm.Tag())
+		}
+	}
+	g.pluginMatchers = pluginMatchers
+	pluginExecutors := make([]adapter.PluginExecutor, 0, len(d.pluginExecutors))
+	for _, pe := range g.pluginExecutors {
+		if _, ok := d.pluginExecutors[pe.Tag()]; ok {
+			pluginExecutors = append(pluginExecutors, pe)
+		} else {
+			delete(g.pluginExecutorMap, pe.Tag())
+		}
+	}
+	g.pluginExecutors = pluginExecutors
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/netip"
 	"os"
@@ -18,6 +20,7 @@ import (
 	"github.com/rnetx/cdns/constant"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
 
 	"github.com/miekg/dns"
 )
@@ -120,6 +123,7 @@ func newTLSConfig(options TLSOptions) (*tls.Config, error) {
 type GenericListener struct {
 	adapter.Listener
 	dealTimeout time.Duration
+	metrics     *listenerMetrics
 }
 
 func (l *GenericListener) Start() error {
@@ -144,16 +148,72 @@ func (l *GenericListener) Handle(ctx context.Context, req *dns.Msg, clientAddr n
 	return l.Listener.Handle(ctx, req, clientAddr)
 }
 
+func (l *GenericListener) CollectMetrics(w *metrics.Writer) {
+	tag := l.Tag()
+	l.metrics.requests.Range(func(rcode string, value uint64) {
+		w.Counter("cdns_listener_requests_total", "Total number of requests handled by the listener.", value, metrics.L("listener", tag), metrics.L("rcode", rcode))
+	})
+	w.Histogram("cdns_listener_request_duration_seconds", "Request handling duration of the listener.", l.metrics.duration, metrics.L("listener", tag))
+}
+
+type listenerMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.Histogram
+}
+
+func newListenerMetrics() *listenerMetrics {
+	return &listenerMetrics{
+		requests: metrics.NewCounterVec(),
+		duration: metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+	}
+}
+
+// handleContext is saved in the listener context by NewListener, listeners handle requests with their own ctx
+type handleContext struct {
+	traceSampleRate float64
+	metrics         *listenerMetrics
+}
+
+type handleContextKey struct{}
+
+func rcodeString(resp *dns.Msg) string {
+	if resp == nil {
+		return "NONE"
+	}
+	s, ok := dns.RcodeToString[resp.Rcode]
+	if !ok {
+		return strconv.Itoa(resp.Rcode)
+	}
+	return s
+}
+
 func reqMessageInfo(req *dns.Msg) string {
 	return fmt.Sprintf("%s %s %s", dns.ClassToString[req.Question[0].Qclass], dns.TypeToString[req.Question[0].Qtype], req.Question[0].Name)
 }
 
-func listenerHandle(ctx context.Context, listener string, logger log.Logger, workflow adapter.Workflow, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
+func listenerHandle(ctx context.Context, listener string, logger log.Logger, workflow adapter.Workflow, req *dns.Msg, clientAddr netip.AddrPort) (resp *dns.Msg) {
+	handleCtx, _ := ctx.Value(handleContextKey{}).(*handleContext)
+	if handleCtx != nil && handleCtx.metrics != nil {
+		startTime := time.Now()
+		defer func() {
+			handleCtx.metrics.duration.Observe(time.Since(startTime))
+			handleCtx.metrics.requests.Inc(rcodeString(resp))
+		}()
+	}
 	if len(req.Question) == 0 {
 		logger.Error(ctx, "invalid request: no question")
 		return nil
 	}
 	dnsCtx := adapter.NewDNSContext(ctx, listener, clientAddr.Addr(), req)
+	if handleCtx != nil && handleCtx.traceSampleRate > 0 && rand.Float64() < handleCtx.traceSampleRate {
+		dnsCtx.SetTrace(adapter.NewTrace())
+		defer func() {
+			raw, err := json.Marshal(dnsCtx.Trace().Events())
+			if err == nil {
+				logger.InfofContext(ctx, "trace: %s", raw)
+			}
+		}()
+	}
 	ctx = dnsCtx.Context()
 	ctx = adapter.SaveLogContext(ctx, dnsCtx)
 	messageInfo := reqMessageInfo(req)
@@ -172,7 +232,7 @@ func listenerHandle(ctx context.Context, listener string, logger log.Logger, wor
 		return nil
 	}
 	logger.InfofContext(ctx, "handle request success: %s", messageInfo)
-	resp := dnsCtx.RespMsg()
+	resp = dnsCtx.RespMsg()
 	if resp == nil {
 		// Empty Response
 		resp = &dns.Msg{}
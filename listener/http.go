@@ -50,7 +50,6 @@ type HTTPListener struct {
 
 	listen       string
 	workflowTag  string
-	workflow     adapter.Workflow
 	useHTTP3     bool
 	path         string
 	realIPHeader string
@@ -147,11 +146,9 @@ func (l *HTTPListener) Type() string {
 }
 
 func (l *HTTPListener) Start() error {
-	w := l.core.GetWorkflow(l.workflowTag)
-	if w == nil {
+	if l.core.GetWorkflow(l.workflowTag) == nil {
 		return fmt.Errorf("create http listener failed: workflow [%s] not found", l.workflowTag)
 	}
-	l.workflow = w
 	var err error
 	if !l.useHTTP3 {
 		httpServer := &http.Server{
@@ -350,5 +347,5 @@ func (l *HTTPListener) realIP(w http.ResponseWriter, r *http.Request) (netip.Add
 }
 
 func (l *HTTPListener) Handle(ctx context.Context, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
-	return listenerHandle(ctx, l.tag, l.logger, l.workflow, req, clientAddr)
+	return listenerHandle(ctx, l.tag, l.logger, l.core.GetWorkflow(l.workflowTag), req, clientAddr)
 }
@@ -11,10 +11,11 @@ import (
 )
 
 type Options struct {
-	Tag         string
-	Type        string
-	DealTimeout time.Duration
-	Workflow    string
+	Tag             string
+	Type            string
+	DealTimeout     time.Duration
+	Workflow        string
+	TraceSampleRate float64
 
 	UDPOptions  *UDPListenerOptions
 	TCPOptions  *TCPListenerOptions
@@ -24,10 +25,11 @@ type Options struct {
 }
 
 type _Options struct {
-	Tag         string         `yaml:"tag"`
-	Type        string         `yaml:"type"`
-	DealTimeout utils.Duration `yaml:"deal-timeout"`
-	Workflow    string         `yaml:"workflow"`
+	Tag             string         `yaml:"tag"`
+	Type            string         `yaml:"type"`
+	DealTimeout     utils.Duration `yaml:"deal-timeout"`
+	Workflow        string         `yaml:"workflow"`
+	TraceSampleRate float64        `yaml:"trace-sample-rate"`
 }
 
 func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -64,14 +66,41 @@ func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	o.Tag = _o.Tag
 	o.DealTimeout = time.Duration(_o.DealTimeout)
 	o.Workflow = _o.Workflow
+	if _o.TraceSampleRate < 0 || _o.TraceSampleRate > 1 {
+		return fmt.Errorf("invalid trace-sample-rate: %v", _o.TraceSampleRate)
+	}
+	o.TraceSampleRate = _o.TraceSampleRate
 	return nil
 }
 
+// ListenAddress returns the listen address of the listener type
+func (o Options) ListenAddress() string {
+	switch {
+	case o.UDPOptions != nil:
+		return o.UDPOptions.Listen
+	case o.TCPOptions != nil:
+		return o.TCPOptions.Listen
+	case o.TLSOptions != nil:
+		return o.TLSOptions.Listen
+	case o.HTTPOptions != nil:
+		return o.HTTPOptions.Listen
+	case o.QUICOptions != nil:
+		return o.QUICOptions.Listen
+	}
+	return ""
+}
+
 func NewListener(ctx context.Context, core adapter.Core, logger log.Logger, tag string, options Options) (adapter.Listener, error) {
 	var (
 		l   adapter.Listener
 		err error
 	)
+	// Listeners handle requests with ctx, metrics and sampled traces are recorded in listenerHandle
+	handleCtx := &handleContext{
+		traceSampleRate: options.TraceSampleRate,
+		metrics:         newListenerMetrics(),
+	}
+	ctx = context.WithValue(ctx, handleContextKey{}, handleCtx)
 	switch options.Type {
 	case UDPListenerType:
 		l, err = NewUDPListener(ctx, core, logger, tag, *options.UDPOptions, options.Workflow)
@@ -95,6 +124,7 @@ func NewListener(ctx context.Context, core adapter.Core, logger log.Logger, tag
 	}
 	l = &GenericListener{
 		dealTimeout: dealTimeout,
+		metrics:     handleCtx.metrics,
 		Listener:    l,
 	}
 	return l, nil
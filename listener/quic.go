@@ -44,7 +44,6 @@ type QUICListener struct {
 
 	listen      string
 	workflowTag string
-	workflow    adapter.Workflow
 
 	idleTimeout   time.Duration
 	maxConnection int
@@ -105,11 +104,9 @@ func (l *QUICListener) Type() string {
 }
 
 func (l *QUICListener) Start() error {
-	w := l.core.GetWorkflow(l.workflowTag)
-	if w == nil {
+	if l.core.GetWorkflow(l.workflowTag) == nil {
 		return fmt.Errorf("create quic listener failed: workflow [%s] not found", l.workflowTag)
 	}
-	l.workflow = w
 	l.limiter = utils.NewLimiter(l.maxConnection)
 	var err error
 	if l.quicConfig.Allow0RTT {
@@ -249,5 +246,5 @@ func (l *QUICListener) serveStream(quicConn quic.Connection, stream quic.Stream,
 }
 
 func (l *QUICListener) Handle(ctx context.Context, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
-	return listenerHandle(ctx, l.tag, l.logger, l.workflow, req, clientAddr)
+	return listenerHandle(ctx, l.tag, l.logger, l.core.GetWorkflow(l.workflowTag), req, clientAddr)
 }
@@ -38,7 +38,6 @@ type TCPListener struct {
 
 	listen      string
 	workflowTag string
-	workflow    adapter.Workflow
 
 	idleTimeout   time.Duration
 	maxConnection int
@@ -87,11 +86,9 @@ func (l *TCPListener) Type() string {
 }
 
 func (l *TCPListener) Start() error {
-	w := l.core.GetWorkflow(l.workflowTag)
-	if w == nil {
+	if l.core.GetWorkflow(l.workflowTag) == nil {
 		return fmt.Errorf("create tcp listener failed: workflow [%s] not found", l.workflowTag)
 	}
-	l.workflow = w
 	l.limiter = utils.NewLimiter(l.maxConnection)
 	var err error
 	l.tcpListener, err = net.Listen("tcp", l.listen)
@@ -193,5 +190,5 @@ func (l *TCPListener) serve(conn net.Conn) {
 }
 
 func (l *TCPListener) Handle(ctx context.Context, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
-	return listenerHandle(ctx, l.tag, l.logger, l.workflow, req, clientAddr)
+	return listenerHandle(ctx, l.tag, l.logger, l.core.GetWorkflow(l.workflowTag), req, clientAddr)
 }
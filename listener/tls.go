@@ -40,7 +40,6 @@ type TLSListener struct {
 
 	listen      string
 	workflowTag string
-	workflow    adapter.Workflow
 
 	idleTimeout   time.Duration
 	maxConnection int
@@ -95,11 +94,9 @@ func (l *TLSListener) Type() string {
 }
 
 func (l *TLSListener) Start() error {
-	w := l.core.GetWorkflow(l.workflowTag)
-	if w == nil {
+	if l.core.GetWorkflow(l.workflowTag) == nil {
 		return fmt.Errorf("create tls listener failed: workflow [%s] not found", l.workflowTag)
 	}
-	l.workflow = w
 	l.limiter = utils.NewLimiter(l.maxConnection)
 	var err error
 	l.tlsListener, err = tls.Listen("tcp", l.listen, l.tlsConfig.Clone())
@@ -201,5 +198,5 @@ func (l *TLSListener) serve(conn net.Conn) {
 }
 
 func (l *TLSListener) Handle(ctx context.Context, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
-	return listenerHandle(ctx, l.tag, l.logger, l.workflow, req, clientAddr)
+	return listenerHandle(ctx, l.tag, l.logger, l.core.GetWorkflow(l.workflowTag), req, clientAddr)
 }
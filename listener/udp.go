@@ -38,7 +38,6 @@ type UDPListener struct {
 
 	listen      string
 	workflowTag string
-	workflow    adapter.Workflow
 
 	maxConnection int
 
@@ -81,11 +80,9 @@ func (l *UDPListener) Type() string {
 }
 
 func (l *UDPListener) Start() error {
-	w := l.core.GetWorkflow(l.workflowTag)
-	if w == nil {
+	if l.core.GetWorkflow(l.workflowTag) == nil {
 		return fmt.Errorf("create udp listener failed: workflow [%s] not found", l.workflowTag)
 	}
-	l.workflow = w
 	l.limiter = utils.NewLimiter(l.maxConnection)
 	udpAddr, err := net.ResolveUDPAddr("udp", l.listen)
 	if err != nil {
@@ -152,7 +149,7 @@ func (l *UDPListener) serve(buf []byte, addr netip.AddrPort) {
 }
 
 func (l *UDPListener) Handle(ctx context.Context, req *dns.Msg, clientAddr netip.AddrPort) *dns.Msg {
-	return listenerHandle(ctx, l.tag, l.logger, l.workflow, req, clientAddr)
+	return listenerHandle(ctx, l.tag, l.logger, l.core.GetWorkflow(l.workflowTag), req, clientAddr)
 }
 
 // from mosdns(https://github.com/IrineSistiana/mosdns), thank for @IrineSistiana
@@ -12,6 +12,7 @@ import (
 type BroadcastMessage struct {
 	Time            time.Time     `json:"time"`
 	Level           Level         `json:"level"`
+	Tag             string        `json:"tag,omitempty"`
 	Message         string        `json:"message"`
 	ContextID       uint32        `json:"context_id,omitempty"`
 	ContextDuration time.Duration `json:"context_duration,omitempty"`
@@ -47,6 +48,10 @@ func (s *BroadcastLogger) disableColor() bool {
 	return s.logger.disableColor()
 }
 
+func (s *BroadcastLogger) jsonFormat() bool {
+	return s.logger.jsonFormat()
+}
+
 func (s *BroadcastLogger) print(level Level, msg string) {
 	go s.sendToBroadcast(&BroadcastMessage{
 		Time:    time.Now(),
@@ -69,6 +74,7 @@ func (s *BroadcastLogger) printContext(ctx context.Context, level Level, msg str
 	go s.sendToBroadcast(&BroadcastMessage{
 		Time:            time.Now(),
 		Level:           level,
+		Tag:             loadTag(ctx),
 		Message:         msg,
 		ContextID:       contextID,
 		ContextDuration: contextDuration,
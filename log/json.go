@@ -0,0 +1,96 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+)
+
+var (
+	_ basicLogger = (*JSONLogger)(nil)
+	_ Logger      = (*JSONLogger)(nil)
+)
+
+type jsonMessage struct {
+	Time            *time.Time `json:"time,omitempty"`
+	Level           Level      `json:"level"`
+	Tag             string     `json:"tag,omitempty"`
+	ContextID       uint32     `json:"context_id,omitempty"`
+	ContextDuration int64      `json:"context_duration,omitempty"` // ms
+	Message         string     `json:"message"`
+}
+
+// JSONLogger writes one JSON object per line, tags of TagLogger are saved in a separate field instead of the message
+type JSONLogger struct {
+	writer           io.Writer
+	_level           Level
+	disableTimestamp bool
+	timeFunc         func() time.Time
+	Logger
+}
+
+func NewJSONLogger(writer io.Writer, level Level, disableTimestamp bool) Logger {
+	j := &JSONLogger{
+		writer:           writer,
+		_level:           level,
+		disableTimestamp: disableTimestamp,
+	}
+	j.Logger = newExportLogger(j)
+	return j
+}
+
+func (l *JSONLogger) level() Level {
+	return l._level
+}
+
+func (l *JSONLogger) disableColor() bool {
+	return true
+}
+
+func (l *JSONLogger) jsonFormat() bool {
+	return true
+}
+
+func (l *JSONLogger) print(level Level, msg string) {
+	l.printContext(context.Background(), level, msg)
+}
+
+func (l *JSONLogger) printContext(ctx context.Context, level Level, msg string) {
+	if level < l._level {
+		return
+	}
+	m := jsonMessage{
+		Level:   level,
+		Tag:     loadTag(ctx),
+		Message: msg,
+	}
+	if !l.disableTimestamp {
+		t := l.timeNow()
+		m.Time = &t
+	}
+	logContext := adapter.LoadLogContext(ctx)
+	if logContext != nil {
+		m.ContextID = logContext.ID()
+		m.ContextDuration = logContext.Duration().Milliseconds()
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	l.writer.Write(append(raw, '\n'))
+}
+
+func (l *JSONLogger) SetTimeFunc(f func() time.Time) {
+	l.timeFunc = f
+}
+
+func (l *JSONLogger) timeNow() time.Time {
+	timeFunc := l.timeFunc
+	if timeFunc == nil {
+		timeFunc = time.Now
+	}
+	return timeFunc()
+}
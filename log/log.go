@@ -42,6 +42,7 @@ type SetTimeFuncInterface interface {
 type basicLogger interface {
 	level() Level
 	disableColor() bool
+	jsonFormat() bool
 
 	print(level Level, msg string)
 	printContext(ctx context.Context, level Level, msg string)
@@ -23,6 +23,10 @@ func (l *NopLogger) disableColor() bool {
 	return true
 }
 
+func (l *NopLogger) jsonFormat() bool {
+	return false
+}
+
 func (l *NopLogger) print(_ Level, _ string) {}
 
 func (l *NopLogger) printContext(_ context.Context, _ Level, _ string) {}
@@ -51,6 +51,10 @@ func (l *SimpleLogger) disableColor() bool {
 	return l._disableColor
 }
 
+func (l *SimpleLogger) jsonFormat() bool {
+	return false
+}
+
 func (l *SimpleLogger) print(level Level, msg string) {
 	if level < l._level {
 		return
@@ -39,10 +39,18 @@ func (t *TagLogger) disableColor() bool {
 	return t.logger.disableColor()
 }
 
+func (t *TagLogger) jsonFormat() bool {
+	return t.logger.jsonFormat()
+}
+
 func (t *TagLogger) print(level Level, msg string) {
 	if level < t.logger.level() {
 		return
 	}
+	if t.logger.jsonFormat() {
+		t.logger.printContext(saveTag(context.Background(), t.tag), level, msg)
+		return
+	}
 	m := ""
 	if !t.logger.disableColor() && t.color != 0 {
 		m += fmt.Sprintf("[%s] ", aurora.Colorize(t.tag, t.color))
@@ -57,6 +65,10 @@ func (t *TagLogger) printContext(ctx context.Context, level Level, msg string) {
 	if level < t.logger.level() {
 		return
 	}
+	if t.logger.jsonFormat() {
+		t.logger.printContext(saveTag(ctx, t.tag), level, msg)
+		return
+	}
 	m := ""
 	if !t.logger.disableColor() && t.color != 0 {
 		m += fmt.Sprintf("[%s] ", aurora.Colorize(t.tag, t.color))
@@ -74,3 +86,18 @@ func (t *TagLogger) printContext(ctx context.Context, level Level, msg string) {
 	m += msg
 	t.logger.print(level, m)
 }
+
+type tagContextKey struct{}
+
+// saveTag saves the tag for loggers in JSON format, tags of nested TagLoggers are joined with `/`
+func saveTag(ctx context.Context, tag string) context.Context {
+	if parent := loadTag(ctx); parent != "" {
+		tag = tag + "/" + parent
+	}
+	return context.WithValue(ctx, tagContextKey{}, tag)
+}
+
+func loadTag(ctx context.Context) string {
+	tag, _ := ctx.Value(tagContextKey{}).(string)
+	return tag
+}
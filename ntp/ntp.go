@@ -45,7 +45,6 @@ type NTPServer struct {
 	ipCacheDeadline time.Time
 
 	upstreamTag string
-	upstream    adapter.Upstream
 	clockOffset time.Duration
 }
 
@@ -85,11 +84,9 @@ func NewNTPServer(ctx context.Context, core adapter.Core, logger log.Logger, opt
 
 func (s *NTPServer) Start() error {
 	if s.upstreamTag != "" {
-		u := s.core.GetUpstream(s.upstreamTag)
-		if u == nil {
+		if s.core.GetUpstream(s.upstreamTag) == nil {
 			return fmt.Errorf("upstream [%s] not found", s.upstreamTag)
 		}
-		s.upstream = u
 	}
 	err := s.update(s.ctx)
 	if err != nil {
@@ -104,6 +101,10 @@ func (s *NTPServer) Start() error {
 	return nil
 }
 
+func (s *NTPServer) UpstreamTag() string {
+	return s.upstreamTag
+}
+
 func (s *NTPServer) Close() error {
 	s.loopCancel()
 	<-s.closeDone
@@ -139,7 +140,12 @@ func (s *NTPServer) newConn(ctx context.Context) (conn net.Conn, err error) {
 	if socksAddr.IsDomain() && (len(s.ips) == 0 || s.ipCacheDeadline.Before(time.Now())) {
 		reqMsg := &dns.Msg{}
 		reqMsg.SetQuestion(dns.Fqdn(socksAddr.Domain()), dns.TypeA)
-		respMsg, err := s.upstream.Exchange(ctx, reqMsg)
+		// Upstream may be replaced by reload
+		u := s.core.GetUpstream(s.upstreamTag)
+		if u == nil {
+			return nil, fmt.Errorf("upstream [%s] not found", s.upstreamTag)
+		}
+		respMsg, err := u.Exchange(ctx, reqMsg)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,304 @@
+package dnstap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/constant"
+	"github.com/rnetx/cdns/listener"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
+)
+
+const Type = "dnstap"
+
+func init() {
+	plugin.RegisterPluginExecutor(Type, NewDnstap)
+}
+
+const (
+	DefaultBufferSize     = 1024
+	DefaultNetwork        = "unix"
+	DefaultReconnectDelay = time.Second
+	maxReconnectDelay     = 30 * time.Second
+	connectTimeout        = 5 * time.Second
+	dropWarnInterval      = time.Minute
+)
+
+type Args struct {
+	Network    string `json:"network"`
+	Address    string `json:"address"`
+	Identity   string `json:"identity"`
+	BufferSize int    `json:"buffer-size"`
+	Forwarder  bool   `json:"forwarder"`
+}
+
+var (
+	_ adapter.PluginExecutor   = (*Dnstap)(nil)
+	_ adapter.Starter          = (*Dnstap)(nil)
+	_ adapter.Closer           = (*Dnstap)(nil)
+	_ adapter.MetricsCollector = (*Dnstap)(nil)
+)
+
+type Dnstap struct {
+	ctx    context.Context
+	core   adapter.Core
+	tag    string
+	logger log.Logger
+
+	network   string
+	address   string
+	identity  []byte
+	version   []byte
+	forwarder bool
+
+	queue      chan []byte
+	loopCtx    context.Context
+	loopCancel context.CancelFunc
+	closeDone  chan struct{}
+
+	sent         atomic.Uint64
+	dropped      atomic.Uint64
+	lastDropWarn atomic.Int64
+}
+
+func NewDnstap(ctx context.Context, core adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
+	d := &Dnstap{
+		ctx:    ctx,
+		core:   core,
+		tag:    tag,
+		logger: logger,
+	}
+	var a Args
+	err := utils.JsonDecode(args, &a)
+	if err != nil {
+		return nil, fmt.Errorf("parse args failed: %w", err)
+	}
+	switch a.Network {
+	case "":
+		d.network = DefaultNetwork
+	case "unix", "tcp":
+		d.network = a.Network
+	default:
+		return nil, fmt.Errorf("invalid network: %s", a.Network)
+	}
+	if a.Address == "" {
+		return nil, fmt.Errorf("missing address")
+	}
+	d.address = a.Address
+	if a.Identity != "" {
+		d.identity = []byte(a.Identity)
+	} else {
+		hostname, err := os.Hostname()
+		if err == nil {
+			d.identity = []byte(hostname)
+		}
+	}
+	d.version = []byte("cdns " + constant.Version)
+	if a.BufferSize < 0 {
+		return nil, fmt.Errorf("invalid buffer-size: %d", a.BufferSize)
+	}
+	if a.BufferSize == 0 {
+		a.BufferSize = DefaultBufferSize
+	}
+	d.queue = make(chan []byte, a.BufferSize)
+	d.forwarder = a.Forwarder
+	return d, nil
+}
+
+func (d *Dnstap) Tag() string {
+	return d.tag
+}
+
+func (d *Dnstap) Type() string {
+	return Type
+}
+
+func (d *Dnstap) Start() error {
+	d.loopCtx, d.loopCancel = context.WithCancel(d.ctx)
+	d.closeDone = make(chan struct{}, 1)
+	go d.loopWrite()
+	return nil
+}
+
+func (d *Dnstap) Close() error {
+	d.loopCancel()
+	<-d.closeDone
+	close(d.closeDone)
+	return nil
+}
+
+func (d *Dnstap) loopWrite() {
+	defer func() {
+		select {
+		case d.closeDone <- struct{}{}:
+		default:
+		}
+	}()
+	delay := DefaultReconnectDelay
+	for {
+		w, err := d.connect()
+		if err == nil {
+			delay = DefaultReconnectDelay
+			err = d.writeFrames(w)
+			if d.loopCtx.Err() != nil {
+				w.close()
+				return
+			}
+			w.conn.Close()
+			d.logger.Errorf("write dnstap frames failed: %s", err)
+		} else {
+			d.logger.Errorf("connect to dnstap collector failed: %s", err)
+		}
+		select {
+		case <-d.loopCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (d *Dnstap) connect() (*frameWriter, error) {
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(d.loopCtx, d.network, d.address)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newFrameWriter(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	d.logger.Infof("connected to dnstap collector: %s", d.address)
+	return w, nil
+}
+
+func (d *Dnstap) writeFrames(w *frameWriter) error {
+	for {
+		select {
+		case <-d.loopCtx.Done():
+			return nil
+		case frame := <-d.queue:
+			err := w.writeFrame(frame)
+			if err != nil {
+				return err
+			}
+			d.sent.Add(1)
+			if len(d.queue) == 0 {
+				err = w.flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (d *Dnstap) LoadRunningArgs(_ context.Context, _ any) (uint16, error) {
+	return 0, nil
+}
+
+func (d *Dnstap) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, _ uint16) (adapter.ReturnMode, error) {
+	reqMsg := dnsCtx.ReqMsg()
+	if reqMsg == nil {
+		d.logger.DebugContext(ctx, "request message is nil")
+		return adapter.ReturnModeContinue, nil
+	}
+	queryMessage, err := reqMsg.Pack()
+	if err != nil {
+		d.logger.DebugfContext(ctx, "pack request message failed: %s", err)
+		return adapter.ReturnModeContinue, nil
+	}
+	m := &message{
+		typ:            messageTypeClientQuery,
+		socketProtocol: d.socketProtocol(dnsCtx.Listener()),
+		queryAddress:   dnsCtx.ClientIP(),
+		queryTime:      dnsCtx.InitTime(),
+		queryMessage:   queryMessage,
+	}
+	d.send(ctx, nil, m)
+	respMsg := dnsCtx.RespMsg()
+	if respMsg == nil {
+		return adapter.ReturnModeContinue, nil
+	}
+	responseMessage, err := respMsg.Pack()
+	if err != nil {
+		d.logger.DebugfContext(ctx, "pack response message failed: %s", err)
+		return adapter.ReturnModeContinue, nil
+	}
+	responseTime := time.Now()
+	if d.forwarder && dnsCtx.RespUpstreamTag() != "" {
+		// The exchange time is not recorded, the time of the client request is used
+		extra := []byte("upstream=" + dnsCtx.RespUpstreamTag())
+		d.send(ctx, extra, &message{
+			typ:          messageTypeForwarderQuery,
+			queryTime:    dnsCtx.InitTime(),
+			queryMessage: queryMessage,
+		})
+		d.send(ctx, extra, &message{
+			typ:          messageTypeForwarderResponse,
+			queryTime:    dnsCtx.InitTime(),
+			queryMessage: queryMessage,
+			responseTime: responseTime,
+			responseMsg:  responseMessage,
+		})
+	}
+	m.typ = messageTypeClientResponse
+	m.responseTime = responseTime
+	m.responseMsg = responseMessage
+	d.send(ctx, nil, m)
+	return adapter.ReturnModeContinue, nil
+}
+
+// send never blocks, frames are dropped if the buffer is full
+func (d *Dnstap) send(ctx context.Context, extra []byte, m *message) {
+	select {
+	case d.queue <- encodeDnstap(d.identity, d.version, extra, m):
+	default:
+		d.dropped.Add(1)
+		now := time.Now().Unix()
+		last := d.lastDropWarn.Load()
+		if now-last >= int64(dropWarnInterval.Seconds()) && d.lastDropWarn.CompareAndSwap(last, now) {
+			d.logger.WarnContext(ctx, "dnstap buffer is full, frames are dropped")
+		}
+	}
+}
+
+func (d *Dnstap) socketProtocol(listenerTag string) uint64 {
+	if listenerTag == "" {
+		return 0
+	}
+	l := d.core.GetListener(listenerTag)
+	if l == nil {
+		return 0
+	}
+	switch l.Type() {
+	case listener.UDPListenerType:
+		return socketProtocolUDP
+	case listener.TCPListenerType:
+		return socketProtocolTCP
+	case listener.TLSListenerType:
+		return socketProtocolDOT
+	case listener.HTTPListenerType:
+		return socketProtocolDOH
+	case listener.QUICListenerType:
+		return socketProtocolDOQ
+	}
+	return 0
+}
+
+func (d *Dnstap) CollectMetrics(w *metrics.Writer) {
+	w.Counter("cdns_dnstap_frames_sent_total", "Total number of dnstap frames sent.", d.sent.Load(), metrics.L("plugin", d.tag))
+	w.Counter("cdns_dnstap_frames_dropped_total", "Total number of dnstap frames dropped because the buffer is full.", d.dropped.Load(), metrics.L("plugin", d.tag))
+}
@@ -0,0 +1,128 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Frame Streams, from https://farsightsec.github.io/fstrm/
+
+const (
+	contentType = "protobuf:dnstap.Dnstap"
+
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+
+	maxControlFrameSize = 512
+	handshakeTimeout    = 5 * time.Second
+	writeTimeout        = 5 * time.Second
+)
+
+func writeControlFrame(w io.Writer, controlType uint32, withContentType bool) error {
+	frame := binary.BigEndian.AppendUint32(nil, controlType)
+	if withContentType {
+		frame = binary.BigEndian.AppendUint32(frame, controlFieldContentType)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+	b := make([]byte, 0, 8+len(frame))
+	b = binary.BigEndian.AppendUint32(b, 0) // escape
+	b = binary.BigEndian.AppendUint32(b, uint32(len(frame)))
+	b = append(b, frame...)
+	_, err := w.Write(b)
+	return err
+}
+
+func readControlFrame(r io.Reader) (uint32, error) {
+	var header [8]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, err
+	}
+	if binary.BigEndian.Uint32(header[:4]) != 0 {
+		return 0, fmt.Errorf("unexpected data frame")
+	}
+	size := binary.BigEndian.Uint32(header[4:])
+	if size < 4 || size > maxControlFrameSize {
+		return 0, fmt.Errorf("invalid control frame size: %d", size)
+	}
+	frame := make([]byte, size)
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(frame[:4]), nil
+}
+
+// frameWriter is a bidirectional Frame Streams writer
+type frameWriter struct {
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newFrameWriter(conn net.Conn) (*frameWriter, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	err := writeControlFrame(conn, controlReady, true)
+	if err != nil {
+		return nil, fmt.Errorf("write ready frame failed: %s", err)
+	}
+	controlType, err := readControlFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read accept frame failed: %s", err)
+	}
+	if controlType != controlAccept {
+		return nil, fmt.Errorf("unexpected control frame: %d", controlType)
+	}
+	err = writeControlFrame(conn, controlStart, true)
+	if err != nil {
+		return nil, fmt.Errorf("write start frame failed: %s", err)
+	}
+	return &frameWriter{
+		conn: conn,
+		w:    bufio.NewWriter(conn),
+	}, nil
+}
+
+// writeFrame sets the write deadline first, the buffered writer writes to the connection when it is full
+func (f *frameWriter) writeFrame(data []byte) error {
+	f.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	_, err := f.w.Write(header[:])
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(data)
+	return err
+}
+
+func (f *frameWriter) flush() error {
+	f.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return f.w.Flush()
+}
+
+// close sends the stop frame and waits for the finish frame
+func (f *frameWriter) close() error {
+	defer f.conn.Close()
+	err := f.flush()
+	if err != nil {
+		return err
+	}
+	f.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err = writeControlFrame(f.conn, controlStop, false)
+	if err != nil {
+		return err
+	}
+	_, err = readControlFrame(f.conn)
+	return err
+}
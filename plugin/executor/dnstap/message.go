@@ -0,0 +1,104 @@
+package dnstap
+
+import (
+	"net/netip"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// dnstap.proto, from https://github.com/dnstap/dnstap.pb
+
+const (
+	dnstapTypeMessage = 1
+
+	messageTypeClientQuery       = 5
+	messageTypeClientResponse    = 6
+	messageTypeForwarderQuery    = 7
+	messageTypeForwarderResponse = 8
+
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+
+	socketProtocolUDP = 1
+	socketProtocolTCP = 2
+	socketProtocolDOT = 3
+	socketProtocolDOH = 4
+	socketProtocolDOQ = 7
+)
+
+type message struct {
+	typ            uint64
+	socketProtocol uint64
+	queryAddress   netip.Addr
+	queryTime      time.Time
+	queryMessage   []byte
+	responseTime   time.Time
+	responseMsg    []byte
+}
+
+// encodeDnstap encodes a dnstap.Dnstap message
+func encodeDnstap(identity []byte, version []byte, extra []byte, m *message) []byte {
+	var b []byte
+	if len(identity) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, identity)
+	}
+	if len(version) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, version)
+	}
+	if len(extra) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, extra)
+	}
+	b = protowire.AppendTag(b, 14, protowire.BytesType)
+	b = protowire.AppendBytes(b, encodeMessage(m))
+	b = protowire.AppendTag(b, 15, protowire.VarintType)
+	b = protowire.AppendVarint(b, dnstapTypeMessage)
+	return b
+}
+
+// encodeMessage encodes a dnstap.Message message
+func encodeMessage(m *message) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.typ)
+	if m.queryAddress.IsValid() {
+		family := uint64(socketFamilyINET6)
+		if m.queryAddress.Is4() || m.queryAddress.Is4In6() {
+			family = socketFamilyINET
+		}
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, family)
+	}
+	if m.socketProtocol != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.socketProtocol)
+	}
+	if m.queryAddress.IsValid() {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.queryAddress.Unmap().AsSlice())
+	}
+	if !m.queryTime.IsZero() {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.queryTime.Unix()))
+		b = protowire.AppendTag(b, 9, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, uint32(m.queryTime.Nanosecond()))
+	}
+	if len(m.queryMessage) > 0 {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.queryMessage)
+	}
+	if !m.responseTime.IsZero() {
+		b = protowire.AppendTag(b, 12, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.responseTime.Unix()))
+		b = protowire.AppendTag(b, 13, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, uint32(m.responseTime.Nanosecond()))
+	}
+	if len(m.responseMsg) > 0 {
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.responseMsg)
+	}
+	return b
+}
@@ -58,7 +58,7 @@ var (
 type IPSet struct {
 	tag            string
 	logger         log.Logger
-	runningArgsMap map[uint16]runningArgs
+	runningArgsMap utils.RunningArgsMap[runningArgs]
 
 	name4    string
 	name6    string
@@ -171,7 +171,7 @@ func (i *IPSet) Close() error {
 	return nil
 }
 
-func (i *IPSet) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
+func (i *IPSet) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 	var a runningArgs
 	if args != nil {
 		err := utils.JsonDecode(args, &a)
@@ -179,18 +179,7 @@ func (i *IPSet) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
 			return 0, fmt.Errorf("parse args failed: %w", err)
 		}
 	}
-	if i.runningArgsMap == nil {
-		i.runningArgsMap = make(map[uint16]runningArgs)
-	}
-	i.runningArgsMap[0] = runningArgs{}
-	var id uint16
-	for {
-		id = utils.RandomIDUint16()
-		if _, ok := i.runningArgsMap[id]; !ok {
-			break
-		}
-	}
-	return id, nil
+	return i.runningArgsMap.Store(ctx, a)
 }
 
 func (i *IPSet) addIP(ctx context.Context, addr netip.Addr, ttl uint32) error {
@@ -254,7 +243,7 @@ func (i *IPSet) addIP(ctx context.Context, addr netip.Addr, ttl uint32) error {
 }
 
 func (i *IPSet) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID uint16) (adapter.ReturnMode, error) {
-	args := i.runningArgsMap[argsID]
+	args := i.runningArgsMap.Load(argsID)
 	if args.UseClientIP {
 		clientIP := dnsCtx.ClientIP()
 		err := i.addIP(ctx, clientIP, 0)
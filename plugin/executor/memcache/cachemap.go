@@ -1,36 +1,130 @@
 package memcache
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rnetx/cdns/utils"
 )
 
+const (
+	PolicyLRU = "lru"
+	PolicyLFU = "lfu"
+
+	shardNum = 16
+	// Small caches use one shard, so that the limits are exact
+	minShardEntries = 64
+	// itemOverhead is the estimated memory of an item besides its key and value
+	itemOverhead = 128
+)
+
 type Item[T any] struct {
 	Value    T              `json:"value"`
 	TTL      utils.Duration `json:"ttl"`
 	Deadline time.Time      `json:"-"`
+
+	hits atomic.Uint64
+	key  string
+	size int64
+	// used by lru
+	element *list.Element
+	// used by lfu
+	index  int
+	access uint64
+}
+
+func (i *Item[T]) Expired() bool {
+	return time.Now().After(i.Deadline)
+}
+
+// Hits returns how many times the item is looked up
+func (i *Item[T]) Hits() uint64 {
+	return i.hits.Load()
+}
+
+// Sizer is implemented by values which know their memory size, it is used by max-memory
+type Sizer interface {
+	Size() int
+}
+
+type CacheMapOptions struct {
+	// Stale is how long an item is kept after its deadline
+	Stale time.Duration
+	// MaxEntries and MaxMemory are the limits of the map, 0 means no limit
+	MaxEntries int
+	MaxMemory  int64
+	// Policy is the eviction policy used when the map is full, lru by default
+	Policy string
+}
+
+type CacheMapStats struct {
+	Entries     int    `json:"entries"`
+	Memory      int64  `json:"memory"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+type cacheShard[T any] struct {
+	lock       sync.Mutex
+	m          map[string]*Item[T]
+	policy     policy[T]
+	memory     int64
+	maxEntries int
+	maxMemory  int64
 }
 
 type CacheMap[T any] struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
-	m         map[string]*Item[T]
-	lock      sync.RWMutex
+	seed      maphash.Seed
+	shards    []*cacheShard[T]
+	stale     time.Duration
 	closeDone chan struct{}
+
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
 }
 
-func NewCacheMap[T any](ctx context.Context) *CacheMap[T] {
+func NewCacheMap[T any](ctx context.Context, options CacheMapOptions) *CacheMap[T] {
 	ctx, cancel := context.WithCancel(ctx)
-	return &CacheMap[T]{
+	m := &CacheMap[T]{
 		ctx:       ctx,
 		cancel:    cancel,
-		m:         make(map[string]*Item[T]),
+		seed:      maphash.MakeSeed(),
+		stale:     options.Stale,
 		closeDone: make(chan struct{}, 1),
 	}
+	n := shardNum
+	if options.MaxEntries > 0 && options.MaxEntries < shardNum*minShardEntries {
+		n = 1
+	}
+	m.shards = make([]*cacheShard[T], n)
+	for i := range m.shards {
+		s := &cacheShard[T]{
+			m: make(map[string]*Item[T]),
+		}
+		if options.MaxEntries > 0 {
+			s.maxEntries = (options.MaxEntries + n - 1) / n
+		}
+		if options.MaxMemory > 0 {
+			s.maxMemory = (options.MaxMemory + int64(n) - 1) / int64(n)
+		}
+		if s.maxEntries > 0 || s.maxMemory > 0 {
+			switch options.Policy {
+			case PolicyLFU:
+				s.policy = newLFUPolicy[T]()
+			default:
+				s.policy = newLRUPolicy[T]()
+			}
+		}
+		m.shards[i] = s
+	}
+	return m
 }
 
 func (m *CacheMap[T]) Start() {
@@ -43,6 +137,13 @@ func (m *CacheMap[T]) Close() {
 	close(m.closeDone)
 }
 
+func (m *CacheMap[T]) shard(key string) *cacheShard[T] {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	return m.shards[maphash.String(m.seed, key)%uint64(len(m.shards))]
+}
+
 func (m *CacheMap[T]) loopHandle() {
 	defer func() {
 		select {
@@ -57,71 +158,166 @@ func (m *CacheMap[T]) loopHandle() {
 		case <-m.ctx.Done():
 			return
 		case <-ticker.C:
-			m.lock.Lock()
-			for k, v := range m.m {
-				if time.Now().After(v.Deadline) {
-					delete(m.m, k)
+			for _, s := range m.shards {
+				s.lock.Lock()
+				for _, item := range s.m {
+					if time.Now().After(item.Deadline.Add(m.stale)) {
+						s.remove(item)
+						m.expirations.Add(1)
+					}
 				}
+				s.lock.Unlock()
 			}
-			m.lock.Unlock()
 		}
 	}
 }
 
 func (m *CacheMap[T]) Get(key string) (T, bool) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
 	var v T
-	item, ok := m.m[key]
+	item, ok := m.Lookup(key)
 	if ok {
 		v = item.Value
 	}
 	return v, ok
 }
 
+// Lookup returns the item of key and counts a hit on it.
+// Expired items are only found within the stale duration.
+func (m *CacheMap[T]) Lookup(key string) (*Item[T], bool) {
+	s := m.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item, ok := s.m[key]
+	if !ok || time.Now().After(item.Deadline.Add(m.stale)) {
+		return nil, false
+	}
+	item.hits.Add(1)
+	if s.policy != nil {
+		s.policy.touch(item)
+	}
+	return item, true
+}
+
 func (m *CacheMap[T]) Set(key string, value T, ttl time.Duration) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.m[key] = &Item[T]{
+	m.set(key, value, ttl, time.Now().Add(ttl))
+}
+
+func (m *CacheMap[T]) set(key string, value T, ttl time.Duration, deadline time.Time) {
+	item := &Item[T]{
 		Value:    value,
 		TTL:      utils.Duration(ttl),
-		Deadline: time.Now().Add(ttl),
+		Deadline: deadline,
+		key:      key,
+		size:     int64(len(key) + itemOverhead),
+	}
+	if sizer, ok := any(value).(Sizer); ok {
+		item.size += int64(sizer.Size())
+	}
+	s := m.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, ok := s.m[key]
+	if ok {
+		s.remove(old)
+	}
+	s.m[key] = item
+	s.memory += item.size
+	if s.policy == nil {
+		return
 	}
+	// The new item is added to the policy after eviction, so that it is never the victim,
+	// with lfu it has the least hits
+	for len(s.m) > 1 && ((s.maxEntries > 0 && len(s.m) > s.maxEntries) || (s.maxMemory > 0 && s.memory > s.maxMemory)) {
+		s.remove(s.policy.victim())
+		m.evictions.Add(1)
+	}
+	s.policy.add(item)
 }
 
 func (m *CacheMap[T]) Delete(key string) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	delete(m.m, key)
+	s := m.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item, ok := s.m[key]
+	if ok {
+		s.remove(item)
+	}
+}
+
+// Range calls f for each item until f returns false, f is called with the lock held
+func (m *CacheMap[T]) Range(f func(key string, item *Item[T]) bool) {
+	for _, s := range m.shards {
+		s.lock.Lock()
+		for k, item := range s.m {
+			if !f(k, item) {
+				s.lock.Unlock()
+				return
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+// DeleteFunc deletes the items for which f returns true, and returns the number of deleted items
+func (m *CacheMap[T]) DeleteFunc(f func(key string, item *Item[T]) bool) int {
+	var n int
+	for _, s := range m.shards {
+		s.lock.Lock()
+		for k, item := range s.m {
+			if f(k, item) {
+				s.remove(item)
+				n++
+			}
+		}
+		s.lock.Unlock()
+	}
+	return n
 }
 
 func (m *CacheMap[T]) FlushAll() {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.m = make(map[string]*Item[T])
+	for _, s := range m.shards {
+		s.lock.Lock()
+		s.m = make(map[string]*Item[T])
+		s.memory = 0
+		if s.policy != nil {
+			s.policy.reset()
+		}
+		s.lock.Unlock()
+	}
 }
 
-func (m *CacheMap[T]) Encode() ([]byte, error) {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
-	return json.Marshal(m.m)
+func (m *CacheMap[T]) Stats() CacheMapStats {
+	var stats CacheMapStats
+	for _, s := range m.shards {
+		s.lock.Lock()
+		stats.Entries += len(s.m)
+		stats.Memory += s.memory
+		s.lock.Unlock()
+	}
+	stats.Evictions = m.evictions.Load()
+	stats.Expirations = m.expirations.Load()
+	return stats
 }
 
-func Decode[T any](ctx context.Context, raw []byte) (*CacheMap[T], error) {
+// remove must be called with the lock held
+func (s *cacheShard[T]) remove(item *Item[T]) {
+	delete(s.m, item.key)
+	s.memory -= item.size
+	if s.policy != nil {
+		s.policy.remove(item)
+	}
+}
+
+// Decode loads the old JSON dump, the deadline of items is reset to now + TTL
+func Decode[T any](ctx context.Context, raw []byte, options CacheMapOptions) (*CacheMap[T], error) {
 	var mm map[string]*Item[T]
 	err := json.Unmarshal(raw, &mm)
 	if err != nil {
 		return nil, err
 	}
-	for _, item := range mm {
-		item.Deadline = time.Now().Add(time.Duration(item.TTL))
-	}
-	ctx, cancel := context.WithCancel(ctx)
-	m := &CacheMap[T]{
-		ctx:       ctx,
-		cancel:    cancel,
-		m:         mm,
-		closeDone: make(chan struct{}, 1),
+	m := NewCacheMap[T](ctx, options)
+	for k, item := range mm {
+		m.Set(k, item.Value, time.Duration(item.TTL))
 	}
 	return m, nil
 }
@@ -0,0 +1,298 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+// Binary dump format:
+//
+//	magic | version | entries...
+//	entry: uvarint key length | key | deadline (unix nano, int64) | ttl (nano, int64) | uvarint value length | value
+//
+// Old dump files are JSON, they are detected by the missing magic.
+
+const (
+	dumpMagic   = "CDNSMC"
+	dumpVersion = 1
+
+	// dumpMaxKeyLen is the longest key of reqToKey: flags, qtype, name length, name and ECS
+	dumpMaxKeyLen = 4 + 255 + 19
+	// dumpMaxValueLen is the longest value, a value of memcache holds two messages
+	dumpMaxValueLen = 2 * (binary.MaxVarintLen64 + dns.MaxMsgSize)
+)
+
+// WriteTo writes all items in the binary dump format, marshal encodes a value
+func (m *CacheMap[T]) WriteTo(w io.Writer, marshal func(T) ([]byte, error)) error {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(dumpMagic)
+	if err != nil {
+		return err
+	}
+	err = bw.WriteByte(dumpVersion)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	for _, s := range m.shards {
+		// Items are immutable, so they are written without the lock held
+		s.lock.Lock()
+		items := make([]*Item[T], 0, len(s.m))
+		for _, item := range s.m {
+			items = append(items, item)
+		}
+		s.lock.Unlock()
+		for _, item := range items {
+			value, err := marshal(item.Value)
+			if err != nil {
+				return err
+			}
+			buf = buf[:0]
+			buf = binary.AppendUvarint(buf, uint64(len(item.key)))
+			buf = append(buf, item.key...)
+			buf = binary.BigEndian.AppendUint64(buf, uint64(item.Deadline.UnixNano()))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(item.TTL))
+			buf = binary.AppendUvarint(buf, uint64(len(value)))
+			buf = append(buf, value...)
+			_, err = bw.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Load reads a binary or JSON dump, unmarshal decodes a value of the binary dump.
+// Items which are expired and out of the stale duration are dropped.
+// Lengths in the dump are checked before allocating, so a corrupt dump returns an error.
+func Load[T any](ctx context.Context, r io.Reader, options CacheMapOptions, unmarshal func([]byte) (T, error)) (*CacheMap[T], error) {
+	size := remainingSize(r)
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(dumpMagic) + 1)
+	if err != nil || string(header[:len(dumpMagic)]) != dumpMagic {
+		raw, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		return Decode[T](ctx, raw, options)
+	}
+	if header[len(dumpMagic)] != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version: %d", header[len(dumpMagic)])
+	}
+	br.Discard(len(header))
+	cr := &countingReader{r: br, n: int64(len(header))}
+	m := NewCacheMap[T](ctx, options)
+	now := time.Now()
+	for {
+		keyLen, err := binary.ReadUvarint(cr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keyLen > dumpMaxKeyLen {
+			return nil, fmt.Errorf("invalid key length: %d", keyLen)
+		}
+		key := make([]byte, keyLen)
+		_, err = io.ReadFull(cr, key)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		var fixed [16]byte
+		_, err = io.ReadFull(cr, fixed[:])
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		deadline := time.Unix(0, int64(binary.BigEndian.Uint64(fixed[:8])))
+		ttl := time.Duration(binary.BigEndian.Uint64(fixed[8:]))
+		valueLen, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if size >= 0 && valueLen > uint64(max(size-cr.n, 0)) {
+			return nil, fmt.Errorf("value length %d is longer than the rest of the dump: %w", valueLen, io.ErrUnexpectedEOF)
+		}
+		if valueLen > dumpMaxValueLen {
+			return nil, fmt.Errorf("invalid value length: %d", valueLen)
+		}
+		raw := make([]byte, valueLen)
+		_, err = io.ReadFull(cr, raw)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if now.After(deadline.Add(options.Stale)) {
+			continue
+		}
+		value, err := unmarshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		m.set(utils.BytesToStringUnsafe(key), value, ttl, deadline)
+	}
+	return m, nil
+}
+
+// remainingSize returns the number of bytes left in r, or -1 if it is unknown
+func remainingSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// dump writes cacheMap to a temporary file then renames it to path, so the dump file is never partially written
+func dump(cacheMap *CacheMap[*cacheItem], path string) error {
+	if cacheMap == nil {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	err = cacheMap.WriteTo(f, (*cacheItem).MarshalBinary)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// load returns an empty map if the dump file does not exist
+func load(ctx context.Context, path string, options CacheMapOptions) (*CacheMap[*cacheItem], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewCacheMap[*cacheItem](ctx, options), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return Load[*cacheItem](ctx, f, options, func(raw []byte) (*cacheItem, error) {
+		c := &cacheItem{}
+		err := c.UnmarshalBinary(raw)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	})
+}
+
+// MarshalBinary encodes the item as: uvarint response length | response | uvarint request length | request
+func (c *cacheItem) MarshalBinary() ([]byte, error) {
+	respRaw, err := c.resp.Pack()
+	if err != nil {
+		return nil, err
+	}
+	var reqRaw []byte
+	if c.req != nil {
+		reqRaw, err = c.req.Pack()
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+len(respRaw)+len(reqRaw))
+	buf = binary.AppendUvarint(buf, uint64(len(respRaw)))
+	buf = append(buf, respRaw...)
+	buf = binary.AppendUvarint(buf, uint64(len(reqRaw)))
+	buf = append(buf, reqRaw...)
+	return buf, nil
+}
+
+func (c *cacheItem) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	msgs := make([]*dns.Msg, 0, 2)
+	for i := 0; i < 2; i++ {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			msgs = append(msgs, nil)
+			continue
+		}
+		if n > uint64(r.Len()) {
+			return fmt.Errorf("invalid message length: %d", n)
+		}
+		raw := make([]byte, n)
+		_, err = io.ReadFull(r, raw)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		msg := &dns.Msg{}
+		err = msg.Unpack(raw)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	if msgs[0] == nil {
+		return fmt.Errorf("missing response")
+	}
+	c.resp, c.req = msgs[0], msgs[1]
+	return nil
+}
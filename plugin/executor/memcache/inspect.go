@@ -0,0 +1,340 @@
+package memcache
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DefaultEntriesLimit = 100
+	MaxEntriesLimit     = 1000
+)
+
+type entry struct {
+	Name    string   `json:"name"`
+	QType   string   `json:"qtype"`
+	Flags   []string `json:"flags,omitempty"`
+	ECS     string   `json:"ecs,omitempty"`
+	TTL     int64    `json:"ttl"` // remaining seconds, negative if expired
+	Expired bool     `json:"expired"`
+	Hits    uint64   `json:"hits"`
+	RCode   string   `json:"rcode"`
+	Answers []string `json:"answers,omitempty"`
+	Message string   `json:"message,omitempty"`
+}
+
+// keyInfo is the decoded key of reqToKey
+type keyInfo struct {
+	name  string
+	qtype uint16
+	flags []string
+	ecs   string
+}
+
+func parseKey(key string) (keyInfo, bool) {
+	var info keyInfo
+	if len(key) < 4 || len(key) < 4+int(key[3]) {
+		return info, false
+	}
+	b := key[0]
+	if b&1 != 0 {
+		info.flags = append(info.flags, "AD")
+	}
+	if b&2 != 0 {
+		info.flags = append(info.flags, "CD")
+	}
+	if b&4 != 0 {
+		info.flags = append(info.flags, "DO")
+	}
+	info.qtype = uint16(key[1])<<8 | uint16(key[2])
+	info.name = key[4 : 4+int(key[3])]
+	// ECS: family + bits + masked address, see utils.ECSKey
+	rest := key[4+int(key[3]):]
+	if len(rest) >= 3 {
+		family := uint16(rest[0])<<8 | uint16(rest[1])
+		bits := int(rest[2])
+		var addr netip.Addr
+		switch family {
+		case 1:
+			var a [4]byte
+			copy(a[:], rest[3:])
+			addr = netip.AddrFrom4(a)
+		case 2:
+			var a [16]byte
+			copy(a[:], rest[3:])
+			addr = netip.AddrFrom16(a)
+		}
+		if addr.IsValid() {
+			info.ecs = netip.PrefixFrom(addr, bits).String()
+		}
+	}
+	return info, true
+}
+
+func newEntry(info keyInfo, item *Item[*cacheItem], withMessage bool) *entry {
+	e := &entry{
+		Name:    info.name,
+		QType:   dns.TypeToString[info.qtype],
+		Flags:   info.flags,
+		ECS:     info.ecs,
+		TTL:     int64(time.Until(item.Deadline) / time.Second),
+		Expired: item.Expired(),
+		Hits:    item.Hits(),
+	}
+	if e.QType == "" {
+		e.QType = strconv.Itoa(int(info.qtype))
+	}
+	resp := item.Value.resp
+	e.RCode = dns.RcodeToString[resp.Rcode]
+	for _, rr := range resp.Answer {
+		header := rr.Header()
+		e.Answers = append(e.Answers, dns.TypeToString[header.Rrtype]+" "+strings.TrimPrefix(rr.String(), header.String()))
+	}
+	if withMessage {
+		e.Message = resp.String()
+	}
+	return e
+}
+
+func parseQType(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	qtype, ok := dns.StringToType[strings.ToUpper(s)]
+	if ok {
+		return qtype, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid qtype: %s", s)
+	}
+	return uint16(n), nil
+}
+
+// entryMatcher matches a name with a qtype, or all names under a domain suffix
+type entryMatcher struct {
+	name   string
+	suffix string
+	qtype  uint16
+}
+
+func (e *entryMatcher) match(info keyInfo) bool {
+	if e.qtype != 0 && info.qtype != e.qtype {
+		return false
+	}
+	name := strings.ToLower(info.name)
+	if e.name != "" && name != e.name {
+		return false
+	}
+	if e.suffix != "" && !dns.IsSubDomain(e.suffix, name) {
+		return false
+	}
+	return true
+}
+
+type foundItem struct {
+	info keyInfo
+	item *Item[*cacheItem]
+}
+
+// foundLess orders the found items by name, qtype and ECS
+func foundLess(a, b foundItem) bool {
+	if a.info.name != b.info.name {
+		return a.info.name < b.info.name
+	}
+	if a.info.qtype != b.info.qtype {
+		return a.info.qtype < b.info.qtype
+	}
+	return a.info.ecs < b.info.ecs
+}
+
+// foundHeap is a max-heap of the found items, the root is the last one in order
+type foundHeap []foundItem
+
+func (h foundHeap) Len() int {
+	return len(h)
+}
+
+func (h foundHeap) Less(i, j int) bool {
+	return foundLess(h[j], h[i])
+}
+
+func (h foundHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *foundHeap) Push(x any) {
+	*h = append(*h, x.(foundItem))
+}
+
+func (h *foundHeap) Pop() any {
+	old := *h
+	n := len(old)
+	found := old[n-1]
+	old[n-1] = foundItem{}
+	*h = old[:n-1]
+	return found
+}
+
+// findEntries returns the entries in [offset, offset+limit) of the matched items sorted by name, qtype and ECS,
+// with the total number of matched items. All items are scanned, but only the first offset+limit of them are kept
+// in a bounded heap, so a page costs O(N log(offset+limit)). Only keys are parsed with the shard locks held,
+// entries of the returned page are built later without the locks, as items are immutable. limit < 0 means no limit.
+func (m *MemCache) findEntries(matcher *entryMatcher, withMessage bool, offset int, limit int) ([]*entry, int) {
+	entries := make([]*entry, 0)
+	cacheMap := m.cacheMap
+	if cacheMap == nil {
+		return entries, 0
+	}
+	// keep < 0 keeps all matched items
+	keep := -1
+	if limit >= 0 && offset <= math.MaxInt-limit {
+		keep = offset + limit
+	}
+	var (
+		h     foundHeap
+		total int
+	)
+	cacheMap.Range(func(key string, item *Item[*cacheItem]) bool {
+		info, ok := parseKey(key)
+		if !ok || !matcher.match(info) {
+			return true
+		}
+		total++
+		found := foundItem{info: info, item: item}
+		switch {
+		case keep < 0:
+			h = append(h, found)
+		case len(h) < keep:
+			heap.Push(&h, found)
+		case keep > 0 && foundLess(found, h[0]):
+			h[0] = found
+			heap.Fix(&h, 0)
+		}
+		return true
+	})
+	items := []foundItem(h)
+	sort.Slice(items, func(i, j int) bool {
+		return foundLess(items[i], items[j])
+	})
+	for _, found := range items[min(offset, len(items)):] {
+		entries = append(entries, newEntry(found.info, found.item, withMessage))
+	}
+	return entries, total
+}
+
+func (m *MemCache) deleteEntries(matcher *entryMatcher) int {
+	cacheMap := m.cacheMap
+	if cacheMap == nil {
+		return 0
+	}
+	return cacheMap.DeleteFunc(func(key string, _ *Item[*cacheItem]) bool {
+		info, ok := parseKey(key)
+		return ok && matcher.match(info)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(raw)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+}
+
+func (m *MemCache) entryAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("name")
+		if name == "" {
+			writeError(w, fmt.Errorf("missing name"))
+			return
+		}
+		qtype, err := parseQType(query.Get("qtype"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		matcher := &entryMatcher{
+			name:  dns.Fqdn(strings.ToLower(name)),
+			qtype: qtype,
+		}
+		switch r.Method {
+		case http.MethodGet:
+			entries, _ := m.findEntries(matcher, true, 0, -1)
+			if len(entries) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": entries})
+		case http.MethodDelete:
+			n := m.deleteEntries(matcher)
+			writeJSON(w, http.StatusOK, map[string]any{"deleted": n})
+		}
+	}
+}
+
+func (m *MemCache) entriesAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		matcher := &entryMatcher{}
+		if domain := query.Get("domain"); domain != "" {
+			matcher.suffix = dns.Fqdn(strings.ToLower(domain))
+		}
+		var err error
+		matcher.qtype, err = parseQType(query.Get("qtype"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			offset := 0
+			if s := query.Get("offset"); s != "" {
+				offset, err = strconv.Atoi(s)
+				if err != nil || offset < 0 {
+					writeError(w, fmt.Errorf("invalid offset: %s", s))
+					return
+				}
+			}
+			limit := DefaultEntriesLimit
+			if s := query.Get("limit"); s != "" {
+				limit, err = strconv.Atoi(s)
+				if err != nil || limit <= 0 || limit > MaxEntriesLimit {
+					writeError(w, fmt.Errorf("invalid limit: %s", s))
+					return
+				}
+			}
+			entries, total := m.findEntries(matcher, false, offset, limit)
+			writeJSON(w, http.StatusOK, map[string]any{
+				"total":  total,
+				"offset": offset,
+				"data":   entries,
+			})
+		case http.MethodDelete:
+			if matcher.suffix == "" {
+				writeError(w, fmt.Errorf("missing domain, use /flush to delete all entries"))
+				return
+			}
+			n := m.deleteEntries(matcher)
+			writeJSON(w, http.StatusOK, map[string]any{"deleted": n})
+		}
+	}
+}
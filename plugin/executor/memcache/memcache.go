@@ -6,14 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/plugin"
 	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/miekg/dns"
@@ -25,9 +26,30 @@ func init() {
 	plugin.RegisterPluginExecutor(Type, NewMemCache)
 }
 
+const (
+	DefaultStaleAnswerTTL = 30 * time.Second
+	DefaultStaleTimeout   = 1800 * time.Millisecond
+	DefaultPrefetchHits   = 2
+)
+
 type Args struct {
-	DumpPath     string         `json:"dump-path"`
-	DumpInterval utils.Duration `json:"dump-interval"`
+	DumpPath       string         `json:"dump-path"`
+	DumpInterval   utils.Duration `json:"dump-interval"`
+	MaxEntries     int            `json:"max-entries"`
+	MaxMemory      utils.ByteSize `json:"max-memory"`
+	EvictionPolicy string         `json:"eviction-policy"`
+	ECS            string         `json:"ecs"`
+	MinTTL         uint32         `json:"min-ttl"`
+	MaxTTL         uint32         `json:"max-ttl"`
+	NegativeMinTTL uint32         `json:"negative-min-ttl"`
+	NegativeMaxTTL uint32         `json:"negative-max-ttl"`
+	Upstream       string         `json:"upstream"`
+	StaleTTL       utils.Duration `json:"stale-ttl"`
+	StaleAnswerTTL utils.Duration `json:"stale-answer-ttl"`
+	StaleTimeout   utils.Duration `json:"stale-timeout"`
+
+	PrefetchThreshold utils.Duration `json:"prefetch-threshold"`
+	PrefetchHits      int            `json:"prefetch-hits"`
 }
 
 type runningArgs struct {
@@ -36,29 +58,54 @@ type runningArgs struct {
 }
 
 var (
-	_ adapter.PluginExecutor = (*MemCache)(nil)
-	_ adapter.Starter        = (*MemCache)(nil)
-	_ adapter.Closer         = (*MemCache)(nil)
-	_ adapter.APIHandler     = (*MemCache)(nil)
+	_ adapter.PluginExecutor   = (*MemCache)(nil)
+	_ adapter.Starter          = (*MemCache)(nil)
+	_ adapter.Closer           = (*MemCache)(nil)
+	_ adapter.APIHandler       = (*MemCache)(nil)
+	_ adapter.MetricsCollector = (*MemCache)(nil)
 )
 
 type MemCache struct {
 	ctx            context.Context
 	tag            string
 	logger         log.Logger
-	runningArgsMap map[uint16]runningArgs
+	runningArgsMap utils.RunningArgsMap[runningArgs]
+
+	positiveTTL utils.TTLLimit
+	negativeTTL utils.TTLLimit
+	ecsMode     string
+
+	dumpPath        string
+	dumpInterval    time.Duration
+	cacheMapOptions CacheMapOptions
 
-	dumpPath     string
-	dumpInterval time.Duration
+	upstream       adapter.Upstream
+	staleTTL       time.Duration
+	staleAnswerTTL time.Duration
+	staleTimeout   time.Duration
+
+	prefetchThreshold time.Duration
+	prefetchHits      int
+
+	refreshLock sync.Mutex
+	refreshing  map[string]*refreshCall
 
 	dumpLock       sync.Mutex
 	cacheMap       *CacheMap[*cacheItem]
 	loopDumpCtx    context.Context
 	loopDumpCancel context.CancelFunc
 	closeDone      chan struct{}
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	staleHits atomic.Uint64
+
+	prefetchTotal   atomic.Uint64
+	prefetchSuccess atomic.Uint64
+	prefetchFailure atomic.Uint64
 }
 
-func NewMemCache(ctx context.Context, _ adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
+func NewMemCache(ctx context.Context, core adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
 	m := &MemCache{
 		ctx:    ctx,
 		tag:    tag,
@@ -69,8 +116,88 @@ func NewMemCache(ctx context.Context, _ adapter.Core, logger log.Logger, tag str
 	if err != nil {
 		return nil, fmt.Errorf("parse args failed: %w", err)
 	}
+	if a.MaxTTL > 0 && a.MinTTL > a.MaxTTL {
+		return nil, fmt.Errorf("min-ttl is greater than max-ttl")
+	}
+	if a.NegativeMaxTTL > 0 && a.NegativeMinTTL > a.NegativeMaxTTL {
+		return nil, fmt.Errorf("negative-min-ttl is greater than negative-max-ttl")
+	}
+	m.positiveTTL = utils.TTLLimit{Min: a.MinTTL, Max: a.MaxTTL}
+	m.negativeTTL = utils.TTLLimit{Min: a.NegativeMinTTL, Max: a.NegativeMaxTTL}
 	m.dumpPath = a.DumpPath
 	m.dumpInterval = time.Duration(a.DumpInterval)
+	if a.MaxEntries < 0 {
+		return nil, fmt.Errorf("invalid max-entries: %d", a.MaxEntries)
+	}
+	if a.MaxMemory < 0 {
+		return nil, fmt.Errorf("invalid max-memory: %d", a.MaxMemory)
+	}
+	switch a.EvictionPolicy {
+	case "", PolicyLRU, PolicyLFU:
+	default:
+		return nil, fmt.Errorf("invalid eviction-policy: %s", a.EvictionPolicy)
+	}
+	switch a.ECS {
+	case "", utils.ECSModeSource, utils.ECSModeScope:
+		m.ecsMode = a.ECS
+	default:
+		return nil, fmt.Errorf("invalid ecs: %s", a.ECS)
+	}
+	m.cacheMapOptions = CacheMapOptions{
+		MaxEntries: a.MaxEntries,
+		MaxMemory:  int64(a.MaxMemory),
+		Policy:     a.EvictionPolicy,
+	}
+	if a.Upstream != "" {
+		u := core.GetUpstream(a.Upstream)
+		if u == nil {
+			return nil, fmt.Errorf("upstream [%s] not found", a.Upstream)
+		}
+		m.upstream = u
+	}
+	m.staleAnswerTTL = time.Duration(a.StaleAnswerTTL)
+	if m.staleAnswerTTL < time.Second {
+		if m.staleAnswerTTL != 0 {
+			return nil, fmt.Errorf("invalid stale-answer-ttl: %s", m.staleAnswerTTL)
+		}
+		m.staleAnswerTTL = DefaultStaleAnswerTTL
+	}
+	if a.StaleTTL < 0 {
+		return nil, fmt.Errorf("invalid stale-ttl: %s", time.Duration(a.StaleTTL))
+	}
+	if a.StaleTTL > 0 {
+		if m.upstream == nil {
+			return nil, fmt.Errorf("missing upstream, it is required by stale-ttl")
+		}
+		m.staleTTL = time.Duration(a.StaleTTL)
+		m.cacheMapOptions.Stale = m.staleTTL
+		m.staleTimeout = time.Duration(a.StaleTimeout)
+		if m.staleTimeout < 0 {
+			return nil, fmt.Errorf("invalid stale-timeout: %s", m.staleTimeout)
+		}
+		if m.staleTimeout == 0 {
+			m.staleTimeout = DefaultStaleTimeout
+		}
+	}
+	if a.PrefetchThreshold < 0 {
+		return nil, fmt.Errorf("invalid prefetch-threshold: %s", time.Duration(a.PrefetchThreshold))
+	}
+	if a.PrefetchThreshold > 0 {
+		if m.upstream == nil {
+			return nil, fmt.Errorf("missing upstream, it is required by prefetch-threshold")
+		}
+		m.prefetchThreshold = time.Duration(a.PrefetchThreshold)
+		m.prefetchHits = a.PrefetchHits
+		if m.prefetchHits < 0 {
+			return nil, fmt.Errorf("invalid prefetch-hits: %d", m.prefetchHits)
+		}
+		if m.prefetchHits == 0 {
+			m.prefetchHits = DefaultPrefetchHits
+		}
+	}
+	if m.upstream != nil {
+		m.refreshing = make(map[string]*refreshCall)
+	}
 	return m, nil
 }
 
@@ -84,17 +211,15 @@ func (m *MemCache) Type() string {
 
 func (m *MemCache) Start() error {
 	if m.dumpPath != "" {
-		raw, err := os.ReadFile(m.dumpPath)
+		cacheMap, err := load(m.ctx, m.dumpPath, m.cacheMapOptions)
 		if err != nil {
-			return fmt.Errorf("load dump file failed: %s, error: %s", m.dumpPath, err)
-		}
-		cacheMap, err := Decode[*cacheItem](m.ctx, raw)
-		if err != nil {
-			return fmt.Errorf("load dump file failed: %s, error: %s", m.dumpPath, err)
+			// The dump is only a warm start, a corrupt one does not stop the cache
+			m.logger.Errorf("load dump file failed: %s, error: %s, start with an empty cache", m.dumpPath, err)
+			cacheMap = NewCacheMap[*cacheItem](m.ctx, m.cacheMapOptions)
 		}
 		m.cacheMap = cacheMap
 	} else {
-		m.cacheMap = NewCacheMap[*cacheItem](m.ctx)
+		m.cacheMap = NewCacheMap[*cacheItem](m.ctx, m.cacheMapOptions)
 	}
 	m.cacheMap.Start()
 	if m.dumpPath != "" && m.dumpInterval > 0 {
@@ -148,7 +273,7 @@ func (m *MemCache) loopDump() {
 	}
 }
 
-func (m *MemCache) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
+func (m *MemCache) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 	var a runningArgs
 	err := utils.JsonDecode(args, &a)
 	if err != nil {
@@ -181,22 +306,11 @@ func (m *MemCache) LoadRunningArgs(_ context.Context, args any) (uint16, error)
 			return 0, fmt.Errorf("unknown return: %v", r)
 		}
 	}
-	if m.runningArgsMap == nil {
-		m.runningArgsMap = make(map[uint16]runningArgs)
-	}
-	var id uint16
-	for {
-		id = utils.RandomIDUint16()
-		if _, ok := m.runningArgsMap[id]; !ok {
-			break
-		}
-	}
-	m.runningArgsMap[id] = a
-	return id, nil
+	return m.runningArgsMap.Store(ctx, a)
 }
 
 func (m *MemCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID uint16) (adapter.ReturnMode, error) {
-	args := m.runningArgsMap[argsID]
+	args := m.runningArgsMap.Load(argsID)
 	var ok bool
 	switch args.Mode {
 	case "store":
@@ -214,19 +328,23 @@ func (m *MemCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID
 			m.logger.DebugContext(ctx, "request message and response message is nil")
 			return adapter.ReturnModeContinue, nil
 		}
-		key := reqToKey(reqMsg)
+		if fallbackMsg, _ := dnsCtx.FallbackRespMsg(); fallbackMsg != nil && fallbackMsg == respMsg {
+			m.storeStale(ctx, dnsCtx)
+			return adapter.ReturnModeContinue, nil
+		}
+		key := utils.ECSStoreKey(reqToKey(reqMsg), reqMsg, respMsg, m.ecsMode)
 		if key == "" {
 			m.logger.DebugContext(ctx, "invalid key")
 			return adapter.ReturnModeContinue, nil
 		}
-		ttl := respFindMinTTL(respMsg)
+		ttl := m.respTTL(respMsg)
 		if ttl == 0 {
 			m.logger.DebugContext(ctx, "invalid ttl")
 			return adapter.ReturnModeContinue, nil
 		}
 		cacheMap := m.cacheMap
 		if cacheMap != nil {
-			cacheMap.Set(key, (*cacheItem)(respMsg.Copy()), time.Duration(ttl)*time.Second)
+			cacheMap.Set(key, &cacheItem{resp: respMsg.Copy(), req: reqMsg.Copy()}, time.Duration(ttl)*time.Second)
 			m.logger.DebugfContext(ctx, "store key: %s, ttl: %d", key, ttl)
 		}
 		ok = true
@@ -236,21 +354,39 @@ func (m *MemCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID
 			m.logger.DebugContext(ctx, "request message is nil")
 			return adapter.ReturnModeContinue, nil
 		}
-		key := reqToKey(reqMsg)
-		if key == "" {
+		keys := utils.ECSLookupKeys(reqToKey(reqMsg), reqMsg, m.ecsMode)
+		if len(keys) == 0 {
 			m.logger.DebugContext(ctx, "invalid key")
 			return adapter.ReturnModeContinue, nil
 		}
 		cacheMap := m.cacheMap
 		if cacheMap != nil {
-			cacheItem, found := cacheMap.Get(key)
-			if found {
+			var (
+				key   string
+				item  *Item[*cacheItem]
+				found bool
+			)
+			for _, key = range keys {
+				item, found = cacheMap.Lookup(key)
+				if found {
+					break
+				}
+			}
+			if found && !item.Expired() {
 				m.logger.DebugfContext(ctx, "restore key: %s", key)
-				respMsg := copyMsg((*dns.Msg)(cacheItem))
+				respMsg := copyMsg(item.Value.resp)
 				respMsg.Id = reqMsg.Id
 				dnsCtx.SetRespMsg(respMsg)
 				ok = true
+				m.hits.Add(1)
+				m.tryPrefetch(ctx, key, item)
+			} else if found && m.staleTTL > 0 {
+				ok = m.restoreStale(ctx, dnsCtx, key, item.Value.resp)
+			} else {
+				m.misses.Add(1)
 			}
+		} else {
+			m.misses.Add(1)
 		}
 	}
 	var returnMode string
@@ -271,6 +407,26 @@ func (m *MemCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID
 	return adapter.ReturnModeContinue, nil
 }
 
+func (m *MemCache) CollectMetrics(w *metrics.Writer) {
+	labels := []metrics.Label{metrics.L("plugin", m.tag), metrics.L("type", Type)}
+	w.Counter("cdns_cache_hits_total", "Total number of cache hits.", m.hits.Load(), labels...)
+	w.Counter("cdns_cache_misses_total", "Total number of cache misses.", m.misses.Load(), labels...)
+	cacheMap := m.cacheMap
+	if cacheMap != nil {
+		stats := cacheMap.Stats()
+		w.Gauge("cdns_cache_entries", "Number of entries in the cache.", float64(stats.Entries), labels...)
+		w.Gauge("cdns_cache_memory_bytes", "Estimated memory used by the cache.", float64(stats.Memory), labels...)
+		w.Counter("cdns_cache_evictions_total", "Total number of entries evicted because the cache is full.", stats.Evictions, labels...)
+	}
+	if m.staleTTL > 0 {
+		w.Counter("cdns_cache_stale_hits_total", "Total number of stale responses served.", m.staleHits.Load(), labels...)
+	}
+	if m.prefetchThreshold > 0 {
+		w.Counter("cdns_cache_prefetch_total", "Total number of cache prefetches.", m.prefetchTotal.Load(), labels...)
+		w.Counter("cdns_cache_prefetch_failures_total", "Total number of failed cache prefetches.", m.prefetchFailure.Load(), labels...)
+	}
+}
+
 func (m *MemCache) dumpFileAPIHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if m.dumpPath == "" {
@@ -302,6 +458,33 @@ func (m *MemCache) flushCacheAPIHandler() http.HandlerFunc {
 	}
 }
 
+func (m *MemCache) statisticsAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"hits":   m.hits.Load(),
+			"misses": m.misses.Load(),
+		}
+		cacheMap := m.cacheMap
+		if cacheMap != nil {
+			data["cache"] = cacheMap.Stats()
+		}
+		if m.staleTTL > 0 {
+			data["stale_hits"] = m.staleHits.Load()
+		}
+		if m.prefetchThreshold > 0 {
+			data["prefetch"] = m.prefetchStatistics()
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(raw)
+	}
+}
+
 func (m *MemCache) APIHandler() chi.Router {
 	builder := utils.NewChiRouterBuilder()
 	builder.Add(&utils.ChiRouterBuilderItem{
@@ -316,20 +499,35 @@ func (m *MemCache) APIHandler() chi.Router {
 		Description: "flush all cache in memory",
 		Handler:     m.flushCacheAPIHandler(),
 	})
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:        "/statistics",
+		Methods:     []string{http.MethodGet},
+		Description: "show cache statistics, including eviction and prefetch counters",
+		Handler:     m.statisticsAPIHandler(),
+	})
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:    "/entry",
+		Methods: []string{http.MethodGet, http.MethodDelete},
+		Description: map[string]string{
+			"name":  "domain name",
+			"qtype": "query type, e.g. A, AAAA, all types if empty",
+		},
+		Handler: m.entryAPIHandler(),
+	})
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:    "/entries",
+		Methods: []string{http.MethodGet, http.MethodDelete},
+		Description: map[string]string{
+			"domain": "domain suffix, matches the domain and its subdomains, required by DELETE",
+			"qtype":  "query type, e.g. A, AAAA, all types if empty",
+			"offset": "GET only, number of entries to skip",
+			"limit":  fmt.Sprintf("GET only, max number of entries, default %d, at most %d", DefaultEntriesLimit, MaxEntriesLimit),
+		},
+		Handler: m.entriesAPIHandler(),
+	})
 	return builder.Build()
 }
 
-func dump(cacheMap *CacheMap[*cacheItem], path string) error {
-	if cacheMap == nil {
-		return nil
-	}
-	raw, err := cacheMap.Encode()
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, raw, 0o644)
-}
-
 // from mosdns(https://github.com/IrineSistiana/mosdns), thank for @IrineSistiana
 func reqToKey(req *dns.Msg) string {
 	if req.Response || req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
@@ -357,7 +555,8 @@ func reqToKey(req *dns.Msg) string {
 		b = b | doBit
 	}
 	buf[0] = b
-	buf[1] = byte(question.Qtype << 8)
+	// The high byte used to be always 0, keys of qtypes above 255 changed when it was fixed
+	buf[1] = byte(question.Qtype >> 8)
 	buf[2] = byte(question.Qtype)
 	buf[3] = byte(len(question.Name))
 	copy(buf[4:], question.Name)
@@ -407,6 +606,14 @@ func copyMsg(req *dns.Msg) *dns.Msg {
 	return resp
 }
 
+// respTTL returns how long resp is cached, negative responses use the TTL from SOA (RFC 2308)
+func (m *MemCache) respTTL(resp *dns.Msg) uint32 {
+	if utils.IsNegativeResponse(resp) {
+		return m.negativeTTL.Clamp(utils.NegativeTTL(resp))
+	}
+	return m.positiveTTL.Clamp(respFindMinTTL(resp))
+}
+
 func respFindMinTTL(resp *dns.Msg) uint32 {
 	var minTTL uint32
 	for _, rr := range resp.Answer {
@@ -430,30 +637,61 @@ func respFindMinTTL(resp *dns.Msg) uint32 {
 	return minTTL
 }
 
-type cacheItem dns.Msg
+var _ Sizer = (*cacheItem)(nil)
+
+// cacheItem is the cached response with the request which it answers.
+// The request is used to refresh the response.
+type cacheItem struct {
+	resp *dns.Msg
+	req  *dns.Msg
+}
+
+func (c *cacheItem) Size() int {
+	size := c.resp.Len()
+	if c.req != nil {
+		size += c.req.Len()
+	}
+	return size
+}
+
+type cacheItemJSON struct {
+	Resp string `json:"resp"`
+	Req  string `json:"req,omitempty"`
+}
 
+// UnmarshalJSON loads the item of the JSON dump
 func (c *cacheItem) UnmarshalJSON(data []byte) error {
-	var _c string
-	err := json.Unmarshal(data, &_c)
+	var _c cacheItemJSON
+	// The first JSON dump only contains the response
+	err := json.Unmarshal(data, &_c.Resp)
 	if err != nil {
-		return err
+		err = json.Unmarshal(data, &_c)
+		if err != nil {
+			return err
+		}
 	}
-	respRaw, err := base64.StdEncoding.DecodeString(_c)
+	c.resp, err = unpackBase64Msg(_c.Resp)
 	if err != nil {
 		return err
 	}
-	err = (*dns.Msg)(c).Unpack(respRaw)
-	if err != nil {
-		return err
+	if _c.Req != "" {
+		c.req, err = unpackBase64Msg(_c.Req)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (c *cacheItem) MarshalJSON() ([]byte, error) {
-	respRaw, err := (*dns.Msg)(c).Pack()
+func unpackBase64Msg(s string) (*dns.Msg, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	msg := &dns.Msg{}
+	err = msg.Unpack(raw)
 	if err != nil {
 		return nil, err
 	}
-	s := base64.StdEncoding.EncodeToString(respRaw)
-	return json.Marshal(s)
+	return msg, nil
 }
@@ -0,0 +1,118 @@
+package memcache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// policy chooses the item to evict when the cache is full, it is called with the shard lock held
+type policy[T any] interface {
+	add(item *Item[T])
+	touch(item *Item[T])
+	remove(item *Item[T])
+	victim() *Item[T]
+	reset()
+}
+
+// lruPolicy evicts the least recently used item
+type lruPolicy[T any] struct {
+	l *list.List
+}
+
+func newLRUPolicy[T any]() *lruPolicy[T] {
+	return &lruPolicy[T]{
+		l: list.New(),
+	}
+}
+
+func (p *lruPolicy[T]) add(item *Item[T]) {
+	item.element = p.l.PushFront(item)
+}
+
+func (p *lruPolicy[T]) touch(item *Item[T]) {
+	p.l.MoveToFront(item.element)
+}
+
+func (p *lruPolicy[T]) remove(item *Item[T]) {
+	p.l.Remove(item.element)
+	item.element = nil
+}
+
+func (p *lruPolicy[T]) victim() *Item[T] {
+	return p.l.Back().Value.(*Item[T])
+}
+
+func (p *lruPolicy[T]) reset() {
+	p.l.Init()
+}
+
+// lfuPolicy evicts the least frequently used item, the least recently used one of them if there are many
+type lfuPolicy[T any] struct {
+	h     lfuHeap[T]
+	clock uint64
+}
+
+func newLFUPolicy[T any]() *lfuPolicy[T] {
+	return &lfuPolicy[T]{}
+}
+
+func (p *lfuPolicy[T]) add(item *Item[T]) {
+	p.clock++
+	item.access = p.clock
+	heap.Push(&p.h, item)
+}
+
+func (p *lfuPolicy[T]) touch(item *Item[T]) {
+	p.clock++
+	item.access = p.clock
+	heap.Fix(&p.h, item.index)
+}
+
+func (p *lfuPolicy[T]) remove(item *Item[T]) {
+	heap.Remove(&p.h, item.index)
+}
+
+func (p *lfuPolicy[T]) victim() *Item[T] {
+	return p.h[0]
+}
+
+func (p *lfuPolicy[T]) reset() {
+	p.h = nil
+	p.clock = 0
+}
+
+type lfuHeap[T any] []*Item[T]
+
+func (h lfuHeap[T]) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap[T]) Less(i, j int) bool {
+	hi, hj := h[i].Hits(), h[j].Hits()
+	if hi != hj {
+		return hi < hj
+	}
+	return h[i].access < h[j].access
+}
+
+func (h lfuHeap[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[T]) Push(x any) {
+	item := x.(*Item[T])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap[T]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,26 @@
+package memcache
+
+import (
+	"context"
+	"time"
+)
+
+// tryPrefetch refreshes a popular item in background before it expires
+func (m *MemCache) tryPrefetch(ctx context.Context, key string, item *Item[*cacheItem]) {
+	if m.prefetchThreshold == 0 || item.Value.req == nil {
+		return
+	}
+	if time.Until(item.Deadline) >= m.prefetchThreshold || item.Hits() < uint64(m.prefetchHits) {
+		return
+	}
+	m.logger.DebugfContext(ctx, "prefetch key: %s", key)
+	m.refresh(key, item.Value.req, true)
+}
+
+func (m *MemCache) prefetchStatistics() map[string]any {
+	return map[string]any{
+		"total":   m.prefetchTotal.Load(),
+		"success": m.prefetchSuccess.Load(),
+		"failure": m.prefetchFailure.Load(),
+	}
+}
@@ -0,0 +1,99 @@
+package memcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+const refreshTimeout = 10 * time.Second
+
+type refreshCall struct {
+	done chan struct{}
+	resp *dns.Msg
+	err  error
+}
+
+// refresh starts a background exchange for key, or returns the running one.
+// A failed exchange is kept for stale-answer-ttl, so that the upstream is not retried on every request.
+func (m *MemCache) refresh(key string, reqMsg *dns.Msg, prefetch bool) *refreshCall {
+	m.refreshLock.Lock()
+	defer m.refreshLock.Unlock()
+	call, ok := m.refreshing[key]
+	if ok {
+		return call
+	}
+	call = &refreshCall{
+		done: make(chan struct{}),
+	}
+	m.refreshing[key] = call
+	if prefetch {
+		m.prefetchTotal.Add(1)
+	}
+	go m.doRefresh(key, reqMsg.Copy(), call, prefetch)
+	return call
+}
+
+func (m *MemCache) doRefresh(key string, reqMsg *dns.Msg, call *refreshCall, prefetch bool) {
+	ctx, cancel := context.WithTimeout(m.ctx, refreshTimeout)
+	defer cancel()
+	respMsg, err := m.upstream.Exchange(ctx, reqMsg)
+	if err == nil && respMsg.Rcode != dns.RcodeSuccess && respMsg.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("unexpected rcode: %s", dns.RcodeToString[respMsg.Rcode])
+	}
+	if err == nil {
+		ttl := m.respTTL(respMsg)
+		cacheMap := m.cacheMap
+		if ttl > 0 && cacheMap != nil {
+			// The scope of the new response may be different
+			cacheMap.Set(utils.ECSStoreKey(reqToKey(reqMsg), reqMsg, respMsg, m.ecsMode), &cacheItem{resp: respMsg.Copy(), req: reqMsg}, time.Duration(ttl)*time.Second)
+		}
+		call.resp = respMsg
+	} else {
+		call.err = err
+	}
+	close(call.done)
+	if prefetch {
+		if err == nil {
+			m.prefetchSuccess.Add(1)
+		} else {
+			m.prefetchFailure.Add(1)
+			m.logger.Debugf("prefetch key: %s failed: %s", key, err)
+		}
+	}
+	if err == nil {
+		m.removeRefreshCall(key, call)
+	} else {
+		time.AfterFunc(m.staleAnswerTTL, func() {
+			m.removeRefreshCall(key, call)
+		})
+	}
+}
+
+// refreshFailed reports whether the last refresh of key failed within stale-answer-ttl
+func (m *MemCache) refreshFailed(key string) bool {
+	m.refreshLock.Lock()
+	call, ok := m.refreshing[key]
+	m.refreshLock.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case <-call.done:
+		return call.err != nil
+	default:
+		return false
+	}
+}
+
+func (m *MemCache) removeRefreshCall(key string, call *refreshCall) {
+	m.refreshLock.Lock()
+	defer m.refreshLock.Unlock()
+	if m.refreshing[key] == call {
+		delete(m.refreshing, key)
+	}
+}
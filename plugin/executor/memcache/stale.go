@@ -0,0 +1,61 @@
+package memcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+
+	"github.com/miekg/dns"
+)
+
+// Serve-stale, from RFC 8767
+
+// restoreStale is called when the cached response of key is expired. The stale response is served directly
+// if the last refresh of key failed within stale-answer-ttl. Otherwise it is set as the fallback response of dnsCtx
+// and the workflow goes on, its upstream step serves the fallback if the upstream fails or does not respond
+// within stale-timeout, then storeStale refreshes key in the background.
+func (m *MemCache) restoreStale(ctx context.Context, dnsCtx *adapter.DNSContext, key string, staleMsg *dns.Msg) bool {
+	reqMsg := dnsCtx.ReqMsg()
+	respMsg := copyMsg(staleMsg)
+	respMsg.Id = reqMsg.Id
+	setMsgTTL(respMsg, uint32(m.staleAnswerTTL/time.Second))
+	if m.refreshFailed(key) {
+		m.logger.DebugfContext(ctx, "restore stale key: %s", key)
+		dnsCtx.SetRespMsg(respMsg)
+		m.staleHits.Add(1)
+		return true
+	}
+	m.logger.DebugfContext(ctx, "stale key: %s, fallback to it if the upstream fails", key)
+	dnsCtx.SetFallbackRespMsg(respMsg, m.staleTimeout, m.tag, key)
+	m.misses.Add(1)
+	return false
+}
+
+// storeStale is called by store if the response is the fallback response, which means the upstream failed.
+// Only the fallback set by restoreStale of this cache is refreshed, the stale response of another cache is not stored.
+func (m *MemCache) storeStale(ctx context.Context, dnsCtx *adapter.DNSContext) {
+	owner, key := dnsCtx.FallbackOwner()
+	if owner != m.tag || m.staleTTL == 0 {
+		m.logger.DebugfContext(ctx, "skip the stale response of [%s]", owner)
+		return
+	}
+	m.logger.DebugfContext(ctx, "restore stale key: %s, refresh in background", key)
+	m.staleHits.Add(1)
+	m.refresh(key, dnsCtx.ReqMsg(), false)
+}
+
+func setMsgTTL(msg *dns.Msg, ttl uint32) {
+	for _, rr := range msg.Answer {
+		rr.Header().Ttl = ttl
+	}
+	for _, rr := range msg.Ns {
+		rr.Header().Ttl = ttl
+	}
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		rr.Header().Ttl = ttl
+	}
+}
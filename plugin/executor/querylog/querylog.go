@@ -0,0 +1,370 @@
+package querylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/rotate"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+const Type = "querylog"
+
+func init() {
+	plugin.RegisterPluginExecutor(Type, NewQueryLog)
+}
+
+const (
+	DefaultBufferSize = 1024
+	DefaultQueryLimit = 100
+	writeQueueSize    = 256
+)
+
+type Args struct {
+	Path       string         `json:"path"`
+	MaxSize    utils.ByteSize `json:"max-size"`
+	MaxAge     utils.Duration `json:"max-age"`
+	MaxBackups int            `json:"max-backups"`
+	Compress   bool           `json:"compress"`
+	BufferSize *int           `json:"buffer-size"`
+}
+
+var (
+	_ adapter.PluginExecutor = (*QueryLog)(nil)
+	_ adapter.Starter        = (*QueryLog)(nil)
+	_ adapter.Closer         = (*QueryLog)(nil)
+	_ adapter.APIHandler     = (*QueryLog)(nil)
+)
+
+type QueryLog struct {
+	ctx    context.Context
+	tag    string
+	logger log.Logger
+
+	path          string
+	rotateOptions rotate.Options
+	buffer        *ring
+
+	file       *rotate.File
+	writeQueue chan *record
+	loopCtx    context.Context
+	loopCancel context.CancelFunc
+	closeDone  chan struct{}
+}
+
+type record struct {
+	Time     time.Time         `json:"time"`
+	ID       uint32            `json:"id"`
+	Listener string            `json:"listener"`
+	ClientIP netip.Addr        `json:"client_ip"`
+	QName    string            `json:"qname"`
+	QType    string            `json:"qtype"`
+	RCode    string            `json:"rcode"`
+	Answers  []string          `json:"answers,omitempty"`
+	Upstream string            `json:"upstream,omitempty"`
+	Mark     uint64            `json:"mark,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Duration int64             `json:"duration"` // µs
+}
+
+func NewQueryLog(ctx context.Context, _ adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
+	q := &QueryLog{
+		ctx:    ctx,
+		tag:    tag,
+		logger: logger,
+	}
+	var a Args
+	err := utils.JsonDecode(args, &a)
+	if err != nil {
+		return nil, fmt.Errorf("parse args failed: %w", err)
+	}
+	bufferSize := DefaultBufferSize
+	if a.BufferSize != nil {
+		if *a.BufferSize < 0 {
+			return nil, fmt.Errorf("invalid buffer-size: %d", *a.BufferSize)
+		}
+		bufferSize = *a.BufferSize
+	}
+	if a.Path == "" && bufferSize == 0 {
+		return nil, fmt.Errorf("missing path or buffer-size")
+	}
+	if bufferSize > 0 {
+		q.buffer = newRing(bufferSize)
+	}
+	q.path = a.Path
+	q.rotateOptions = rotate.Options{
+		MaxSize:    int64(a.MaxSize),
+		MaxAge:     time.Duration(a.MaxAge),
+		MaxBackups: a.MaxBackups,
+		Compress:   a.Compress,
+	}
+	return q, nil
+}
+
+func (q *QueryLog) Tag() string {
+	return q.tag
+}
+
+func (q *QueryLog) Type() string {
+	return Type
+}
+
+func (q *QueryLog) Start() error {
+	if q.path == "" {
+		return nil
+	}
+	file, err := rotate.Open(q.path, q.rotateOptions)
+	if err != nil {
+		return fmt.Errorf("open query log file failed: %s, error: %s", q.path, err)
+	}
+	q.file = file
+	q.writeQueue = make(chan *record, writeQueueSize)
+	q.loopCtx, q.loopCancel = context.WithCancel(q.ctx)
+	q.closeDone = make(chan struct{}, 1)
+	go q.loopWrite()
+	return nil
+}
+
+func (q *QueryLog) Close() error {
+	if q.file == nil {
+		return nil
+	}
+	q.loopCancel()
+	<-q.closeDone
+	close(q.closeDone)
+	return q.file.Close()
+}
+
+func (q *QueryLog) loopWrite() {
+	defer func() {
+		select {
+		case q.closeDone <- struct{}{}:
+		default:
+		}
+	}()
+	for {
+		select {
+		case <-q.loopCtx.Done():
+			// Write the remaining records
+			for {
+				select {
+				case r := <-q.writeQueue:
+					q.write(r)
+				default:
+					return
+				}
+			}
+		case r := <-q.writeQueue:
+			q.write(r)
+		}
+	}
+}
+
+func (q *QueryLog) write(r *record) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	_, err = q.file.Write(append(raw, '\n'))
+	if err != nil {
+		q.logger.Errorf("write query log failed: %s", err)
+	}
+}
+
+func (q *QueryLog) LoadRunningArgs(_ context.Context, _ any) (uint16, error) {
+	return 0, nil
+}
+
+func (q *QueryLog) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, _ uint16) (adapter.ReturnMode, error) {
+	reqMsg := dnsCtx.ReqMsg()
+	if reqMsg == nil || len(reqMsg.Question) == 0 {
+		q.logger.DebugContext(ctx, "request message is nil")
+		return adapter.ReturnModeContinue, nil
+	}
+	r := newRecord(dnsCtx)
+	if q.buffer != nil {
+		q.buffer.add(r)
+	}
+	if q.file != nil {
+		select {
+		case q.writeQueue <- r:
+		default:
+			q.logger.WarnContext(ctx, "query log write queue is full, record dropped")
+		}
+	}
+	return adapter.ReturnModeContinue, nil
+}
+
+func newRecord(dnsCtx *adapter.DNSContext) *record {
+	reqMsg := dnsCtx.ReqMsg()
+	question := reqMsg.Question[0]
+	r := &record{
+		Time:     dnsCtx.InitTime(),
+		ID:       dnsCtx.ID(),
+		Listener: dnsCtx.Listener(),
+		ClientIP: dnsCtx.ClientIP(),
+		QName:    question.Name,
+		QType:    dns.TypeToString[question.Qtype],
+		RCode:    "NONE",
+		Upstream: dnsCtx.RespUpstreamTag(),
+		Mark:     dnsCtx.Mark(),
+		Duration: dnsCtx.Duration().Microseconds(),
+	}
+	if r.QType == "" {
+		r.QType = strconv.Itoa(int(question.Qtype))
+	}
+	respMsg := dnsCtx.RespMsg()
+	if respMsg != nil {
+		r.RCode = dns.RcodeToString[respMsg.Rcode]
+		if r.RCode == "" {
+			r.RCode = strconv.Itoa(respMsg.Rcode)
+		}
+		for _, rr := range respMsg.Answer {
+			header := rr.Header()
+			r.Answers = append(r.Answers, dns.TypeToString[header.Rrtype]+" "+strings.TrimPrefix(rr.String(), header.String()))
+		}
+	}
+	metadata := dnsCtx.Metadata()
+	if len(metadata) > 0 {
+		r.Metadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			r.Metadata[k] = v
+		}
+	}
+	return r
+}
+
+type filter struct {
+	client    netip.Prefix
+	domain    string
+	rcode     string
+	startTime time.Time
+	endTime   time.Time
+}
+
+func (f *filter) match(r *record) bool {
+	if f.client.IsValid() && !f.client.Contains(r.ClientIP.Unmap()) {
+		return false
+	}
+	if f.domain != "" && !strings.Contains(strings.ToLower(r.QName), f.domain) {
+		return false
+	}
+	if f.rcode != "" && !strings.EqualFold(r.RCode, f.rcode) {
+		return false
+	}
+	if !f.startTime.IsZero() && r.Time.Before(f.startTime) {
+		return false
+	}
+	if !f.endTime.IsZero() && r.Time.After(f.endTime) {
+		return false
+	}
+	return true
+}
+
+func parseFilter(r *http.Request) (*filter, int, error) {
+	query := r.URL.Query()
+	f := &filter{
+		domain: strings.ToLower(query.Get("domain")),
+		rcode:  query.Get("rcode"),
+	}
+	if client := query.Get("client"); client != "" {
+		prefix, err := netip.ParsePrefix(client)
+		if err != nil {
+			ip, err2 := netip.ParseAddr(client)
+			if err2 != nil {
+				return nil, 0, fmt.Errorf("invalid client: %s", client)
+			}
+			ip = ip.Unmap()
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		f.client = prefix.Masked()
+	}
+	var err error
+	if start := query.Get("start"); start != "" {
+		f.startTime, err = parseTime(start)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid start: %s", start)
+		}
+	}
+	if end := query.Get("end"); end != "" {
+		f.endTime, err = parseTime(end)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid end: %s", end)
+		}
+	}
+	limit := DefaultQueryLimit
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return nil, 0, fmt.Errorf("invalid limit: %s", l)
+		}
+	}
+	return f, limit, nil
+}
+
+// parseTime parses RFC3339 time or unix seconds
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(n, 0), nil
+}
+
+func (q *QueryLog) queryAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		f, limit, err := parseFilter(r)
+		if err != nil {
+			raw, _ := json.Marshal(map[string]any{"error": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(raw)
+			return
+		}
+		records := make([]*record, 0)
+		if q.buffer != nil {
+			records = q.buffer.find(f.match, limit)
+		}
+		raw, err := json.Marshal(map[string]any{"data": records})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(raw)
+	}
+}
+
+func (q *QueryLog) APIHandler() chi.Router {
+	builder := utils.NewChiRouterBuilder()
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:    "/query",
+		Methods: []string{http.MethodGet},
+		Description: map[string]string{
+			"client": "client ip or cidr",
+			"domain": "domain substring",
+			"rcode":  "rcode, e.g. NOERROR, NXDOMAIN, NONE for no response",
+			"start":  "start time, RFC3339 or unix seconds",
+			"end":    "end time, RFC3339 or unix seconds",
+			"limit":  fmt.Sprintf("max number of records, newest first, default %d", DefaultQueryLimit),
+		},
+		Handler: q.queryAPIHandler(),
+	})
+	return builder.Build()
+}
@@ -0,0 +1,46 @@
+package querylog
+
+import "sync"
+
+// ring keeps the latest records in memory
+type ring struct {
+	lock    sync.RWMutex
+	records []*record
+	next    int
+	full    bool
+}
+
+func newRing(size int) *ring {
+	return &ring{
+		records: make([]*record, size),
+	}
+}
+
+func (r *ring) add(record *record) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records[r.next] = record
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// find returns at most limit matched records, newest first
+func (r *ring) find(match func(*record) bool, limit int) []*record {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	n := r.next
+	if r.full {
+		n = len(r.records)
+	}
+	result := make([]*record, 0)
+	for i := 0; i < n && len(result) < limit; i++ {
+		index := (r.next - 1 - i + len(r.records)) % len(r.records)
+		if match(r.records[index]) {
+			result = append(result, r.records[index])
+		}
+	}
+	return result
+}
@@ -34,7 +34,7 @@ type RDNS struct {
 	tag    string
 	logger log.Logger
 
-	runningArgsMap map[uint16][]rule
+	runningArgsMap utils.RunningArgsMap[[]rule]
 }
 
 func NewRDNS(ctx context.Context, core adapter.Core, logger log.Logger, tag string, _ any) (adapter.PluginExecutor, error) {
@@ -70,7 +70,7 @@ func (r *RDNS) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID uint
 			return adapter.ReturnModeContinue, nil
 		}
 	}
-	rules := r.runningArgsMap[argsID]
+	rules := r.runningArgsMap.Load(argsID)
 	for _, rule := range rules {
 		if rule.isAny || rule.rule.Contains(ip) {
 			respMsg, err := rule.upstream.Exchange(ctx, reqMsg)
@@ -123,18 +123,7 @@ func (r *RDNS) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 			isAny:    isAny,
 		})
 	}
-	if r.runningArgsMap == nil {
-		r.runningArgsMap = make(map[uint16][]rule)
-	}
-	var id uint16
-	for {
-		id = utils.RandomIDUint16()
-		if _, ok := r.runningArgsMap[id]; !ok {
-			break
-		}
-	}
-	r.runningArgsMap[id] = rules
-	return id, nil
+	return r.runningArgsMap.Store(ctx, rules)
 }
 
 func isIPv4rDNS(q *dns.Question) netip.Addr {
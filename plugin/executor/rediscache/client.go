@@ -0,0 +1,159 @@
+package rediscache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+
+	flushBatchSize = 1000
+)
+
+type TLSOptions struct {
+	Servername     string                 `json:"servername"`
+	Insecure       bool                   `json:"insecure"`
+	ServerCAFile   utils.Listable[string] `json:"server-ca-file"`
+	ClientCertFile string                 `json:"client-cert-file"`
+	ClientKeyFile  string                 `json:"client-key-file"`
+}
+
+func newTLSConfig(options TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         options.Servername,
+		InsecureSkipVerify: options.Insecure,
+	}
+	if len(options.ServerCAFile) > 0 {
+		caPool := x509.NewCertPool()
+		for _, caFile := range options.ServerCAFile {
+			ca, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("read server-ca-file failed: %s, error: %s", caFile, err)
+			}
+			if !caPool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("append server-ca-file failed: %s", caFile)
+			}
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	if (options.ClientCertFile == "" && options.ClientKeyFile != "") || (options.ClientCertFile != "" && options.ClientKeyFile == "") {
+		return nil, fmt.Errorf("invalid client-cert-file or client-key-file")
+	}
+	if options.ClientCertFile != "" && options.ClientKeyFile != "" {
+		certPair, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client-cert-file and client-key-file failed: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certPair}
+	}
+	return tlsConfig, nil
+}
+
+func (r *RedisCache) newClient() redis.UniversalClient {
+	switch r.mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       r.masterName,
+			SentinelAddrs:    r.addresses,
+			SentinelPassword: r.sentinelPassword,
+			Password:         r.password,
+			DB:               r.db,
+			TLSConfig:        r.tlsConfig,
+		})
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     r.addresses,
+			Password:  r.password,
+			TLSConfig: r.tlsConfig,
+		})
+	default:
+		var (
+			address = r.addresses[0]
+			network = "unix"
+		)
+		addr, err := netip.ParseAddrPort(address)
+		if err == nil {
+			network = "tcp"
+			address = addr.String()
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:      address,
+			Network:   network,
+			Password:  r.password,
+			DB:        r.db,
+			TLSConfig: r.tlsConfig,
+		})
+	}
+}
+
+// flush deletes all keys with the key prefix, or all keys if the key prefix is empty.
+// In cluster mode, every master is flushed.
+func (r *RedisCache) flush(ctx context.Context) error {
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return r.flushNode(ctx, client)
+		})
+	}
+	return r.flushNode(ctx, r.client)
+}
+
+func (r *RedisCache) flushNode(ctx context.Context, client redis.Cmdable) error {
+	if r.keyPrefix == "" {
+		return client.FlushAll(ctx).Err()
+	}
+	iter := client.Scan(ctx, 0, escapePattern(r.keyPrefix)+"*", flushBatchSize).Iterator()
+	keys := make([]string, 0, flushBatchSize)
+	del := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		// Keys are deleted one by one, multi-key commands fail across cluster slots
+		_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, key := range keys {
+				p.Unlink(ctx, key)
+			}
+			return nil
+		})
+		keys = keys[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == flushBatchSize {
+			err := del()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := iter.Err()
+	if err != nil {
+		return err
+	}
+	return del()
+}
+
+// escapePattern escapes the special characters of the glob-style pattern of SCAN
+func escapePattern(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package rediscache
+
+import (
+	"context"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/plugin/executor/memcache"
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+// Two-tier cache: an in-process cache (L1) in front of Redis
+
+const (
+	DefaultMemoryMaxEntries  = 4096
+	DefaultInvalidateChannel = "cdns:invalidate"
+
+	// invalidateFlush is published when the cache is flushed, it is the only message on the channel
+	invalidateFlush = "flush"
+)
+
+type MemoryArgs struct {
+	MaxEntries     int            `json:"max-entries"`
+	MaxMemory      utils.ByteSize `json:"max-memory"`
+	EvictionPolicy string         `json:"eviction-policy"`
+	MaxTTL         utils.Duration `json:"max-ttl"`
+}
+
+type memoryItem struct {
+	resp *dns.Msg
+}
+
+func (m *memoryItem) Size() int {
+	return m.resp.Len()
+}
+
+// restoreMemory restores the response from L1, expired responses are left to Redis
+func (r *RedisCache) restoreMemory(ctx context.Context, dnsCtx *adapter.DNSContext, keys []string) bool {
+	for _, key := range keys {
+		item, ok := r.memory.Get(key)
+		if ok {
+			r.logger.DebugfContext(ctx, "restore key from memory: %s", key)
+			reqMsg := dnsCtx.ReqMsg()
+			respMsg := copyMsg(item.resp)
+			respMsg.Id = reqMsg.Id
+			dnsCtx.SetRespMsg(respMsg)
+			r.memoryHits.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// storeMemory stores resp in L1 for ttl, ttl is limited by max-ttl of memory
+func (r *RedisCache) storeMemory(key string, resp *dns.Msg, ttl time.Duration) {
+	if r.memoryMaxTTL > 0 && ttl > r.memoryMaxTTL {
+		ttl = r.memoryMaxTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	r.memory.Set(key, &memoryItem{resp: resp}, ttl)
+}
+
+// publishFlush notifies all nodes to flush L1
+func (r *RedisCache) publishFlush(ctx context.Context) error {
+	return r.client.Publish(ctx, r.invalidateChannel, invalidateFlush).Err()
+}
+
+func (r *RedisCache) loopInvalidate() {
+	defer func() {
+		select {
+		case r.closeDone <- struct{}{}:
+		default:
+		}
+	}()
+	ch := r.pubsub.Channel()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if msg.Payload == invalidateFlush {
+				r.logger.Debug("flush memory cache by invalidation")
+				r.memory.FlushAll()
+			}
+		}
+	}
+}
+
+func newMemoryCache(ctx context.Context, a MemoryArgs) *memcache.CacheMap[*memoryItem] {
+	options := memcache.CacheMapOptions{
+		MaxEntries: a.MaxEntries,
+		MaxMemory:  int64(a.MaxMemory),
+		Policy:     a.EvictionPolicy,
+	}
+	if options.MaxEntries == 0 && options.MaxMemory == 0 {
+		options.MaxEntries = DefaultMemoryMaxEntries
+	}
+	return memcache.NewCacheMap[*memoryItem](ctx, options)
+}
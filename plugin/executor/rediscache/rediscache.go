@@ -2,17 +2,20 @@ package rediscache
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/plugin/executor/memcache"
 	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/miekg/dns"
@@ -25,10 +28,33 @@ func init() {
 	plugin.RegisterPluginExecutor(Type, NewRedisCache)
 }
 
+const (
+	DefaultStaleAnswerTTL = 30 * time.Second
+	DefaultStaleTimeout   = 1800 * time.Millisecond
+)
+
 type Args struct {
-	Address  string `json:"address"`
-	Password string `json:"password"`
-	DB       int    `json:"db"`
+	Mode             string                 `json:"mode"`
+	Address          utils.Listable[string] `json:"address"`
+	MasterName       string                 `json:"master-name"`
+	Password         string                 `json:"password"`
+	SentinelPassword string                 `json:"sentinel-password"`
+	DB               int                    `json:"db"`
+	TLS              *TLSOptions            `json:"tls"`
+	KeyPrefix        string                 `json:"key-prefix"`
+	MinTTL           uint32                 `json:"min-ttl"`
+	MaxTTL           uint32                 `json:"max-ttl"`
+	NegativeMinTTL   uint32                 `json:"negative-min-ttl"`
+	NegativeMaxTTL   uint32                 `json:"negative-max-ttl"`
+	ECS              string                 `json:"ecs"`
+	Upstream         string                 `json:"upstream"`
+	StaleTTL         utils.Duration         `json:"stale-ttl"`
+	StaleAnswerTTL   utils.Duration         `json:"stale-answer-ttl"`
+	StaleTimeout     utils.Duration         `json:"stale-timeout"`
+
+	Memory            *MemoryArgs `json:"memory"`
+	Invalidate        bool        `json:"invalidate"`
+	InvalidateChannel string      `json:"invalidate-channel"`
 }
 
 type runningArgs struct {
@@ -37,26 +63,56 @@ type runningArgs struct {
 }
 
 var (
-	_ adapter.PluginExecutor = (*RedisCache)(nil)
-	_ adapter.Starter        = (*RedisCache)(nil)
-	_ adapter.Closer         = (*RedisCache)(nil)
-	_ adapter.APIHandler     = (*RedisCache)(nil)
+	_ adapter.PluginExecutor   = (*RedisCache)(nil)
+	_ adapter.Starter          = (*RedisCache)(nil)
+	_ adapter.Closer           = (*RedisCache)(nil)
+	_ adapter.APIHandler       = (*RedisCache)(nil)
+	_ adapter.MetricsCollector = (*RedisCache)(nil)
 )
 
 type RedisCache struct {
 	ctx            context.Context
 	tag            string
 	logger         log.Logger
-	runningArgsMap map[uint16]runningArgs
+	runningArgsMap utils.RunningArgsMap[runningArgs]
+
+	positiveTTL utils.TTLLimit
+	negativeTTL utils.TTLLimit
+	ecsMode     string
+
+	mode             string
+	addresses        []string
+	masterName       string
+	password         string
+	sentinelPassword string
+	db               int
+	tlsConfig        *tls.Config
+	keyPrefix        string
+
+	upstream       adapter.Upstream
+	staleTTL       time.Duration
+	staleAnswerTTL time.Duration
+	staleTimeout   time.Duration
 
-	address  string
-	password string
-	db       int
+	refreshLock sync.Mutex
+	refreshing  map[string]*refreshCall
 
-	client *redis.Client
+	memoryArgs        *MemoryArgs
+	memoryMaxTTL      time.Duration
+	memory            *memcache.CacheMap[*memoryItem]
+	invalidateChannel string
+	pubsub            *redis.PubSub
+	closeDone         chan struct{}
+
+	client redis.UniversalClient
+
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	staleHits  atomic.Uint64
+	memoryHits atomic.Uint64
 }
 
-func NewRedisCache(ctx context.Context, _ adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
+func NewRedisCache(ctx context.Context, core adapter.Core, logger log.Logger, tag string, args any) (adapter.PluginExecutor, error) {
 	r := &RedisCache{
 		ctx:    ctx,
 		tag:    tag,
@@ -67,12 +123,110 @@ func NewRedisCache(ctx context.Context, _ adapter.Core, logger log.Logger, tag s
 	if err != nil {
 		return nil, fmt.Errorf("parse args failed: %w", err)
 	}
-	if a.Address == "" {
+	if len(a.Address) == 0 {
 		return nil, fmt.Errorf("missing address")
 	}
-	r.address = a.Address
+	switch a.Mode {
+	case "", ModeStandalone:
+		if len(a.Address) > 1 {
+			return nil, fmt.Errorf("too many addresses, standalone mode requires one address")
+		}
+		r.mode = ModeStandalone
+	case ModeSentinel:
+		if a.MasterName == "" {
+			return nil, fmt.Errorf("missing master-name, it is required by sentinel mode")
+		}
+		r.mode = ModeSentinel
+	case ModeCluster:
+		if a.DB != 0 {
+			return nil, fmt.Errorf("invalid db: %d, cluster mode only supports db 0", a.DB)
+		}
+		r.mode = ModeCluster
+	default:
+		return nil, fmt.Errorf("invalid mode: %s", a.Mode)
+	}
+	r.addresses = a.Address
+	r.masterName = a.MasterName
 	r.password = a.Password
+	r.sentinelPassword = a.SentinelPassword
 	r.db = a.DB
+	if a.TLS != nil {
+		r.tlsConfig, err = newTLSConfig(*a.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls: %w", err)
+		}
+	}
+	r.keyPrefix = a.KeyPrefix
+	if a.MaxTTL > 0 && a.MinTTL > a.MaxTTL {
+		return nil, fmt.Errorf("min-ttl is greater than max-ttl")
+	}
+	if a.NegativeMaxTTL > 0 && a.NegativeMinTTL > a.NegativeMaxTTL {
+		return nil, fmt.Errorf("negative-min-ttl is greater than negative-max-ttl")
+	}
+	switch a.ECS {
+	case "", utils.ECSModeSource, utils.ECSModeScope:
+		r.ecsMode = a.ECS
+	default:
+		return nil, fmt.Errorf("invalid ecs: %s", a.ECS)
+	}
+	r.positiveTTL = utils.TTLLimit{Min: a.MinTTL, Max: a.MaxTTL}
+	r.negativeTTL = utils.TTLLimit{Min: a.NegativeMinTTL, Max: a.NegativeMaxTTL}
+	if a.StaleTTL < 0 {
+		return nil, fmt.Errorf("invalid stale-ttl: %s", time.Duration(a.StaleTTL))
+	}
+	if a.StaleTTL > 0 {
+		if a.Upstream == "" {
+			return nil, fmt.Errorf("missing upstream, it is required by stale-ttl")
+		}
+		u := core.GetUpstream(a.Upstream)
+		if u == nil {
+			return nil, fmt.Errorf("upstream [%s] not found", a.Upstream)
+		}
+		r.upstream = u
+		r.staleTTL = time.Duration(a.StaleTTL)
+		r.staleAnswerTTL = time.Duration(a.StaleAnswerTTL)
+		if r.staleAnswerTTL < time.Second {
+			if r.staleAnswerTTL != 0 {
+				return nil, fmt.Errorf("invalid stale-answer-ttl: %s", r.staleAnswerTTL)
+			}
+			r.staleAnswerTTL = DefaultStaleAnswerTTL
+		}
+		r.staleTimeout = time.Duration(a.StaleTimeout)
+		if r.staleTimeout < 0 {
+			return nil, fmt.Errorf("invalid stale-timeout: %s", r.staleTimeout)
+		}
+		if r.staleTimeout == 0 {
+			r.staleTimeout = DefaultStaleTimeout
+		}
+		r.refreshing = make(map[string]*refreshCall)
+	}
+	if a.Memory != nil {
+		if a.Memory.MaxEntries < 0 {
+			return nil, fmt.Errorf("invalid memory max-entries: %d", a.Memory.MaxEntries)
+		}
+		if a.Memory.MaxMemory < 0 {
+			return nil, fmt.Errorf("invalid memory max-memory: %d", a.Memory.MaxMemory)
+		}
+		switch a.Memory.EvictionPolicy {
+		case "", memcache.PolicyLRU, memcache.PolicyLFU:
+		default:
+			return nil, fmt.Errorf("invalid memory eviction-policy: %s", a.Memory.EvictionPolicy)
+		}
+		if a.Memory.MaxTTL < 0 {
+			return nil, fmt.Errorf("invalid memory max-ttl: %s", time.Duration(a.Memory.MaxTTL))
+		}
+		r.memoryArgs = a.Memory
+		r.memoryMaxTTL = time.Duration(a.Memory.MaxTTL)
+	}
+	if a.Invalidate {
+		if r.memoryArgs == nil {
+			return nil, fmt.Errorf("missing memory, it is required by invalidate")
+		}
+		r.invalidateChannel = a.InvalidateChannel
+		if r.invalidateChannel == "" {
+			r.invalidateChannel = r.keyPrefix + DefaultInvalidateChannel
+		}
+	}
 	return r, nil
 }
 
@@ -85,30 +239,33 @@ func (r *RedisCache) Type() string {
 }
 
 func (r *RedisCache) Start() error {
-	var (
-		address = r.address
-		network = "unix"
-	)
-	addr, err := netip.ParseAddrPort(r.address)
-	if err == nil {
-		network = "tcp"
-		address = addr.String()
-	}
-	r.client = redis.NewClient(&redis.Options{
-		Addr:     address,
-		Network:  network,
-		Password: r.password,
-		DB:       r.db,
-	})
+	r.client = r.newClient()
+	if r.memoryArgs != nil {
+		r.memory = newMemoryCache(r.ctx, *r.memoryArgs)
+		r.memory.Start()
+	}
+	if r.invalidateChannel != "" {
+		r.pubsub = r.client.Subscribe(r.ctx, r.invalidateChannel)
+		r.closeDone = make(chan struct{}, 1)
+		go r.loopInvalidate()
+	}
 	return nil
 }
 
 func (r *RedisCache) Close() error {
+	if r.pubsub != nil {
+		r.pubsub.Close()
+		<-r.closeDone
+		close(r.closeDone)
+	}
+	if r.memory != nil {
+		r.memory.Close()
+	}
 	r.client.Close()
 	return nil
 }
 
-func (r *RedisCache) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
+func (r *RedisCache) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 	var a runningArgs
 	err := utils.JsonDecode(args, &a)
 	if err != nil {
@@ -141,22 +298,11 @@ func (r *RedisCache) LoadRunningArgs(_ context.Context, args any) (uint16, error
 			return 0, fmt.Errorf("unknown return: %v", r)
 		}
 	}
-	if r.runningArgsMap == nil {
-		r.runningArgsMap = make(map[uint16]runningArgs)
-	}
-	var id uint16
-	for {
-		id = utils.RandomIDUint16()
-		if _, ok := r.runningArgsMap[id]; !ok {
-			break
-		}
-	}
-	r.runningArgsMap[id] = a
-	return id, nil
+	return r.runningArgsMap.Store(ctx, a)
 }
 
 func (r *RedisCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsID uint16) (adapter.ReturnMode, error) {
-	args := r.runningArgsMap[argsID]
+	args := r.runningArgsMap.Load(argsID)
 	var ok bool
 	switch args.Mode {
 	case "store":
@@ -174,26 +320,13 @@ func (r *RedisCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsI
 			r.logger.DebugContext(ctx, "request message and response message is nil")
 			return adapter.ReturnModeContinue, nil
 		}
-		key := reqToKey(reqMsg)
-		if key == "" {
-			r.logger.DebugContext(ctx, "invalid key")
-			return adapter.ReturnModeContinue, nil
-		}
-		ttl := respFindMinTTL(respMsg)
-		if ttl == 0 {
-			r.logger.DebugContext(ctx, "invalid ttl")
-			return adapter.ReturnModeContinue, nil
-		}
-		respRaw, err := respMsg.Pack()
-		if err != nil {
-			r.logger.DebugfContext(ctx, "pack response message failed: %w", err)
+		if fallbackMsg, _ := dnsCtx.FallbackRespMsg(); fallbackMsg != nil && fallbackMsg == respMsg {
+			r.storeStale(ctx, dnsCtx)
 			return adapter.ReturnModeContinue, nil
 		}
-		respStr := base64.StdEncoding.EncodeToString(respRaw)
-		r.logger.DebugfContext(ctx, "store key: %s, ttl: %d", key, ttl)
-		err = r.client.Set(r.ctx, key, respStr, time.Duration(ttl)*time.Second).Err()
+		err := r.store(ctx, reqMsg, respMsg)
 		if err != nil {
-			r.logger.DebugfContext(ctx, "store key failed: %s, error: %w", key, err)
+			r.logger.DebugContext(ctx, err)
 			return adapter.ReturnModeContinue, nil
 		}
 		ok = true
@@ -203,29 +336,55 @@ func (r *RedisCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsI
 			r.logger.DebugContext(ctx, "request message is nil")
 			return adapter.ReturnModeContinue, nil
 		}
-		key := reqToKey(reqMsg)
-		if key == "" {
+		keys := utils.ECSLookupKeys(reqToKey(reqMsg), reqMsg, r.ecsMode)
+		if len(keys) == 0 {
 			r.logger.DebugContext(ctx, "invalid key")
 			return adapter.ReturnModeContinue, nil
 		}
-		value, err := r.client.Get(r.ctx, key).Result()
+		for i := range keys {
+			keys[i] = r.keyPrefix + keys[i]
+		}
+		// Stale responses are counted by restoreStale
+		var stale bool
+		defer func() {
+			if stale {
+				return
+			}
+			if ok {
+				r.hits.Add(1)
+			} else {
+				r.misses.Add(1)
+			}
+		}()
+		if r.memory != nil && r.restoreMemory(ctx, dnsCtx, keys) {
+			ok = true
+			break
+		}
+		key, value, err := r.get(keys)
 		if err != nil && !errors.Is(err, redis.Nil) {
 			r.logger.DebugfContext(ctx, "get key failed: %s, error: %w", key, err)
 			return adapter.ReturnModeContinue, nil
 		}
 		if !errors.Is(err, redis.Nil) && value != "" {
-			respRaw, err := base64.StdEncoding.DecodeString(value)
+			respMsg, deadline, err := decodeValue(value)
 			if err != nil {
 				r.logger.DebugfContext(ctx, "decode response message failed: %w", err)
 				return adapter.ReturnModeContinue, nil
 			}
-			respMsg := &dns.Msg{}
-			err = respMsg.Unpack(respRaw)
-			if err != nil {
-				r.logger.DebugfContext(ctx, "unpack response message failed: %w", err)
-				return adapter.ReturnModeContinue, nil
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				if r.staleTTL == 0 {
+					// Stored by another instance with serve-stale
+					r.logger.DebugfContext(ctx, "key expired: %s", key)
+					return adapter.ReturnModeContinue, nil
+				}
+				stale = true
+				ok = r.restoreStale(ctx, dnsCtx, key, respMsg)
+				break
 			}
 			r.logger.DebugfContext(ctx, "restore key: %s", key)
+			if r.memory != nil && !deadline.IsZero() {
+				r.storeMemory(key, respMsg, time.Until(deadline))
+			}
 			respMsg = copyMsg(respMsg)
 			respMsg.Id = reqMsg.Id
 			dnsCtx.SetRespMsg(respMsg)
@@ -253,9 +412,91 @@ func (r *RedisCache) Exec(ctx context.Context, dnsCtx *adapter.DNSContext, argsI
 	return adapter.ReturnModeContinue, nil
 }
 
+// store caches resp in Redis, the key lives for stale-ttl more if serve-stale is enabled
+func (r *RedisCache) store(ctx context.Context, req *dns.Msg, resp *dns.Msg) error {
+	key := utils.ECSStoreKey(reqToKey(req), req, resp, r.ecsMode)
+	if key == "" {
+		return fmt.Errorf("invalid key")
+	}
+	ttl := r.respTTL(resp)
+	if ttl == 0 {
+		return fmt.Errorf("invalid ttl")
+	}
+	key = r.keyPrefix + key
+	if r.memory != nil {
+		r.storeMemory(key, resp.Copy(), time.Duration(ttl)*time.Second)
+	}
+	value, err := r.encodeValue(resp, time.Duration(ttl)*time.Second)
+	if err != nil {
+		return fmt.Errorf("pack response message failed: %w", err)
+	}
+	r.logger.DebugfContext(ctx, "store key: %s, ttl: %d", key, ttl)
+	err = r.client.Set(r.ctx, key, value, time.Duration(ttl)*time.Second+r.staleTTL).Err()
+	if err != nil {
+		return fmt.Errorf("store key failed: %s, error: %w", key, err)
+	}
+	return nil
+}
+
+// get returns the value of the first existing key, keys are got in one round trip
+func (r *RedisCache) get(keys []string) (string, string, error) {
+	if len(keys) == 1 {
+		value, err := r.client.Get(r.ctx, keys[0]).Result()
+		return keys[0], value, err
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := r.client.Pipelined(r.ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.Get(r.ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return keys[0], "", err
+	}
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == nil {
+			return keys[i], value, nil
+		}
+	}
+	return keys[0], "", redis.Nil
+}
+
+func (r *RedisCache) CollectMetrics(w *metrics.Writer) {
+	labels := []metrics.Label{metrics.L("plugin", r.tag), metrics.L("type", Type)}
+	w.Counter("cdns_cache_hits_total", "Total number of cache hits.", r.hits.Load(), labels...)
+	w.Counter("cdns_cache_misses_total", "Total number of cache misses.", r.misses.Load(), labels...)
+	memory := r.memory
+	if memory != nil {
+		stats := memory.Stats()
+		w.Counter("cdns_cache_l1_hits_total", "Total number of cache hits in memory.", r.memoryHits.Load(), labels...)
+		w.Gauge("cdns_cache_entries", "Number of entries in the cache.", float64(stats.Entries), labels...)
+		w.Gauge("cdns_cache_memory_bytes", "Estimated memory used by the cache.", float64(stats.Memory), labels...)
+		w.Counter("cdns_cache_evictions_total", "Total number of entries evicted because the cache is full.", stats.Evictions, labels...)
+	}
+	if r.staleTTL > 0 {
+		w.Counter("cdns_cache_stale_hits_total", "Total number of stale responses served.", r.staleHits.Load(), labels...)
+	}
+}
+
 func (r *RedisCache) flushCacheAPIHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		r.client.FlushAll(req.Context())
+		err := r.flush(req.Context())
+		if err != nil {
+			r.logger.Errorf("flush cache failed: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.memory != nil {
+			r.memory.FlushAll()
+		}
+		if r.pubsub != nil {
+			err = r.publishFlush(req.Context())
+			if err != nil {
+				r.logger.Errorf("publish flush failed: %s", err)
+			}
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
@@ -265,7 +506,7 @@ func (r *RedisCache) APIHandler() chi.Router {
 	builder.Add(&utils.ChiRouterBuilderItem{
 		Path:        "/flush",
 		Methods:     []string{http.MethodGet, http.MethodDelete},
-		Description: "flush all redis cache",
+		Description: "flush all redis cache, only keys with the key prefix are deleted if key-prefix is set",
 		Handler:     r.flushCacheAPIHandler(),
 	})
 	return builder.Build()
@@ -298,7 +539,8 @@ func reqToKey(req *dns.Msg) string {
 		b = b | doBit
 	}
 	buf[0] = b
-	buf[1] = byte(question.Qtype << 8)
+	// The high byte used to be always 0, keys of qtypes above 255 changed when it was fixed
+	buf[1] = byte(question.Qtype >> 8)
 	buf[2] = byte(question.Qtype)
 	buf[3] = byte(len(question.Name))
 	copy(buf[4:], question.Name)
@@ -348,6 +590,14 @@ func copyMsg(req *dns.Msg) *dns.Msg {
 	return resp
 }
 
+// respTTL returns how long resp is cached, negative responses use the TTL from SOA (RFC 2308)
+func (r *RedisCache) respTTL(resp *dns.Msg) uint32 {
+	if utils.IsNegativeResponse(resp) {
+		return r.negativeTTL.Clamp(utils.NegativeTTL(resp))
+	}
+	return r.positiveTTL.Clamp(respFindMinTTL(resp))
+}
+
 func respFindMinTTL(resp *dns.Msg) uint32 {
 	var minTTL uint32
 	for _, rr := range resp.Answer {
@@ -0,0 +1,84 @@
+package rediscache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const refreshTimeout = 10 * time.Second
+
+type refreshCall struct {
+	done chan struct{}
+	resp *dns.Msg
+	err  error
+}
+
+// refresh starts a background exchange for key, or returns the running one.
+// A failed exchange is kept for stale-answer-ttl, so that the upstream is not retried on every request.
+func (r *RedisCache) refresh(key string, reqMsg *dns.Msg) *refreshCall {
+	r.refreshLock.Lock()
+	defer r.refreshLock.Unlock()
+	call, ok := r.refreshing[key]
+	if ok {
+		return call
+	}
+	call = &refreshCall{
+		done: make(chan struct{}),
+	}
+	r.refreshing[key] = call
+	go r.doRefresh(key, reqMsg.Copy(), call)
+	return call
+}
+
+func (r *RedisCache) doRefresh(key string, reqMsg *dns.Msg, call *refreshCall) {
+	ctx, cancel := context.WithTimeout(r.ctx, refreshTimeout)
+	defer cancel()
+	respMsg, err := r.upstream.Exchange(ctx, reqMsg)
+	if err == nil && respMsg.Rcode != dns.RcodeSuccess && respMsg.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("unexpected rcode: %s", dns.RcodeToString[respMsg.Rcode])
+	}
+	if err == nil {
+		storeErr := r.store(ctx, reqMsg, respMsg)
+		if storeErr != nil {
+			r.logger.Debugf("store key: %s failed: %s", key, storeErr)
+		}
+		call.resp = respMsg
+	} else {
+		call.err = err
+	}
+	close(call.done)
+	if err == nil {
+		r.removeRefreshCall(key, call)
+	} else {
+		time.AfterFunc(r.staleAnswerTTL, func() {
+			r.removeRefreshCall(key, call)
+		})
+	}
+}
+
+// refreshFailed reports whether the last refresh of key failed within stale-answer-ttl
+func (r *RedisCache) refreshFailed(key string) bool {
+	r.refreshLock.Lock()
+	call, ok := r.refreshing[key]
+	r.refreshLock.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case <-call.done:
+		return call.err != nil
+	default:
+		return false
+	}
+}
+
+func (r *RedisCache) removeRefreshCall(key string, call *refreshCall) {
+	r.refreshLock.Lock()
+	defer r.refreshLock.Unlock()
+	if r.refreshing[key] == call {
+		delete(r.refreshing, key)
+	}
+}
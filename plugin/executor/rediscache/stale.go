@@ -0,0 +1,104 @@
+package rediscache
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+
+	"github.com/miekg/dns"
+)
+
+// Serve-stale, from RFC 8767
+
+// encodeValue encodes resp as base64. With serve-stale, the Redis key lives for ttl + stale-ttl,
+// so the original expiry is stored before the response: unix seconds | ':' | base64.
+// The expiry is also used by memory to know how long a response from Redis is valid.
+func (r *RedisCache) encodeValue(resp *dns.Msg, ttl time.Duration) (string, error) {
+	respRaw, err := resp.Pack()
+	if err != nil {
+		return "", err
+	}
+	respStr := base64.StdEncoding.EncodeToString(respRaw)
+	if r.staleTTL == 0 && r.memory == nil {
+		return respStr, nil
+	}
+	return strconv.FormatInt(time.Now().Add(ttl).Unix(), 10) + ":" + respStr, nil
+}
+
+// decodeValue returns the response and its original expiry, the expiry is zero for values stored without serve-stale
+func decodeValue(value string) (*dns.Msg, time.Time, error) {
+	var deadline time.Time
+	// ':' is not in the base64 alphabet
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		unix, err := strconv.ParseInt(value[:i], 10, 64)
+		if err != nil {
+			return nil, deadline, fmt.Errorf("invalid expiry: %s", value[:i])
+		}
+		deadline = time.Unix(unix, 0)
+		value = value[i+1:]
+	}
+	respRaw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, deadline, err
+	}
+	respMsg := &dns.Msg{}
+	err = respMsg.Unpack(respRaw)
+	if err != nil {
+		return nil, deadline, err
+	}
+	return respMsg, deadline, nil
+}
+
+// restoreStale is called when the cached response of key is expired. The stale response is served directly
+// if the last refresh of key failed within stale-answer-ttl. Otherwise it is set as the fallback response of dnsCtx
+// and the workflow goes on, its upstream step serves the fallback if the upstream fails or does not respond
+// within stale-timeout, then storeStale refreshes key in the background.
+func (r *RedisCache) restoreStale(ctx context.Context, dnsCtx *adapter.DNSContext, key string, staleMsg *dns.Msg) bool {
+	reqMsg := dnsCtx.ReqMsg()
+	respMsg := copyMsg(staleMsg)
+	respMsg.Id = reqMsg.Id
+	setMsgTTL(respMsg, uint32(r.staleAnswerTTL/time.Second))
+	if r.refreshFailed(key) {
+		r.logger.DebugfContext(ctx, "restore stale key: %s", key)
+		dnsCtx.SetRespMsg(respMsg)
+		r.staleHits.Add(1)
+		return true
+	}
+	r.logger.DebugfContext(ctx, "stale key: %s, fallback to it if the upstream fails", key)
+	dnsCtx.SetFallbackRespMsg(respMsg, r.staleTimeout, r.tag, key)
+	r.misses.Add(1)
+	return false
+}
+
+// storeStale is called by store if the response is the fallback response, which means the upstream failed.
+// Only the fallback set by restoreStale of this cache is refreshed, the stale response of another cache is not stored.
+func (r *RedisCache) storeStale(ctx context.Context, dnsCtx *adapter.DNSContext) {
+	owner, key := dnsCtx.FallbackOwner()
+	if owner != r.tag || r.staleTTL == 0 {
+		r.logger.DebugfContext(ctx, "skip the stale response of [%s]", owner)
+		return
+	}
+	r.logger.DebugfContext(ctx, "restore stale key: %s, refresh in background", key)
+	r.staleHits.Add(1)
+	r.refresh(key, dnsCtx.ReqMsg())
+}
+
+func setMsgTTL(msg *dns.Msg, ttl uint32) {
+	for _, rr := range msg.Answer {
+		rr.Header().Ttl = ttl
+	}
+	for _, rr := range msg.Ns {
+		rr.Header().Ttl = ttl
+	}
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		rr.Header().Ttl = ttl
+	}
+}
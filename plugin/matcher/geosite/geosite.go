@@ -45,6 +45,7 @@ type runningArgs struct {
 var (
 	_ adapter.PluginMatcher = (*GeoSite)(nil)
 	_ adapter.Starter       = (*GeoSite)(nil)
+	_ adapter.Validator     = (*GeoSite)(nil)
 	_ adapter.APIHandler    = (*GeoSite)(nil)
 )
 
@@ -52,7 +53,7 @@ type GeoSite struct {
 	ctx            context.Context
 	tag            string
 	logger         log.Logger
-	runningArgsMap map[uint16][]string
+	runningArgsMap utils.RunningArgsMap[[]string]
 
 	path        string
 	geositeType string
@@ -103,6 +104,11 @@ func (g *GeoSite) Type() string {
 }
 
 func (g *GeoSite) Start() error {
+	return g.Validate()
+}
+
+// Validate loads the rule, the codes of running args are checked against it
+func (g *GeoSite) Validate() error {
 	return g.loadRule()
 }
 
@@ -175,7 +181,7 @@ func (g *GeoSite) loadRule() error {
 	return nil
 }
 
-func (g *GeoSite) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
+func (g *GeoSite) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 	switch g.geositeType {
 	case "sing", "v2xray":
 		var codes utils.Listable[string]
@@ -198,23 +204,15 @@ func (g *GeoSite) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
 			for _, cc := range c {
 				cc = strings.TrimSpace(cc)
 				if _, ok := seen[cc]; !ok {
+					if g.ruleMap != nil && g.ruleMap[cc] == nil {
+						return 0, fmt.Errorf("code not found: %s", cc)
+					}
 					seen[cc] = struct{}{}
 					formatCodes = append(formatCodes, cc)
 				}
 			}
 		}
-		if g.runningArgsMap == nil {
-			g.runningArgsMap = make(map[uint16][]string)
-		}
-		var id uint16
-		for {
-			id = utils.RandomIDUint16()
-			if _, ok := g.runningArgsMap[id]; !ok {
-				break
-			}
-		}
-		g.runningArgsMap[id] = formatCodes
-		return id, nil
+		return g.runningArgsMap.Store(ctx, formatCodes)
 	case "meta":
 	}
 	return 0, nil
@@ -226,7 +224,7 @@ func (g *GeoSite) Match(ctx context.Context, dnsCtx *adapter.DNSContext, argsID
 	name := question.Name
 	switch g.geositeType {
 	case "sing", "v2xray":
-		codes := g.runningArgsMap[argsID]
+		codes := g.runningArgsMap.Load(argsID)
 		ruleMap := g.ruleMap
 		for _, code := range codes {
 			set, ok := ruleMap[code]
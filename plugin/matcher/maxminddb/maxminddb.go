@@ -49,7 +49,7 @@ type MaxmindDB struct {
 	ctx            context.Context
 	tag            string
 	logger         log.Logger
-	runningArgsMap map[uint16]runningArgItem
+	runningArgsMap utils.RunningArgsMap[runningArgItem]
 
 	path     string
 	dataType string
@@ -111,7 +111,7 @@ func (m *MaxmindDB) loadRule() error {
 	return nil
 }
 
-func (m *MaxmindDB) LoadRunningArgs(_ context.Context, args any) (uint16, error) {
+func (m *MaxmindDB) LoadRunningArgs(ctx context.Context, args any) (uint16, error) {
 	var codes utils.Listable[string]
 	var useClientIP bool
 	err := utils.JsonDecode(args, &codes)
@@ -135,21 +135,10 @@ func (m *MaxmindDB) LoadRunningArgs(_ context.Context, args any) (uint16, error)
 			codeMap[cc] = struct{}{}
 		}
 	}
-	if m.runningArgsMap == nil {
-		m.runningArgsMap = make(map[uint16]runningArgItem)
-	}
-	var id uint16
-	for {
-		id = utils.RandomIDUint16()
-		if _, ok := m.runningArgsMap[id]; !ok {
-			break
-		}
-	}
-	m.runningArgsMap[id] = runningArgItem{
+	return m.runningArgsMap.Store(ctx, runningArgItem{
 		code:        codeMap,
 		useClientIP: useClientIP,
-	}
-	return id, nil
+	})
 }
 
 func (m *MaxmindDB) Match(ctx context.Context, dnsCtx *adapter.DNSContext, argsID uint16) (bool, error) {
@@ -158,7 +147,7 @@ func (m *MaxmindDB) Match(ctx context.Context, dnsCtx *adapter.DNSContext, argsI
 		reader = reader.Clone()
 	}
 	defer reader.Close()
-	codeItem := m.runningArgsMap[argsID]
+	codeItem := m.runningArgsMap.Load(argsID)
 	if codeItem.useClientIP {
 		clientIP := dnsCtx.ClientIP()
 		codes := reader.Lookup(clientIP)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rnetx/cdns/utils"
+)
+
+func TestRunningArgsMapFree(t *testing.T) {
+	var m utils.RunningArgsMap[string]
+	ctx, cancel := context.WithCancel(context.Background())
+	id, err := m.Store(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := m.Load(id); v != "old" {
+		t.Fatalf("load: got %q, want %q", v, "old")
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for m.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("args are not freed after the context is done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v := m.Load(id); v != "" {
+		t.Fatalf("load after free: got %q", v)
+	}
+}
+
+func TestRunningArgsMapFull(t *testing.T) {
+	var m utils.RunningArgsMap[int]
+	ctx := context.Background()
+	seen := make(map[uint16]struct{}, math.MaxUint16+1)
+	for i := 0; i <= math.MaxUint16; i++ {
+		id, err := m.Store(ctx, i)
+		if err != nil {
+			t.Fatalf("store %d: %s", i, err)
+		}
+		if _, ok := seen[id]; ok {
+			t.Fatalf("store %d: duplicate id %d", i, id)
+		}
+		seen[id] = struct{}{}
+	}
+	_, err := m.Store(ctx, 0)
+	if err == nil {
+		t.Fatal("store in a full map: want an error")
+	}
+}
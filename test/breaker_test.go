@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/miekg/dns"
+)
+
+// circuitOpenGauge returns the value of the circuit open gauge of u
+func circuitOpenGauge(t *testing.T, u adapter.Upstream) string {
+	w := metrics.NewWriter()
+	u.(adapter.MetricsCollector).CollectMetrics(w)
+	var buf bytes.Buffer
+	_, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "cdns_upstream_circuit_open{") {
+			fields := strings.Fields(line)
+			return fields[len(fields)-1]
+		}
+	}
+	t.Fatalf("missing circuit open gauge: %s", buf.String())
+	return ""
+}
+
+func TestCircuitBreakerOpenGauge(t *testing.T) {
+	// The server never responds, so every exchange times out
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const openTimeout = 200 * time.Millisecond
+	options := upstream.Options{
+		Tag:          "breaker",
+		Type:         upstream.UDPUpstreamType,
+		QueryTimeout: 300 * time.Millisecond,
+		CircuitBreaker: &upstream.CircuitBreakerOptions{
+			Failures:    1,
+			OpenTimeout: utils.Duration(openTimeout),
+		},
+		UDPOptions: &upstream.UDPUpstreamOptions{
+			Address:            conn.LocalAddr().String(),
+			DisableFallbackTCP: true,
+		},
+	}
+	ctx := simpleCore.Context()
+	rootLogger := simpleCore.RootLogger()
+	u, err := upstream.NewUpstream(ctx, simpleCore, log.NewTagLogger(rootLogger, fmt.Sprintf("upstream/%s", options.Tag), aurora.GreenFg), options.Tag, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adapter.Start(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.Close(u)
+	req := &dns.Msg{}
+	req.SetQuestion("example.com.", dns.TypeA)
+
+	if gauge := circuitOpenGauge(t, u); gauge != "0" {
+		t.Fatalf("closed: got gauge %s, want 0", gauge)
+	}
+	_, err = u.Exchange(ctx, req)
+	if err == nil || errors.Is(err, upstream.ErrCircuitOpen) {
+		t.Fatalf("first exchange: got %v, want a timeout", err)
+	}
+	if gauge := circuitOpenGauge(t, u); gauge != "1" {
+		t.Fatalf("open: got gauge %s, want 1", gauge)
+	}
+	time.Sleep(openTimeout + 50*time.Millisecond)
+	// Reading the gauge does not take the half-open probe
+	for i := 0; i < 3; i++ {
+		if gauge := circuitOpenGauge(t, u); gauge != "0" {
+			t.Fatalf("half-open: got gauge %s, want 0", gauge)
+		}
+	}
+	probeDone := make(chan error, 1)
+	go func() {
+		_, err := u.Exchange(ctx, req)
+		probeDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// The probe is in flight, the circuit is half-open instead of open
+	if gauge := circuitOpenGauge(t, u); gauge != "0" {
+		t.Errorf("half-open with a probe in flight: got gauge %s, want 0", gauge)
+	}
+	err = <-probeDone
+	if err == nil || errors.Is(err, upstream.ErrCircuitOpen) {
+		t.Fatalf("probe: got %v, want a timeout", err)
+	}
+	if gauge := circuitOpenGauge(t, u); gauge != "1" {
+		t.Fatalf("reopened: got gauge %s, want 1", gauge)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rnetx/cdns/core"
+
+	"github.com/v2fly/v2ray-core/v5/app/router/routercommon"
+	"google.golang.org/protobuf/proto"
+)
+
+const testGeoSiteConfig = `
+upstreams:
+  - tag: dns
+    type: udp
+    address: 127.0.0.1:53
+workflows:
+  - tag: main
+    rules:
+      - match-and:
+          - plugin:
+              tag: geosite
+              args: %s
+        exec:
+          - upstream: dns
+listeners:
+  - tag: dns
+    type: udp
+    listen: 127.0.0.1:5353
+    workflow: main
+plugin-matchers:
+  - tag: geosite
+    type: geosite
+    args:
+      path: %s
+      type: v2ray
+      code: cn
+`
+
+func writeTestGeoSite(t *testing.T) string {
+	list := &routercommon.GeoSiteList{
+		Entry: []*routercommon.GeoSite{
+			{CountryCode: "CN", Domain: []*routercommon.Domain{{Type: routercommon.Domain_RootDomain, Value: "example.cn"}}},
+			{CountryCode: "GOOGLE", Domain: []*routercommon.Domain{{Type: routercommon.Domain_RootDomain, Value: "google.com"}}},
+		},
+	}
+	raw, err := proto.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "geosite.dat")
+	err = os.WriteFile(path, raw, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckGeoSite(t *testing.T) {
+	path := writeTestGeoSite(t)
+	tests := []struct {
+		name string
+		code string
+		path string
+		errs []string
+	}{
+		{name: "loaded code", code: "cn", path: path},
+		// google is in the file, but not loaded by the code option
+		{name: "code not loaded", code: "google", path: path, errs: []string{"workflows[0].rules[0].match-and[0].plugin: plugin matcher [geosite] load running args failed: code not found: google"}},
+		{name: "missing file", code: "cn", path: filepath.Join(t.TempDir(), "missing.dat"), errs: []string{"plugin-matchers[0]: read v2xray-geosite file failed"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := core.Check(context.Background(), []byte(fmt.Sprintf(testGeoSiteConfig, test.code, test.path)))
+			if len(errs) != len(test.errs) {
+				t.Fatalf("got errors %v, want %v", errs, test.errs)
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), test.errs[i]) {
+					t.Errorf("got error %q, want %q", err, test.errs[i])
+				}
+			}
+		})
+	}
+}
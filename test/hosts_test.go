@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/miekg/dns"
+)
+
+const testHosts = `# comment line
+127.0.0.1	localhost
+192.0.2.1	www.example.com	WWW.example.org.	# comment after names
+192.0.2.2	www.example.com
+192.0.2.1	dup.example.com
+2001:db8::1	www.example.com
+fe80::1%eth0	link.example.com
+::ffff:192.0.2.3	mapped.example.com
+0.0.0.0	blocked.example.com
+::	blocked.example.com
+not-an-ip	invalid.example.com
+192.0.2.4
+192.0.2.5	bad..name
+`
+
+func newTestHostsUpstream(t *testing.T, hosts string) adapter.Upstream {
+	// Names which are not in the table are answered by the fallback with REFUSED
+	addFuncUpstream(t, "fallback", func(req *dns.Msg) (*dns.Msg, error) {
+		resp := &dns.Msg{}
+		resp.SetRcode(req, dns.RcodeRefused)
+		return resp, nil
+	})
+	path := filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(path, []byte(hosts), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := upstream.Options{
+		Tag:  "hosts",
+		Type: upstream.HostsUpstreamType,
+		HostsOptions: &upstream.HostsUpstreamOptions{
+			File:     utils.Listable[string]{path},
+			Fallback: "fallback",
+		},
+	}
+	ctx := simpleCore.Context()
+	rootLogger := simpleCore.RootLogger()
+	u, err := upstream.NewUpstream(ctx, simpleCore, log.NewTagLogger(rootLogger, fmt.Sprintf("upstream/%s", options.Tag), aurora.GreenFg), options.Tag, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := u.(adapter.Closer).Close()
+		if err != nil {
+			t.Log(err)
+		}
+	})
+	return u
+}
+
+func TestHostsUpstream(t *testing.T) {
+	u := newTestHostsUpstream(t, testHosts)
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer []string
+	}{
+		{name: "single name", qname: "localhost.", qtype: dns.TypeA, answer: []string{"127.0.0.1"}},
+		{name: "multiple lines", qname: "www.example.com.", qtype: dns.TypeA, answer: []string{"192.0.2.1", "192.0.2.2"}},
+		{name: "ipv6", qname: "www.example.com.", qtype: dns.TypeAAAA, answer: []string{"2001:db8::1"}},
+		{name: "second name of a line", qname: "www.example.org.", qtype: dns.TypeA, answer: []string{"192.0.2.1"}},
+		{name: "case insensitive", qname: "Www.Example.Org.", qtype: dns.TypeA, answer: []string{"192.0.2.1"}},
+		{name: "no address of the type", qname: "localhost.", qtype: dns.TypeAAAA},
+		{name: "zone is removed", qname: "link.example.com.", qtype: dns.TypeAAAA, answer: []string{"fe80::1"}},
+		{name: "mapped address is ipv4", qname: "mapped.example.com.", qtype: dns.TypeA, answer: []string{"192.0.2.3"}},
+		{name: "blocked", qname: "blocked.example.com.", qtype: dns.TypeA, answer: []string{"0.0.0.0"}},
+		{name: "blocked ipv6", qname: "blocked.example.com.", qtype: dns.TypeAAAA, answer: []string{"::"}},
+		{name: "invalid address is skipped", qname: "invalid.example.com.", qtype: dns.TypeA, rcode: dns.RcodeRefused},
+		{name: "not in the table", qname: "other.example.com.", qtype: dns.TypeA, rcode: dns.RcodeRefused},
+		{name: "ptr", qname: "1.0.0.127.in-addr.arpa.", qtype: dns.TypePTR, answer: []string{"localhost."}},
+		{name: "ptr of the first name", qname: "1.2.0.192.in-addr.arpa.", qtype: dns.TypePTR, answer: []string{"www.example.com."}},
+		{name: "ptr upper case", qname: "2.2.0.192.IN-ADDR.ARPA.", qtype: dns.TypePTR, answer: []string{"www.example.com."}},
+		{name: "ptr of mapped address", qname: "3.2.0.192.in-addr.arpa.", qtype: dns.TypePTR, answer: []string{"mapped.example.com."}},
+		{name: "ptr ipv6", qname: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", qtype: dns.TypePTR, answer: []string{"www.example.com."}},
+		{name: "ptr of zoned address", qname: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.", qtype: dns.TypePTR, answer: []string{"link.example.com."}},
+		{name: "no ptr of 0.0.0.0", qname: "0.0.0.0.in-addr.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "no ptr of ::", qname: "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr not in the table", qname: "9.2.0.192.in-addr.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr too few labels", qname: "2.0.192.in-addr.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr too many labels", qname: "1.1.2.0.192.in-addr.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr label out of range", qname: "256.2.0.192.in-addr.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr ipv6 too few labels", qname: "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr ipv6 long label", qname: "10.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+		{name: "ptr ipv6 invalid label", qname: "g.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", qtype: dns.TypePTR, rcode: dns.RcodeRefused},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &dns.Msg{}
+			req.SetQuestion(test.qname, test.qtype)
+			resp, err := u.Exchange(simpleCore.Context(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rcode != test.rcode {
+				t.Fatalf("rcode: got %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[test.rcode])
+			}
+			answer := make([]string, 0, len(resp.Answer))
+			for _, rr := range resp.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					answer = append(answer, rr.A.String())
+				case *dns.AAAA:
+					answer = append(answer, rr.AAAA.String())
+				case *dns.PTR:
+					answer = append(answer, rr.Ptr)
+				}
+			}
+			if fmt.Sprint(answer) != fmt.Sprint(test.answer) {
+				t.Errorf("answer: got %v, want %v", answer, test.answer)
+			}
+		})
+	}
+}
+
+func TestHostsUpstreamReloadAPI(t *testing.T) {
+	u := newTestHostsUpstream(t, testHosts)
+	router := u.(adapter.APIHandler).APIHandler()
+	tests := []struct {
+		method string
+		status int
+	}{
+		{method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, status: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, "/reload", nil))
+		if w.Code != test.status {
+			t.Errorf("%s /reload: got %d, want %d", test.method, w.Code, test.status)
+		}
+	}
+}
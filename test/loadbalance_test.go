@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/upstream"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/miekg/dns"
+)
+
+func TestLoadBalanceEWMA(t *testing.T) {
+	var fast, slow atomic.Int64
+	addDelayUpstream := func(tag string, delay time.Duration, count *atomic.Int64) {
+		addFuncUpstream(t, tag, func(req *dns.Msg) (*dns.Msg, error) {
+			count.Add(1)
+			time.Sleep(delay)
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			return resp, nil
+		})
+	}
+	addDelayUpstream("fast", time.Millisecond, &fast)
+	addDelayUpstream("slow", 5*time.Millisecond, &slow)
+	options := upstream.Options{
+		Tag:  "lb",
+		Type: upstream.LoadBalanceUpstreamType,
+		LoadBalanceOptions: &upstream.LoadBalanceUpstreamOptions{
+			Upstreams: []upstream.LoadBalanceUpstreamMember{{Tag: "fast"}, {Tag: "slow"}},
+			Strategy:  upstream.LoadBalanceStrategyEWMA,
+		},
+	}
+	ctx := simpleCore.Context()
+	rootLogger := simpleCore.RootLogger()
+	u, err := upstream.NewUpstream(ctx, simpleCore, log.NewTagLogger(rootLogger, fmt.Sprintf("upstream/%s", options.Tag), aurora.GreenFg), options.Tag, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adapter.Start(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &dns.Msg{}
+	req.SetQuestion("example.com.", dns.TypeA)
+	for i := 0; i < 200; i++ {
+		_, err := u.Exchange(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The slow member keeps getting a share of the requests, so that its latency is measured again
+	if fast.Load() <= slow.Load() || slow.Load() < 2 {
+		t.Errorf("got fast %d, slow %d, want most but not all requests on fast", fast.Load(), slow.Load())
+	}
+}
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/plugin"
+	"github.com/rnetx/cdns/plugin/executor/memcache"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/miekg/dns"
+)
+
+type sizedValue int
+
+func (v sizedValue) Size() int {
+	return int(v)
+}
+
+// cacheItemSize returns the estimated memory of an item with a one byte key and a value of size v
+func cacheItemSize(v int) int64 {
+	m := memcache.NewCacheMap[sizedValue](context.Background(), memcache.CacheMapOptions{})
+	m.Set("a", sizedValue(v), time.Minute)
+	return m.Stats().Memory
+}
+
+type cacheOp struct {
+	set  string
+	get  string
+	size int
+}
+
+func TestCacheMapEvict(t *testing.T) {
+	itemSize := cacheItemSize(100)
+	tests := []struct {
+		name       string
+		policy     string
+		maxEntries int
+		maxMemory  int64
+		ops        []cacheOp
+		want       []string
+		evicted    []string
+	}{
+		{
+			name:       "lru entries",
+			policy:     memcache.PolicyLRU,
+			maxEntries: 3,
+			ops: []cacheOp{
+				{set: "a"}, {set: "b"}, {set: "c"},
+				{get: "a"},
+				{set: "d"},
+			},
+			want:    []string{"a", "c", "d"},
+			evicted: []string{"b"},
+		},
+		{
+			name:       "lru entries replace",
+			policy:     memcache.PolicyLRU,
+			maxEntries: 3,
+			ops: []cacheOp{
+				{set: "a"}, {set: "b"}, {set: "c"},
+				{set: "a"},
+				{set: "d"},
+				{set: "e"},
+			},
+			want:    []string{"a", "d", "e"},
+			evicted: []string{"b", "c"},
+		},
+		{
+			name:       "lfu entries",
+			policy:     memcache.PolicyLFU,
+			maxEntries: 3,
+			ops: []cacheOp{
+				{set: "a"}, {set: "b"}, {set: "c"},
+				{get: "a"}, {get: "a"}, {get: "c"},
+				{set: "d"},
+				{set: "e"},
+			},
+			want:    []string{"a", "c", "e"},
+			evicted: []string{"b", "d"},
+		},
+		{
+			name:       "lfu entries tie",
+			policy:     memcache.PolicyLFU,
+			maxEntries: 2,
+			ops: []cacheOp{
+				{set: "a"}, {set: "b"},
+				{get: "b"}, {get: "a"},
+				{set: "c"},
+			},
+			want:    []string{"a", "c"},
+			evicted: []string{"b"},
+		},
+		{
+			name:       "lru memory",
+			policy:     memcache.PolicyLRU,
+			maxEntries: 100,
+			maxMemory:  3 * itemSize,
+			ops: []cacheOp{
+				{set: "a", size: 100}, {set: "b", size: 100}, {set: "c", size: 100},
+				{get: "a"},
+				{set: "d", size: 100},
+			},
+			want:    []string{"a", "c", "d"},
+			evicted: []string{"b"},
+		},
+		{
+			name:       "lru memory large item",
+			policy:     memcache.PolicyLRU,
+			maxEntries: 100,
+			maxMemory:  3 * itemSize,
+			ops: []cacheOp{
+				{set: "a", size: 100}, {set: "b", size: 100}, {set: "c", size: 100},
+				{get: "a"},
+				{set: "d", size: 300},
+			},
+			want:    []string{"a", "d"},
+			evicted: []string{"b", "c"},
+		},
+		{
+			name:       "lfu memory",
+			policy:     memcache.PolicyLFU,
+			maxEntries: 100,
+			maxMemory:  3 * itemSize,
+			ops: []cacheOp{
+				{set: "a", size: 100}, {set: "b", size: 100}, {set: "c", size: 100},
+				{get: "b"}, {get: "c"},
+				{set: "d", size: 200},
+			},
+			want:    []string{"c", "d"},
+			evicted: []string{"a", "b"},
+		},
+		{
+			name:       "lfu memory oversize item is kept",
+			policy:     memcache.PolicyLFU,
+			maxEntries: 100,
+			maxMemory:  itemSize,
+			ops: []cacheOp{
+				{set: "a", size: 100},
+				{set: "b", size: 1000},
+			},
+			want:    []string{"b"},
+			evicted: []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := memcache.NewCacheMap[sizedValue](ctx, memcache.CacheMapOptions{
+				MaxEntries: test.maxEntries,
+				MaxMemory:  test.maxMemory,
+				Policy:     test.policy,
+			})
+			for _, op := range test.ops {
+				if op.set != "" {
+					m.Set(op.set, sizedValue(op.size), time.Minute)
+				} else {
+					m.Lookup(op.get)
+				}
+			}
+			for _, key := range test.want {
+				if _, ok := m.Get(key); !ok {
+					t.Errorf("key %s is evicted", key)
+				}
+			}
+			for _, key := range test.evicted {
+				if _, ok := m.Get(key); ok {
+					t.Errorf("key %s is not evicted", key)
+				}
+			}
+			stats := m.Stats()
+			if stats.Entries != len(test.want) {
+				t.Errorf("entries: got %d, want %d", stats.Entries, len(test.want))
+			}
+			if stats.Evictions != uint64(len(test.evicted)) {
+				t.Errorf("evictions: got %d, want %d", stats.Evictions, len(test.evicted))
+			}
+			var memory int64
+			m.Range(func(_ string, item *memcache.Item[sizedValue]) bool {
+				memory += itemSize - 100 + int64(item.Value)
+				return true
+			})
+			if stats.Memory != memory {
+				t.Errorf("memory: got %d, want %d", stats.Memory, memory)
+			}
+		})
+	}
+}
+
+func TestCacheMapShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Large limits are split across shards, the total stays within the limit
+	const maxEntries = 1024
+	m := memcache.NewCacheMap[sizedValue](ctx, memcache.CacheMapOptions{
+		MaxEntries: maxEntries,
+	})
+	for i := 0; i < 4*maxEntries; i++ {
+		m.Set(string(rune(i+0x100)), 0, time.Minute)
+	}
+	stats := m.Stats()
+	if stats.Entries > maxEntries {
+		t.Errorf("entries: got %d, want at most %d", stats.Entries, maxEntries)
+	}
+	if stats.Evictions != uint64(4*maxEntries-stats.Entries) {
+		t.Errorf("evictions: got %d, want %d", stats.Evictions, 4*maxEntries-stats.Entries)
+	}
+}
+
+type dumpValue string
+
+func marshalDumpValue(v dumpValue) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func unmarshalDumpValue(raw []byte) (dumpValue, error) {
+	return dumpValue(raw), nil
+}
+
+// dumpHeaderLen is the length of the magic and the version of a binary dump
+const dumpHeaderLen = len("CDNSMC") + 1
+
+func TestCacheMapDumpRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := memcache.NewCacheMap[dumpValue](ctx, memcache.CacheMapOptions{Stale: time.Hour})
+	m.Set("a", "value a", time.Minute)
+	m.Set("b", "", time.Hour)
+	m.Set("stale", "value stale", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	var buf bytes.Buffer
+	err := m.WriteTo(&buf, marshalDumpValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired items are kept within the stale duration
+	loaded, err := memcache.Load[dumpValue](ctx, bytes.NewReader(buf.Bytes()), memcache.CacheMapOptions{Stale: time.Hour}, unmarshalDumpValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := loaded.Stats(); stats.Entries != 3 {
+		t.Fatalf("entries: got %d, want 3", stats.Entries)
+	}
+	for _, key := range []string{"a", "b", "stale"} {
+		want, _ := m.Lookup(key)
+		got, ok := loaded.Lookup(key)
+		if !ok {
+			t.Errorf("key %s not found", key)
+			continue
+		}
+		if got.Value != want.Value || !got.Deadline.Equal(want.Deadline) || got.TTL != want.TTL {
+			t.Errorf("key %s: got %q deadline %s ttl %s, want %q deadline %s ttl %s", key, got.Value, got.Deadline, time.Duration(got.TTL), want.Value, want.Deadline, time.Duration(want.TTL))
+		}
+	}
+	loaded, err = memcache.Load[dumpValue](ctx, bytes.NewReader(buf.Bytes()), memcache.CacheMapOptions{}, unmarshalDumpValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Lookup("stale"); ok {
+		t.Errorf("key stale is loaded out of the stale duration")
+	}
+	if stats := loaded.Stats(); stats.Entries != 2 {
+		t.Errorf("entries: got %d, want 2", stats.Entries)
+	}
+}
+
+func TestCacheMapDumpTruncated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := memcache.NewCacheMap[dumpValue](ctx, memcache.CacheMapOptions{})
+	m.Set("a", "value a", time.Minute)
+	var buf bytes.Buffer
+	err := m.WriteTo(&buf, marshalDumpValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	// A dump with only the header is empty
+	loaded, err := memcache.Load[dumpValue](ctx, bytes.NewReader(raw[:dumpHeaderLen]), memcache.CacheMapOptions{}, unmarshalDumpValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := loaded.Stats(); stats.Entries != 0 {
+		t.Errorf("entries: got %d, want 0", stats.Entries)
+	}
+	for n := dumpHeaderLen + 1; n < len(raw); n++ {
+		_, err := memcache.Load[dumpValue](ctx, bytes.NewReader(raw[:n]), memcache.CacheMapOptions{}, unmarshalDumpValue)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated at %d/%d: got %v, want %v", n, len(raw), err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestCacheMapDumpUnsupportedVersion(t *testing.T) {
+	raw := append([]byte("CDNSMC"), 255)
+	_, err := memcache.Load[dumpValue](context.Background(), bytes.NewReader(raw), memcache.CacheMapOptions{}, unmarshalDumpValue)
+	if err == nil {
+		t.Fatal("no error")
+	}
+}
+
+// dumpEntry returns an entry of a binary dump with the given lengths, value is shorter than valueLen if it is truncated
+func dumpEntry(keyLen uint64, key string, deadline time.Time, valueLen uint64, value []byte) []byte {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, keyLen)
+	buf = append(buf, key...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(deadline.UnixNano()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Hour))
+	buf = binary.AppendUvarint(buf, valueLen)
+	return append(buf, value...)
+}
+
+// dumpHeader is the magic and the version of a binary dump
+var dumpHeader = append([]byte("CDNSMC"), 1)
+
+// corruptDumps are binary dumps whose lengths are out of range, they are built without the header
+var corruptDumps = []struct {
+	name  string
+	entry []byte
+}{
+	{name: "huge key length", entry: binary.AppendUvarint(nil, 1<<62)},
+	{name: "key longer than any key", entry: dumpEntry(1000, strings.Repeat("a", 1000), time.Now().Add(time.Hour), 1, []byte("v"))},
+	{name: "huge value length", entry: dumpEntry(1, "a", time.Now().Add(time.Hour), 1<<62, nil)},
+	{name: "value longer than the dump", entry: dumpEntry(1, "a", time.Now().Add(time.Hour), 100, []byte("short"))},
+}
+
+func TestCacheMapDumpCorrupt(t *testing.T) {
+	for _, test := range corruptDumps {
+		t.Run(test.name, func(t *testing.T) {
+			raw := append(append([]byte{}, dumpHeader...), test.entry...)
+			readers := map[string]io.Reader{
+				"sized":   bytes.NewReader(raw),
+				"unsized": struct{ io.Reader }{bytes.NewReader(raw)},
+			}
+			for kind, r := range readers {
+				_, err := memcache.Load[dumpValue](context.Background(), r, memcache.CacheMapOptions{}, unmarshalDumpValue)
+				if err == nil {
+					t.Errorf("%s reader: no error", kind)
+				}
+			}
+		})
+	}
+}
+
+func TestMemCacheDumpCorrupt(t *testing.T) {
+	tests := append(corruptDumps, struct {
+		name  string
+		entry []byte
+	}{
+		// The response in the value is longer than the value
+		name: "huge message length", entry: dumpEntry(1, "a", time.Now().Add(time.Hour), 10, binary.AppendUvarint(nil, 1<<40)),
+	})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.dump")
+			err := os.WriteFile(path, append(append([]byte{}, dumpHeader...), test.entry...), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The cache starts empty
+			p := newTestMemCache(t, "cache", map[string]any{"dump-path": path})
+			err = p.(adapter.Starter).Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.(adapter.Closer).Close()
+			if resp := restoreCache(t, p, "a.example.com."); resp != nil {
+				t.Errorf("got %v, want empty cache", resp)
+			}
+		})
+	}
+}
+
+func newTestMemCache(t *testing.T, tag string, args map[string]any) adapter.PluginExecutor {
+	ctx := simpleCore.Context()
+	rootLogger := simpleCore.RootLogger()
+	p, err := plugin.NewPluginExecutor(ctx, simpleCore, log.NewTagLogger(rootLogger, fmt.Sprintf("plugin-executor/%s", tag), aurora.YellowFg), tag, memcache.Type, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// execCache runs the plugin in mode, it reports whether the plugin returns
+func execCache(t *testing.T, p adapter.PluginExecutor, dnsCtx *adapter.DNSContext, mode string) bool {
+	ctx := simpleCore.Context()
+	id, err := p.LoadRunningArgs(ctx, map[string]any{"mode": mode, "return": "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	returnMode, err := p.Exec(ctx, dnsCtx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return returnMode != adapter.ReturnModeContinue
+}
+
+func newTestResponse(req *dns.Msg, ttl uint32, ip string) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP(ip),
+	}}
+	return resp
+}
+
+func newTestDNSContext(name string) *adapter.DNSContext {
+	req := &dns.Msg{}
+	req.SetQuestion(name, dns.TypeA)
+	return adapter.NewDNSContext(simpleCore.Context(), "test", netip.MustParseAddr("127.0.0.1"), req)
+}
+
+// restoreCache returns the response restored by the plugin, or nil
+func restoreCache(t *testing.T, p adapter.PluginExecutor, name string) *dns.Msg {
+	dnsCtx := newTestDNSContext(name)
+	if !execCache(t, p, dnsCtx, "restore") {
+		return nil
+	}
+	return dnsCtx.RespMsg()
+}
+
+func TestMemCacheDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.dump")
+	args := map[string]any{"dump-path": path}
+	p := newTestMemCache(t, "cache", args)
+	err := p.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := make(map[string]*dns.Msg)
+	for i, name := range []string{"a.example.com.", "b.example.com."} {
+		dnsCtx := newTestDNSContext(name)
+		resp := newTestResponse(dnsCtx.ReqMsg(), 300, fmt.Sprintf("192.0.2.%d", i+1))
+		dnsCtx.SetRespMsg(resp)
+		execCache(t, p, dnsCtx, "store")
+		responses[name] = resp
+	}
+	// The cache is dumped on close
+	err = p.(adapter.Closer).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = newTestMemCache(t, "cache", args)
+	err = p.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(adapter.Closer).Close()
+	for name, want := range responses {
+		got := restoreCache(t, p, name)
+		if got == nil {
+			t.Errorf("%s is not restored", name)
+			continue
+		}
+		if !equalRRInfos(got.Answer, rrInfos(want.Answer)) || got.Answer[0].String() != want.Answer[0].String() {
+			t.Errorf("%s: got %v, want %v", name, got.Answer, want.Answer)
+		}
+	}
+}
+
+// memcacheKey returns the cache key of memcache for a query without flags and ECS
+func memcacheKey(name string, qtype uint16) string {
+	return string([]byte{0, byte(qtype >> 8), byte(qtype), byte(len(name))}) + name
+}
+
+func TestMemCacheDumpLegacyJSON(t *testing.T) {
+	pack := func(msg *dns.Msg) string {
+		raw, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	reqA := newTestDNSContext("a.example.com.").ReqMsg()
+	reqB := newTestDNSContext("b.example.com.").ReqMsg()
+	respA := newTestResponse(reqA, 300, "192.0.2.1")
+	respB := newTestResponse(reqB, 300, "192.0.2.2")
+	raw, err := json.Marshal(map[string]any{
+		// The first JSON dump, the value is the response
+		memcacheKey("a.example.com.", dns.TypeA): map[string]any{"value": pack(respA), "ttl": "100s"},
+		// The value is the response with the request
+		memcacheKey("b.example.com.", dns.TypeA): map[string]any{"value": map[string]any{"resp": pack(respB), "req": pack(reqB)}, "ttl": "1m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cache.json")
+	err = os.WriteFile(path, raw, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newTestMemCache(t, "cache", map[string]any{"dump-path": path})
+	err = p.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(adapter.Closer).Close()
+	tests := []struct {
+		name string
+		resp *dns.Msg
+		ttl  int64
+	}{
+		{name: "a.example.com.", resp: respA, ttl: 100},
+		{name: "b.example.com.", resp: respB, ttl: 60},
+	}
+	router := p.(adapter.APIHandler).APIHandler()
+	for _, test := range tests {
+		got := restoreCache(t, p, test.name)
+		if got == nil {
+			t.Errorf("%s is not restored", test.name)
+			continue
+		}
+		if got.Answer[0].String() != test.resp.Answer[0].String() {
+			t.Errorf("%s: got %v, want %v", test.name, got.Answer, test.resp.Answer)
+		}
+		// The deadline is reset to now + TTL
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/entry?name="+test.name, nil))
+		var entries struct {
+			Data []struct {
+				TTL int64 `json:"ttl"`
+			} `json:"data"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &entries)
+		if err != nil || len(entries.Data) != 1 {
+			t.Errorf("%s: invalid entry: %s", test.name, w.Body.String())
+			continue
+		}
+		if ttl := entries.Data[0].TTL; ttl > test.ttl || ttl < test.ttl-5 {
+			t.Errorf("%s: got ttl %d, want %d", test.name, ttl, test.ttl)
+		}
+	}
+}
+
+func TestMemCacheDumpMissingFile(t *testing.T) {
+	p := newTestMemCache(t, "cache", map[string]any{"dump-path": filepath.Join(t.TempDir(), "missing.dump")})
+	err := p.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(adapter.Closer).Close()
+	if resp := restoreCache(t, p, "a.example.com."); resp != nil {
+		t.Errorf("got %v, want empty cache", resp)
+	}
+}
+
+// TestMemCacheStaleChain runs a cache without serve-stale in front of a cache with serve-stale,
+// the stale response of one cache must not be taken as its own by the other
+func TestMemCacheStaleChain(t *testing.T) {
+	refreshed := make(chan struct{}, 1)
+	addFuncUpstream(t, "stale-upstream", func(req *dns.Msg) (*dns.Msg, error) {
+		select {
+		case refreshed <- struct{}{}:
+		default:
+		}
+		return nil, errors.New("upstream is down")
+	})
+	front := newTestMemCache(t, "front", map[string]any{})
+	back := newTestMemCache(t, "back", map[string]any{"upstream": "stale-upstream", "stale-ttl": "1h"})
+	for _, p := range []adapter.PluginExecutor{front, back} {
+		err := p.(adapter.Starter).Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.(adapter.Closer).Close()
+	}
+	const name = "stale.example.com."
+	dnsCtx := newTestDNSContext(name)
+	dnsCtx.SetRespMsg(newTestResponse(dnsCtx.ReqMsg(), 1, "192.0.2.1"))
+	execCache(t, back, dnsCtx, "store")
+	execCache(t, front, dnsCtx, "store")
+	time.Sleep(1100 * time.Millisecond)
+
+	dnsCtx = newTestDNSContext(name)
+	if execCache(t, front, dnsCtx, "restore") {
+		t.Fatal("front: expired response is restored")
+	}
+	if execCache(t, back, dnsCtx, "restore") {
+		t.Fatal("back: stale response is restored before the upstream fails")
+	}
+	fallbackMsg, _ := dnsCtx.FallbackRespMsg()
+	if owner, _ := dnsCtx.FallbackOwner(); fallbackMsg == nil || owner != "back" {
+		t.Fatalf("fallback: got %v owned by %q, want the stale response of back", fallbackMsg, owner)
+	}
+	// The upstream step serves the fallback when the upstream fails
+	dnsCtx.SetRespMsg(fallbackMsg)
+	execCache(t, back, dnsCtx, "store")
+	execCache(t, front, dnsCtx, "store")
+
+	if resp := restoreCache(t, front, name); resp != nil {
+		t.Errorf("front: stale response of back is stored: %v", resp)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("back: stale response is not refreshed")
+	}
+	// The refresh failed, so back serves the stale response directly
+	deadline := time.Now().Add(time.Second)
+	for restoreCache(t, back, name) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("back: stale response is not served after the refresh failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemCacheEntriesPage(t *testing.T) {
+	p := newTestMemCache(t, "cache", nil)
+	err := p.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(adapter.Closer).Close()
+	const n = 50
+	names := make([]string, 0, n)
+	// Store in reverse order, so that the page does not follow the insertion order
+	for i := n - 1; i >= 0; i-- {
+		name := fmt.Sprintf("%02d.example.com.", i)
+		dnsCtx := newTestDNSContext(name)
+		dnsCtx.SetRespMsg(newTestResponse(dnsCtx.ReqMsg(), 300, "192.0.2.1"))
+		execCache(t, p, dnsCtx, "store")
+		names = append([]string{name}, names...)
+	}
+	router := p.(adapter.APIHandler).APIHandler()
+	tests := []struct {
+		offset int
+		limit  int
+		want   []string
+	}{
+		{offset: 0, limit: 5, want: names[:5]},
+		{offset: 20, limit: 7, want: names[20:27]},
+		{offset: 45, limit: 10, want: names[45:]},
+		{offset: 60, limit: 10, want: []string{}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/entries?domain=example.com&offset=%d&limit=%d", test.offset, test.limit), nil))
+		var page struct {
+			Total int `json:"total"`
+			Data  []struct {
+				Name string `json:"name"`
+			} `json:"data"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &page)
+		if err != nil {
+			t.Fatalf("offset %d: invalid page: %s", test.offset, w.Body.String())
+		}
+		got := make([]string, 0, len(page.Data))
+		for _, e := range page.Data {
+			got = append(got, e.Name)
+		}
+		if page.Total != n || strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("offset %d limit %d: got %d %v, want %d %v", test.offset, test.limit, page.Total, got, n, test.want)
+		}
+	}
+}
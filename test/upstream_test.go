@@ -356,3 +356,40 @@ func TestHTTP3UpstreamSocks5POST(t *testing.T) {
 	}
 	initTestUpstream(t, options)
 }
+
+var _ adapter.Upstream = (*funcUpstream)(nil)
+
+// funcUpstream answers requests with exchange, it stands in for the upstreams used by the component under test
+type funcUpstream struct {
+	tag      string
+	exchange func(req *dns.Msg) (*dns.Msg, error)
+}
+
+func addFuncUpstream(t *testing.T, tag string, exchange func(req *dns.Msg) (*dns.Msg, error)) *funcUpstream {
+	u := &funcUpstream{tag: tag, exchange: exchange}
+	simpleCore.AddUpstream(u)
+	t.Cleanup(func() {
+		simpleCore.RemoveUpstream(tag)
+	})
+	return u
+}
+
+func (u *funcUpstream) Tag() string {
+	return u.tag
+}
+
+func (u *funcUpstream) Type() string {
+	return "func"
+}
+
+func (u *funcUpstream) Dependencies() []string {
+	return nil
+}
+
+func (u *funcUpstream) Exchange(_ context.Context, req *dns.Msg) (*dns.Msg, error) {
+	return u.exchange(req)
+}
+
+func (u *funcUpstream) StatisticalData() map[string]any {
+	return nil
+}
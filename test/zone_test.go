@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/upstream"
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/miekg/dns"
+)
+
+const testZone = `$ORIGIN example.com.
+$TTL 300
+@          SOA   ns1 hostmaster 1 3600 600 86400 60
+@          NS    ns1
+ns1        A     192.0.2.53
+www        A     192.0.2.1
+*.wild     A     192.0.2.2
+cname      CNAME alias
+alias      CNAME missing
+sub        NS    ns.sub
+sub        DS    12345 8 1 49FD46E6C4B45C55D4AC69CBD3CD34AC1AFE51DE
+ns.sub     A     192.0.2.54
+`
+
+func newTestZoneUpstream(t *testing.T, zone string) adapter.Upstream {
+	path := filepath.Join(t.TempDir(), "example.com.zone")
+	err := os.WriteFile(path, []byte(zone), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := upstream.Options{
+		Tag:  "zone",
+		Type: upstream.ZoneUpstreamType,
+		ZoneOptions: &upstream.ZoneUpstreamOptions{
+			Files: utils.Listable[upstream.ZoneUpstreamFile]{{File: path}},
+		},
+	}
+	ctx := simpleCore.Context()
+	rootLogger := simpleCore.RootLogger()
+	u, err := upstream.NewUpstream(ctx, simpleCore, log.NewTagLogger(rootLogger, fmt.Sprintf("upstream/%s", options.Tag), aurora.GreenFg), options.Tag, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.(adapter.Starter).Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := u.(adapter.Closer).Close()
+		if err != nil {
+			t.Log(err)
+		}
+	})
+	return u
+}
+
+// rrInfos returns the owner name and type of each record
+func rrInfos(rrs []dns.RR) []string {
+	s := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		s = append(s, fmt.Sprintf("%s %s", rr.Header().Name, dns.TypeToString[rr.Header().Rrtype]))
+	}
+	return s
+}
+
+func equalRRInfos(rrs []dns.RR, want []string) bool {
+	got := rrInfos(rrs)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestZoneUpstream(t *testing.T) {
+	u := newTestZoneUpstream(t, testZone)
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		aa     bool
+		answer []string
+		ns     []string
+		extra  []string
+	}{
+		{
+			name:   "answer",
+			qname:  "www.example.com.",
+			qtype:  dns.TypeA,
+			rcode:  dns.RcodeSuccess,
+			aa:     true,
+			answer: []string{"www.example.com. A"},
+		},
+		{
+			name:   "wildcard answer",
+			qname:  "a.wild.example.com.",
+			qtype:  dns.TypeA,
+			rcode:  dns.RcodeSuccess,
+			aa:     true,
+			answer: []string{"a.wild.example.com. A"},
+		},
+		{
+			name:  "wildcard nodata",
+			qname: "a.wild.example.com.",
+			qtype: dns.TypeAAAA,
+			rcode: dns.RcodeSuccess,
+			aa:    true,
+			ns:    []string{"example.com. SOA"},
+		},
+		{
+			name:  "empty non-terminal nodata",
+			qname: "wild.example.com.",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			aa:    true,
+			ns:    []string{"example.com. SOA"},
+		},
+		{
+			name:  "nxdomain without wildcard",
+			qname: "a.www.example.com.",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeNameError,
+			aa:    true,
+			ns:    []string{"example.com. SOA"},
+		},
+		{
+			name:  "referral with glue",
+			qname: "host.sub.example.com.",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			aa:    false,
+			ns:    []string{"sub.example.com. NS"},
+			extra: []string{"ns.sub.example.com. A"},
+		},
+		{
+			name:  "referral at the cut",
+			qname: "sub.example.com.",
+			qtype: dns.TypeA,
+			rcode: dns.RcodeSuccess,
+			aa:    false,
+			ns:    []string{"sub.example.com. NS"},
+			extra: []string{"ns.sub.example.com. A"},
+		},
+		{
+			name:   "ds at the cut",
+			qname:  "sub.example.com.",
+			qtype:  dns.TypeDS,
+			rcode:  dns.RcodeSuccess,
+			aa:     true,
+			answer: []string{"sub.example.com. DS"},
+		},
+		{
+			name:   "cname chain to nxdomain",
+			qname:  "cname.example.com.",
+			qtype:  dns.TypeA,
+			rcode:  dns.RcodeNameError,
+			aa:     true,
+			answer: []string{"cname.example.com. CNAME", "alias.example.com. CNAME"},
+			ns:     []string{"example.com. SOA"},
+		},
+		{
+			name:   "apex ns is not a referral",
+			qname:  "example.com.",
+			qtype:  dns.TypeNS,
+			rcode:  dns.RcodeSuccess,
+			aa:     true,
+			answer: []string{"example.com. NS"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &dns.Msg{}
+			req.SetQuestion(test.qname, test.qtype)
+			resp, err := u.Exchange(simpleCore.Context(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rcode != test.rcode {
+				t.Errorf("rcode: got %s, want %s", dns.RcodeToString[resp.Rcode], dns.RcodeToString[test.rcode])
+			}
+			if resp.Authoritative != test.aa {
+				t.Errorf("aa: got %t, want %t", resp.Authoritative, test.aa)
+			}
+			if !equalRRInfos(resp.Answer, test.answer) {
+				t.Errorf("answer: got %v, want %v", rrInfos(resp.Answer), test.answer)
+			}
+			if !equalRRInfos(resp.Ns, test.ns) {
+				t.Errorf("authority: got %v, want %v", rrInfos(resp.Ns), test.ns)
+			}
+			if !equalRRInfos(resp.Extra, test.extra) {
+				t.Errorf("additional: got %v, want %v", rrInfos(resp.Extra), test.extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/utils"
+)
+
+// Circuit breaker based on real traffic: it opens after too many failures, rejects requests for open-timeout,
+// then lets half-open-requests requests through and closes if all of them succeed.
+
+const (
+	DefaultCircuitBreakerFailures         = 5
+	DefaultCircuitBreakerWindow           = 30 * time.Second
+	DefaultCircuitBreakerMinRequests      = 10
+	DefaultCircuitBreakerOpenTimeout      = 30 * time.Second
+	DefaultCircuitBreakerHalfOpenRequests = 1
+
+	circuitBuckets = 10
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+var circuitStateString = map[int]string{
+	circuitClosed:   "closed",
+	circuitOpen:     "open",
+	circuitHalfOpen: "half-open",
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitBreakerOptions struct {
+	Failures         int            `yaml:"failures"`
+	ErrorRate        float64        `yaml:"error-rate"`
+	Window           utils.Duration `yaml:"window"`
+	MinRequests      int            `yaml:"min-requests"`
+	OpenTimeout      utils.Duration `yaml:"open-timeout"`
+	HalfOpenRequests int            `yaml:"half-open-requests"`
+}
+
+type circuitBucket struct {
+	index    int64
+	total    int
+	failures int
+}
+
+type circuitBreaker struct {
+	failures         int
+	errorRate        float64
+	bucketDuration   time.Duration
+	minRequests      int
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	lock             sync.Mutex
+	state            int
+	consecutive      int
+	buckets          [circuitBuckets]circuitBucket
+	openedAt         time.Time
+	halfOpenInflight int
+	halfOpenSuccess  int
+
+	opens    atomic.Uint64
+	rejected atomic.Uint64
+}
+
+func newCircuitBreaker(options *CircuitBreakerOptions) (*circuitBreaker, error) {
+	b := &circuitBreaker{}
+	if options.Failures < 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker failures: %d", options.Failures)
+	}
+	b.failures = options.Failures
+	if options.ErrorRate < 0 || options.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid circuit-breaker error-rate: %v", options.ErrorRate)
+	}
+	b.errorRate = options.ErrorRate
+	if b.failures == 0 && b.errorRate == 0 {
+		b.failures = DefaultCircuitBreakerFailures
+	}
+	window := time.Duration(options.Window)
+	if window < 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker window: %s", window)
+	}
+	if window == 0 {
+		window = DefaultCircuitBreakerWindow
+	}
+	b.bucketDuration = window / circuitBuckets
+	if b.bucketDuration <= 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker window: %s", window)
+	}
+	b.minRequests = options.MinRequests
+	if b.minRequests < 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker min-requests: %d", b.minRequests)
+	}
+	if b.minRequests == 0 {
+		b.minRequests = DefaultCircuitBreakerMinRequests
+	}
+	b.openTimeout = time.Duration(options.OpenTimeout)
+	if b.openTimeout < 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker open-timeout: %s", b.openTimeout)
+	}
+	if b.openTimeout == 0 {
+		b.openTimeout = DefaultCircuitBreakerOpenTimeout
+	}
+	b.halfOpenRequests = options.HalfOpenRequests
+	if b.halfOpenRequests < 0 {
+		return nil, fmt.Errorf("invalid circuit-breaker half-open-requests: %d", b.halfOpenRequests)
+	}
+	if b.halfOpenRequests == 0 {
+		b.halfOpenRequests = DefaultCircuitBreakerHalfOpenRequests
+	}
+	return b, nil
+}
+
+// available reports whether a request would be allowed now, it does not take a half-open slot
+func (b *circuitBreaker) available() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case circuitOpen:
+		return time.Since(b.openedAt) >= b.openTimeout
+	case circuitHalfOpen:
+		return b.halfOpenInflight < b.halfOpenRequests
+	}
+	return true
+}
+
+// allow reports whether a request is allowed, probe is true if the request is a half-open probe
+func (b *circuitBreaker) allow() (probe bool, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == circuitOpen {
+		if time.Since(b.openedAt) < b.openTimeout {
+			b.rejected.Add(1)
+			return false, false
+		}
+		b.state = circuitHalfOpen
+		b.halfOpenInflight = 0
+		b.halfOpenSuccess = 0
+	}
+	if b.state == circuitHalfOpen {
+		if b.halfOpenInflight >= b.halfOpenRequests {
+			b.rejected.Add(1)
+			return false, false
+		}
+		b.halfOpenInflight++
+		return true, true
+	}
+	return false, true
+}
+
+// record records the result of an allowed request, canceled requests are not counted
+func (b *circuitBreaker) record(probe bool, err error) {
+	failed := err != nil
+	if failed && classifyError(err) == "canceled" {
+		if probe {
+			b.lock.Lock()
+			if b.state == circuitHalfOpen {
+				b.halfOpenInflight--
+			}
+			b.lock.Unlock()
+		}
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	if probe {
+		if b.state != circuitHalfOpen {
+			return
+		}
+		b.halfOpenInflight--
+		if failed {
+			b.open(now)
+			return
+		}
+		b.halfOpenSuccess++
+		if b.halfOpenSuccess >= b.halfOpenRequests {
+			b.state = circuitClosed
+			b.consecutive = 0
+			b.buckets = [circuitBuckets]circuitBucket{}
+		}
+		return
+	}
+	if b.state != circuitClosed {
+		// The request started before the circuit opened
+		return
+	}
+	index := now.UnixNano() / int64(b.bucketDuration)
+	bucket := &b.buckets[index%circuitBuckets]
+	if bucket.index != index {
+		*bucket = circuitBucket{index: index}
+	}
+	bucket.total++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	bucket.failures++
+	b.consecutive++
+	if b.failures > 0 && b.consecutive >= b.failures {
+		b.open(now)
+		return
+	}
+	if b.errorRate > 0 {
+		var total, failures int
+		for _, bucket := range b.buckets {
+			if index-bucket.index < circuitBuckets {
+				total += bucket.total
+				failures += bucket.failures
+			}
+		}
+		if total >= b.minRequests && float64(failures)/float64(total) >= b.errorRate {
+			b.open(now)
+		}
+	}
+}
+
+// open must be called with the lock held
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = circuitOpen
+	b.openedAt = now
+	b.opens.Add(1)
+}
+
+// currentState reads the state without changing it, an open circuit past open-timeout is half-open,
+// which the next request moves it to
+func (b *circuitBreaker) currentState() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == circuitOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return circuitHalfOpen
+	}
+	return b.state
+}
+
+func (b *circuitBreaker) stateString() string {
+	return circuitStateString[b.currentState()]
+}
+
+func (b *circuitBreaker) statisticalData() map[string]any {
+	return map[string]any{
+		"state":    b.stateString(),
+		"opens":    b.opens.Load(),
+		"rejected": b.rejected.Load(),
+	}
+}
+
+// Healthy reports whether the circuit breaker of the upstream lets requests through
+func (g *GenericUpstream) Healthy() bool {
+	return g.breaker == nil || g.breaker.available()
+}
+
+// isHealthy reports whether u is not tripped by its circuit breaker
+func isHealthy(u adapter.Upstream) bool {
+	h, ok := u.(interface{ Healthy() bool })
+	return !ok || h.Healthy()
+}
+
+// healthyUpstreams returns the upstreams which are not tripped, or all upstreams if all of them are tripped
+func healthyUpstreams(upstreams []adapter.Upstream) []adapter.Upstream {
+	healthy := make([]adapter.Upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if isHealthy(u) {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		return upstreams
+	}
+	return healthy
+}
@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+// coalescer shares one exchange between concurrent identical requests
+type coalescer struct {
+	lock      sync.Mutex
+	calls     map[string]*coalesceCall
+	coalesced atomic.Uint64
+}
+
+type coalesceCall struct {
+	done chan struct{}
+	resp *dns.Msg
+	err  error
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		calls: make(map[string]*coalesceCall),
+	}
+}
+
+// exchange calls exchangeFunc once for all concurrent requests with the same key, every caller gets a copy of the response with its own ID.
+// The shared exchange is not canceled by its first caller, so that the others can still use it.
+func (c *coalescer) exchange(ctx context.Context, req *dns.Msg, exchangeFunc func(ctx context.Context, req *dns.Msg) (*dns.Msg, error)) (*dns.Msg, error) {
+	key := coalesceKey(req)
+	if key == "" {
+		return exchangeFunc(ctx, req)
+	}
+	c.lock.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		call = &coalesceCall{
+			done: make(chan struct{}),
+		}
+		c.calls[key] = call
+		go c.doExchange(context.WithoutCancel(ctx), key, req.Copy(), call, exchangeFunc)
+	}
+	c.lock.Unlock()
+	if ok {
+		c.coalesced.Add(1)
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	resp := call.resp.Copy()
+	resp.Id = req.Id
+	return resp, nil
+}
+
+func (c *coalescer) doExchange(ctx context.Context, key string, req *dns.Msg, call *coalesceCall, exchangeFunc func(ctx context.Context, req *dns.Msg) (*dns.Msg, error)) {
+	call.resp, call.err = exchangeFunc(ctx, req)
+	c.lock.Lock()
+	delete(c.calls, key)
+	c.lock.Unlock()
+	close(call.done)
+}
+
+// coalesceKey is like the key of caches, with the RD bit, qclass and ECS
+func coalesceKey(req *dns.Msg) string {
+	if req.Response || req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		return ""
+	}
+	const (
+		adBit = 1 << iota
+		cdBit
+		doBit
+		rdBit
+	)
+
+	question := req.Question[0]
+	buf := make([]byte, 1+2+2+1+len(question.Name)) // bits + qtype + qclass + qname length + qname
+	b := byte(0)
+	if req.AuthenticatedData {
+		b = b | adBit
+	}
+	if req.CheckingDisabled {
+		b = b | cdBit
+	}
+	if req.RecursionDesired {
+		b = b | rdBit
+	}
+	if opt := req.IsEdns0(); opt != nil && opt.Do() {
+		b = b | doBit
+	}
+	buf[0] = b
+	buf[1] = byte(question.Qtype >> 8)
+	buf[2] = byte(question.Qtype)
+	buf[3] = byte(question.Qclass >> 8)
+	buf[4] = byte(question.Qclass)
+	buf[5] = byte(len(question.Name))
+	copy(buf[6:], question.Name)
+	key := utils.BytesToStringUnsafe(buf)
+	if subnet := utils.ECSOption(req); subnet != nil {
+		key = utils.ECSKey(key, subnet, subnet.SourceNetmask)
+	}
+	return key
+}
@@ -56,7 +56,7 @@ func newTLSConfig(options TLSOptions) (*tls.Config, error) {
 		for _, caFile := range options.ServerCAFile {
 			ca, err := os.ReadFile(caFile)
 			if err != nil {
-				return nil, fmt.Errorf("read server-ca-file failed: %s, error: %s", caFile, err)
+				return nil, fmt.Errorf("read server-ca-file failed: %s, error: %w", caFile, err)
 			}
 			if !caPool.AppendCertsFromPEM(ca) {
 				return nil, fmt.Errorf("append server-ca-file failed: %s", caFile)
@@ -70,7 +70,7 @@ func newTLSConfig(options TLSOptions) (*tls.Config, error) {
 	if options.ClientCertFile != "" && options.ClientKeyFile != "" {
 		certPair, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
 		if err != nil {
-			return nil, fmt.Errorf("load client-cert-file and client-key-file failed: %s", err)
+			return nil, fmt.Errorf("load client-cert-file and client-key-file failed: %w", err)
 		}
 		tlsConfig.Certificates = []tls.Certificate{certPair}
 	}
@@ -119,7 +119,7 @@ func (u *DHCPUpstream) Dependencies() []string {
 func (u *DHCPUpstream) Start() error {
 	err := u.fetchDNSUpstream(u.ctx)
 	if err != nil {
-		return fmt.Errorf("start dhcp upstream failed: %s", err)
+		return fmt.Errorf("start dhcp upstream failed: %w", err)
 	}
 	u.fetchCtx, u.fetchCancel = context.WithCancel(u.ctx)
 	u.fetchTaskGroup = utils.NewTaskGroup()
@@ -314,7 +314,7 @@ func (u *DHCPUpstream) flushDNSUpstream(ips []string) (err error) {
 		}
 		uu, err = NewUpstream(u.ctx, u.core, u.logger, options.Tag, options)
 		if err != nil {
-			return fmt.Errorf("create dhcp item upstream failed: %s", err)
+			return fmt.Errorf("create dhcp item upstream failed: %w", err)
 		}
 		starter, isStarter := uu.(adapter.Starter)
 		if isStarter {
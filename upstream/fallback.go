@@ -90,7 +90,7 @@ func (u *FallbackUpstream) Dependencies() []string {
 	return []string{u.mainUpstreamTag, u.fallbackUpstreamTag}
 }
 
-func (u *FallbackUpstream) Start() error {
+func (u *FallbackUpstream) Validate() error {
 	u.mainUpstream = u.core.GetUpstream(u.mainUpstreamTag)
 	if u.mainUpstream == nil {
 		return fmt.Errorf("upstream [%s] not found", u.mainUpstreamTag)
@@ -99,6 +99,14 @@ func (u *FallbackUpstream) Start() error {
 	if u.fallbackUpstream == nil {
 		return fmt.Errorf("upstream [%s] not found", u.fallbackUpstreamTag)
 	}
+	return nil
+}
+
+func (u *FallbackUpstream) Start() error {
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
 	u.healthy = true
 	u.callChan = make(chan struct{}, 1)
 	u.closeDone = make(chan struct{}, 1)
@@ -166,7 +174,7 @@ func (u *FallbackUpstream) check(ctx context.Context) error {
 }
 
 func (u *FallbackUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
-	if !u.healthy {
+	if !u.healthy || !isHealthy(u.mainUpstream) {
 		return u.fallbackUpstream.Exchange(ctx, req)
 	}
 	resp, err := u.mainUpstream.Exchange(ctx, req)
@@ -0,0 +1,231 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/domain"
+
+	"github.com/miekg/dns"
+)
+
+type ForwardZoneUpstreamZone struct {
+	Domains  utils.Listable[string] `yaml:"domains"`
+	Upstream string                 `yaml:"upstream"`
+}
+
+type ForwardZoneUpstreamOptions struct {
+	Zones   []ForwardZoneUpstreamZone `yaml:"zones"`
+	Default string                    `yaml:"default"`
+}
+
+const ForwardZoneUpstreamType = "forward-zone"
+
+var (
+	_ adapter.Upstream  = (*ForwardZoneUpstream)(nil)
+	_ adapter.Starter   = (*ForwardZoneUpstream)(nil)
+	_ adapter.Validator = (*ForwardZoneUpstream)(nil)
+)
+
+type forwardZone struct {
+	domains     *domain.DomainSet
+	upstreamTag string
+	upstream    adapter.Upstream
+	requests    atomic.Uint64
+}
+
+type ForwardZoneUpstream struct {
+	tag    string
+	core   adapter.Core
+	logger log.Logger
+
+	zones []*forwardZone
+	// defaultZone matches all domains
+	defaultZone *forwardZone
+
+	reqTotal   atomic.Uint64
+	reqSuccess atomic.Uint64
+}
+
+func NewForwardZoneUpstream(_ context.Context, core adapter.Core, logger log.Logger, tag string, options ForwardZoneUpstreamOptions) (adapter.Upstream, error) {
+	u := &ForwardZoneUpstream{
+		tag:    tag,
+		core:   core,
+		logger: logger,
+	}
+	if options.Default == "" {
+		return nil, fmt.Errorf("create forward-zone upstream failed: missing default")
+	}
+	u.defaultZone = &forwardZone{
+		upstreamTag: options.Default,
+	}
+	u.zones = make([]*forwardZone, 0, len(options.Zones))
+	for _, z := range options.Zones {
+		if z.Upstream == "" {
+			return nil, fmt.Errorf("create forward-zone upstream failed: missing upstream")
+		}
+		if len(z.Domains) == 0 {
+			return nil, fmt.Errorf("create forward-zone upstream failed: missing domains, upstream: %s", z.Upstream)
+		}
+		// Zones are matched label by label, so only full domains are added
+		builder := domain.NewDomainSetBuilder()
+		for _, d := range z.Domains {
+			names, err := zoneNames(d)
+			if err != nil {
+				return nil, fmt.Errorf("create forward-zone upstream failed: %w", err)
+			}
+			for _, name := range names {
+				builder.AddFull(name)
+			}
+		}
+		domains, err := builder.Build()
+		if err != nil {
+			return nil, fmt.Errorf("create forward-zone upstream failed: %w", err)
+		}
+		u.zones = append(u.zones, &forwardZone{
+			domains:     domains,
+			upstreamTag: z.Upstream,
+		})
+	}
+	return u, nil
+}
+
+// zoneNames returns the lower case zone without the trailing dot, a CIDR is converted to its reverse zones
+func zoneNames(s string) ([]string, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err == nil {
+		return reverseZones(prefix.Masked()), nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(s, "."))
+	if name == "" {
+		return nil, fmt.Errorf("invalid domain: %s", s)
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, fmt.Errorf("invalid domain: %s", s)
+	}
+	return []string{name}, nil
+}
+
+// reverseZones returns the in-addr.arpa or ip6.arpa zones which cover prefix,
+// the prefix length is rounded up to the octet (IPv4) or nibble (IPv6) boundary
+func reverseZones(prefix netip.Prefix) []string {
+	addr := prefix.Addr()
+	step, base, suffix := 8, 10, "in-addr.arpa"
+	if addr.Is6() {
+		step, base, suffix = 4, 16, "ip6.arpa"
+	}
+	bits := (prefix.Bits() + step - 1) / step * step
+	raw := addr.AsSlice()
+	values := make([]int, bits/step)
+	for i := range values {
+		if step == 8 {
+			values[i] = int(raw[i])
+		} else {
+			values[i] = int(raw[i/2]>>(4*(1-i%2))) & 0xf
+		}
+	}
+	n := 1 << (bits - prefix.Bits())
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		labels := make([]string, 0, len(values)+1)
+		for j := len(values) - 1; j >= 0; j-- {
+			v := values[j]
+			if j == len(values)-1 {
+				// The prefix is masked, so the host bits of the last label are zero
+				v += i
+			}
+			labels = append(labels, strconv.FormatInt(int64(v), base))
+		}
+		names = append(names, strings.Join(append(labels, suffix), "."))
+	}
+	return names
+}
+
+func (u *ForwardZoneUpstream) Tag() string {
+	return u.tag
+}
+
+func (u *ForwardZoneUpstream) Type() string {
+	return ForwardZoneUpstreamType
+}
+
+func (u *ForwardZoneUpstream) Dependencies() []string {
+	tags := make([]string, 0, len(u.zones)+1)
+	for _, z := range u.zones {
+		tags = append(tags, z.upstreamTag)
+	}
+	return append(tags, u.defaultZone.upstreamTag)
+}
+
+func (u *ForwardZoneUpstream) Start() error {
+	return u.Validate()
+}
+
+func (u *ForwardZoneUpstream) Validate() error {
+	for _, z := range append(u.zones, u.defaultZone) {
+		uu := u.core.GetUpstream(z.upstreamTag)
+		if uu == nil {
+			return fmt.Errorf("upstream [%s] not found", z.upstreamTag)
+		}
+		z.upstream = uu
+	}
+	return nil
+}
+
+// match returns the zone with the longest matched suffix, zones earlier in the options win on the same suffix
+func (u *ForwardZoneUpstream) match(name string) *forwardZone {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for name != "" {
+		for _, z := range u.zones {
+			if z.domains.Match(name) {
+				return z
+			}
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return u.defaultZone
+}
+
+func (u *ForwardZoneUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	z := u.defaultZone
+	if len(req.Question) > 0 {
+		z = u.match(req.Question[0].Name)
+	}
+	u.logger.DebugfContext(ctx, "forward-zone upstream [%s] selected", z.upstreamTag)
+	z.requests.Add(1)
+	return z.upstream.Exchange(ctx, req)
+}
+
+func (u *ForwardZoneUpstream) Exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.exchange(ctx, req)
+	u.reqTotal.Add(1)
+	if err == nil {
+		u.reqSuccess.Add(1)
+	}
+	return
+}
+
+func (u *ForwardZoneUpstream) StatisticalData() map[string]any {
+	total := u.reqTotal.Load()
+	success := u.reqSuccess.Load()
+	requests := make(map[string]uint64)
+	for _, z := range append(u.zones, u.defaultZone) {
+		requests[z.upstreamTag] += z.requests.Load()
+	}
+	return map[string]any{
+		"total":    total,
+		"success":  success,
+		"requests": requests,
+	}
+}
@@ -1,30 +1,46 @@
 package upstream
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"net/http"
 	"net/netip"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/utils"
 
 	"github.com/dlclark/regexp2"
+	"github.com/go-chi/chi/v5"
 	"github.com/miekg/dns"
 )
 
 type HostsUpstreamOptions struct {
-	Rule     map[string]utils.Listable[string] `yaml:"rule"`
-	Fallback string                            `yaml:"fallback"`
+	Rule          map[string]utils.Listable[string] `yaml:"rule"`
+	File          utils.Listable[string]            `yaml:"file"`
+	WatchInterval utils.Duration                    `yaml:"watch-interval,omitempty"`
+	Fallback      string                            `yaml:"fallback"`
 }
 
-const HostsUpstreamType = "hosts"
+const (
+	HostsUpstreamType = "hosts"
+
+	DefaultHostsWatchInterval = 10 * time.Second
+)
 
 var (
-	_ adapter.Upstream = (*HostsUpstream)(nil)
-	_ adapter.Starter  = (*HostsUpstream)(nil)
+	_ adapter.Upstream   = (*HostsUpstream)(nil)
+	_ adapter.Starter    = (*HostsUpstream)(nil)
+	_ adapter.Closer     = (*HostsUpstream)(nil)
+	_ adapter.Validator  = (*HostsUpstream)(nil)
+	_ adapter.APIHandler = (*HostsUpstream)(nil)
 )
 
 type HostsUpstream struct {
@@ -37,6 +53,10 @@ type HostsUpstream struct {
 	fallbackTag string
 	fallback    adapter.Upstream
 
+	files    []string
+	table    atomic.Pointer[hostsTable]
+	reloader *fileReloader
+
 	reqTotal   atomic.Uint64
 	reqSuccess atomic.Uint64
 }
@@ -48,6 +68,19 @@ type hostsRule struct {
 	ip   []netip.Prefix
 }
 
+// hostsTable is loaded from hosts files
+type hostsTable struct {
+	// names is keyed by lower case domain without the trailing dot
+	names map[string]*hostsEntry
+	// ptr maps an address to the first name of it
+	ptr map[netip.Addr]string
+}
+
+type hostsEntry struct {
+	ipv4 []netip.Addr
+	ipv6 []netip.Addr
+}
+
 func NewHostsUpstream(ctx context.Context, core adapter.Core, logger log.Logger, tag string, options HostsUpstreamOptions) (adapter.Upstream, error) {
 	u := &HostsUpstream{
 		ctx:    ctx,
@@ -55,8 +88,8 @@ func NewHostsUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 		core:   core,
 		logger: logger,
 	}
-	if len(options.Rule) == 0 {
-		return nil, fmt.Errorf("create hosts upstream failed: missing rule")
+	if len(options.Rule) == 0 && len(options.File) == 0 {
+		return nil, fmt.Errorf("create hosts upstream failed: missing rule or file")
 	}
 	rule := make([]*hostsRule, 0, len(options.Rule))
 	for k, v := range options.Rule {
@@ -107,6 +140,26 @@ func NewHostsUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 		})
 	}
 	u.rule = rule
+	if len(options.File) > 0 {
+		for _, f := range options.File {
+			if f == "" {
+				return nil, fmt.Errorf("create hosts upstream failed: missing file")
+			}
+		}
+		u.files = options.File
+		if options.WatchInterval < 0 {
+			return nil, fmt.Errorf("create hosts upstream failed: invalid watch-interval: %s", time.Duration(options.WatchInterval))
+		}
+		watchInterval := DefaultHostsWatchInterval
+		if options.WatchInterval > 0 {
+			watchInterval = time.Duration(options.WatchInterval)
+		}
+		u.reloader = newFileReloader(u.logger, "hosts files", u.files, watchInterval, u.reload)
+		err := u.reloader.Load()
+		if err != nil {
+			return nil, fmt.Errorf("create hosts upstream failed: %w", err)
+		}
+	}
 	if options.Fallback == "" {
 		return nil, fmt.Errorf("create hosts upstream failed: missing fallback")
 	}
@@ -122,7 +175,7 @@ func (u *HostsUpstream) Type() string {
 	return HostsUpstreamType
 }
 
-func (u *HostsUpstream) Start() error {
+func (u *HostsUpstream) Validate() error {
 	uu := u.core.GetUpstream(u.fallbackTag)
 	if uu == nil {
 		return fmt.Errorf("upstream [%s] not found", u.fallbackTag)
@@ -131,11 +184,219 @@ func (u *HostsUpstream) Start() error {
 	return nil
 }
 
+func (u *HostsUpstream) Start() error {
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
+	if u.reloader != nil {
+		u.reloader.Start(u.ctx)
+	}
+	return nil
+}
+
+func (u *HostsUpstream) Close() error {
+	if u.reloader != nil {
+		u.reloader.Close()
+	}
+	return nil
+}
+
 func (u *HostsUpstream) Dependencies() []string {
+	if u.fallbackTag == "" {
+		return nil
+	}
 	return []string{u.fallbackTag}
 }
 
+// reload loads all hosts files, the current table is kept if any of them fails.
+// It is called by the reloader.
+func (u *HostsUpstream) reload() error {
+	table := &hostsTable{
+		names: make(map[string]*hostsEntry),
+		ptr:   make(map[netip.Addr]string),
+	}
+	for _, file := range u.files {
+		n, err := table.loadFile(file)
+		if err != nil {
+			return fmt.Errorf("load hosts file failed: %s, error: %w", file, err)
+		}
+		u.logger.Infof("load hosts file: %s, entries: %d", file, n)
+	}
+	u.table.Store(table)
+	return nil
+}
+
+// loadFile adds the entries of a hosts file, lines which are not valid are skipped like the resolver of libc
+func (t *hostsTable) loadFile(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		ip = ip.WithZone("").Unmap()
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if _, ok := dns.IsDomainName(name); !ok || name == "" {
+				continue
+			}
+			entry := t.names[name]
+			if entry == nil {
+				entry = &hostsEntry{}
+				t.names[name] = entry
+			}
+			if ip.Is4() {
+				if !slices.Contains(entry.ipv4, ip) {
+					entry.ipv4 = append(entry.ipv4, ip)
+				}
+			} else {
+				if !slices.Contains(entry.ipv6, ip) {
+					entry.ipv6 = append(entry.ipv6, ip)
+				}
+			}
+			// Blocking entries such as 0.0.0.0 have no PTR answers
+			if _, ok := t.ptr[ip]; !ok && !ip.IsUnspecified() {
+				t.ptr[ip] = name
+			}
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+// exchange returns nil if the request is not answered by the table.
+// A name in the table is answered even without addresses of the query type, so that it is not resolved by the fallback.
+func (t *hostsTable) exchange(req *dns.Msg) *dns.Msg {
+	question := req.Question[0]
+	var answers []dns.RR
+	switch question.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		entry := t.names[strings.ToLower(strings.TrimSuffix(question.Name, "."))]
+		if entry == nil {
+			return nil
+		}
+		ips := entry.ipv4
+		if question.Qtype == dns.TypeAAAA {
+			ips = entry.ipv6
+		}
+		answers = make([]dns.RR, 0, len(ips))
+		for _, ip := range ips {
+			answers = append(answers, hostsRecord(question.Name, ip))
+		}
+	case dns.TypePTR:
+		ip, ok := reverseAddr(question.Name)
+		if !ok {
+			return nil
+		}
+		name, ok := t.ptr[ip]
+		if !ok {
+			return nil
+		}
+		answers = []dns.RR{
+			&dns.PTR{
+				Hdr: dns.RR_Header{
+					Name:   question.Name,
+					Rrtype: dns.TypePTR,
+					Class:  dns.ClassINET,
+					Ttl:    600,
+				},
+				Ptr: dns.Fqdn(name),
+			},
+		}
+	default:
+		return nil
+	}
+	respMsg := &dns.Msg{}
+	respMsg.SetReply(req)
+	respMsg.Answer = answers
+	return respMsg
+}
+
+// reverseAddr returns the address of an in-addr.arpa or ip6.arpa name
+func reverseAddr(name string) (netip.Addr, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if s, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+		var b [4]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			b[3-i] = byte(v)
+		}
+		return netip.AddrFrom4(b), true
+	}
+	if s, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 32 {
+			return netip.Addr{}, false
+		}
+		var b [16]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Addr{}, false
+			}
+			// labels start from the lowest nibble
+			n := 31 - i
+			b[n/2] |= byte(v) << (4 * (1 - n%2))
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
+
+// hostsRecord returns an A or AAAA record of ip
+func hostsRecord(name string, ip netip.Addr) dns.RR {
+	if ip.Is4() {
+		return &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    600,
+			},
+			A: ip.AsSlice(),
+		}
+	}
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    600,
+		},
+		AAAA: ip.AsSlice(),
+	}
+}
+
 func (u *HostsUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if table := u.table.Load(); table != nil {
+		respMsg := table.exchange(req)
+		if respMsg != nil {
+			return respMsg, nil
+		}
+	}
 	question := req.Question[0]
 	qName := question.Name
 	qName = strings.TrimSuffix(qName, ".")
@@ -146,37 +407,14 @@ func (u *HostsUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, e
 			if (qType == dns.TypeA && r.ipv4) || (qType == dns.TypeAAAA && r.ipv6) {
 				answers := make([]dns.RR, 0, len(r.ip))
 				for _, p := range r.ip {
-					var record dns.RR
 					ip := p.Addr()
-					if qType == dns.TypeA && ip.Is4() {
-						if p.Bits() != 32 {
-							ip = utils.RandomAddrFromPrefix(p)
-						}
-						record = &dns.A{
-							Hdr: dns.RR_Header{
-								Name:   qName,
-								Rrtype: dns.TypeA,
-								Class:  dns.ClassINET,
-								Ttl:    600,
-							},
-							A: ip.AsSlice(),
-						}
+					if ip.Is4() != (qType == dns.TypeA) {
+						continue
 					}
-					if qType == dns.TypeAAAA && ip.Is6() {
-						if p.Bits() != 128 {
-							ip = utils.RandomAddrFromPrefix(p)
-						}
-						record = &dns.AAAA{
-							Hdr: dns.RR_Header{
-								Name:   qName,
-								Rrtype: dns.TypeAAAA,
-								Class:  dns.ClassINET,
-								Ttl:    600,
-							},
-							AAAA: ip.AsSlice(),
-						}
+					if p.Bits() != ip.BitLen() {
+						ip = utils.RandomAddrFromPrefix(p)
 					}
-					answers = append(answers, record)
+					answers = append(answers, hostsRecord(question.Name, ip))
 				}
 				respMsg := &dns.Msg{}
 				respMsg.SetReply(req)
@@ -200,8 +438,28 @@ func (u *HostsUpstream) Exchange(ctx context.Context, req *dns.Msg) (resp *dns.M
 func (u *HostsUpstream) StatisticalData() map[string]any {
 	total := u.reqTotal.Load()
 	success := u.reqSuccess.Load()
-	return map[string]any{
+	data := map[string]any{
 		"total":   total,
 		"success": success,
 	}
+	if table := u.table.Load(); table != nil {
+		data["names"] = len(table.names)
+		data["reloads"] = u.reloader.Reloads()
+	}
+	return data
+}
+
+// APIHandler returns nil if file is not set
+func (u *HostsUpstream) APIHandler() chi.Router {
+	if u.reloader == nil {
+		return nil
+	}
+	builder := utils.NewChiRouterBuilder()
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:        "/reload",
+		Methods:     []string{http.MethodPost},
+		Description: "reload hosts files",
+		Handler:     u.reloader.APIHandler(),
+	})
+	return builder.Build()
 }
@@ -89,18 +89,18 @@ func NewHTTPSUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 	}
 	socksAddr, err := common.NewSocksAddrFromStringWithDefaultPort(options.Address, 443)
 	if err != nil {
-		return nil, fmt.Errorf("create https upstream failed: invalid address: %s, error: %s", options.Address, err)
+		return nil, fmt.Errorf("create https upstream failed: invalid address: %s, error: %w", options.Address, err)
 	}
 	u.address = *socksAddr
 	dialer, err := network.NewDialer(options.DialerOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create https upstream failed: create dialer: %s", err)
+		return nil, fmt.Errorf("create https upstream failed: create dialer: %w", err)
 	}
 	u.dialer = dialer
 	if options.BootstrapOptions != nil {
 		b, err := bootstrap.NewBootstrap(ctx, core, *options.BootstrapOptions)
 		if err != nil {
-			return nil, fmt.Errorf("create https upstream failed: create bootstrap: %s", err)
+			return nil, fmt.Errorf("create https upstream failed: create bootstrap: %w", err)
 		}
 		u.bootstrap = b
 	}
@@ -178,7 +178,7 @@ func NewHTTPSUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 	u.url = *uri
 	tlsConfig, err := newTLSConfig(options.TLSOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create https upstream failed: create tls config: %s", err)
+		return nil, fmt.Errorf("create https upstream failed: create tls config: %w", err)
 	}
 	tlsConfig.Time = core.GetTimeFunc()
 	if tlsConfig.ServerName == "" {
@@ -220,7 +220,7 @@ func (u *HTTPSUpstream) Start() error {
 	if u.bootstrap != nil {
 		err := u.bootstrap.Start()
 		if err != nil {
-			return fmt.Errorf("start bootstrap failed: %s", err)
+			return fmt.Errorf("start bootstrap failed: %w", err)
 		}
 	}
 	var httpTransport http.RoundTripper
@@ -286,7 +286,7 @@ func (u *HTTPSUpstream) newTCPConn(ctx context.Context) (net.Conn, error) {
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, _, err := network.DialParallel(ctx, u.dialer, "tcp", ips, u.address.Port())
 			return conn, err
@@ -301,7 +301,7 @@ func (u *HTTPSUpstream) newUDPPacketConn(ctx context.Context) (net.PacketConn, n
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, ip, err := network.ListenPacketParallel(ctx, u.dialer, ips, u.address.Port())
 			return conn, &net.UDPAddr{IP: ip.AsSlice(), Port: int(u.address.Port())}, err
@@ -317,7 +317,7 @@ func (u *HTTPSUpstream) newUDPPacketConn(ctx context.Context) (net.PacketConn, n
 func (u *HTTPSUpstream) newGETRequest(req *dns.Msg) (*http.Request, error) {
 	raw, err := req.Pack()
 	if err != nil {
-		return nil, fmt.Errorf("pack dns message failed: %s", err)
+		return nil, fmt.Errorf("pack dns message failed: %w", err)
 	}
 	uri := u.url
 	q := uri.Query()
@@ -325,7 +325,7 @@ func (u *HTTPSUpstream) newGETRequest(req *dns.Msg) (*http.Request, error) {
 	uri.RawQuery = q.Encode()
 	httpReq, err := http.NewRequest(http.MethodGet, uri.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("create http request failed: %s", err)
+		return nil, fmt.Errorf("create http request failed: %w", err)
 	}
 	if u.headers != nil {
 		httpReq.Header = u.headers.Clone()
@@ -341,12 +341,12 @@ func (u *HTTPSUpstream) newGETRequest(req *dns.Msg) (*http.Request, error) {
 func (u *HTTPSUpstream) newPOSTRequest(req *dns.Msg) (*http.Request, error) {
 	raw, err := req.Pack()
 	if err != nil {
-		return nil, fmt.Errorf("pack dns message failed: %s", err)
+		return nil, fmt.Errorf("pack dns message failed: %w", err)
 	}
 	uri := u.url
 	httpReq, err := http.NewRequest(http.MethodPost, uri.String(), bytes.NewReader(raw))
 	if err != nil {
-		return nil, fmt.Errorf("create http request failed: %s", err)
+		return nil, fmt.Errorf("create http request failed: %w", err)
 	}
 	if u.headers != nil {
 		httpReq.Header = u.headers.Clone()
@@ -375,7 +375,7 @@ func (u *HTTPSUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, e
 
 	httpResp, err := u.httpClient.Do(httpReq.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("send http request failed: %s", err)
+		return nil, fmt.Errorf("send http request failed: %w", err)
 	}
 
 	if httpResp.StatusCode != http.StatusOK {
@@ -387,7 +387,7 @@ func (u *HTTPSUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, e
 	_, err = io.Copy(buffer, httpResp.Body)
 	httpResp.Body.Close()
 	if err != nil {
-		return nil, fmt.Errorf("read http response failed: %s", err)
+		return nil, fmt.Errorf("read http response failed: %w", err)
 	}
 
 	resp := &dns.Msg{}
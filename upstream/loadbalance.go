@@ -0,0 +1,339 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	LoadBalanceStrategyRoundRobin       = "round-robin"
+	LoadBalanceStrategyLeastOutstanding = "least-outstanding"
+	LoadBalanceStrategyEWMA             = "ewma"
+
+	DefaultLoadBalanceEWMADecay = 10 * time.Second
+	// loadBalanceFailurePenalty is the least latency recorded for a failed exchange
+	loadBalanceFailurePenalty = time.Second
+)
+
+type LoadBalanceUpstreamMember struct {
+	Tag    string `yaml:"tag"`
+	Weight int    `yaml:"weight,omitempty"`
+}
+
+type _LoadBalanceUpstreamMember LoadBalanceUpstreamMember
+
+// UnmarshalYAML accepts a tag, or a tag with weight
+func (m *LoadBalanceUpstreamMember) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tag string
+	err := unmarshal(&tag)
+	if err == nil {
+		m.Tag = tag
+		return nil
+	}
+	var _m _LoadBalanceUpstreamMember
+	err = unmarshal(&_m)
+	if err != nil {
+		return err
+	}
+	*m = LoadBalanceUpstreamMember(_m)
+	return nil
+}
+
+type LoadBalanceUpstreamOptions struct {
+	Upstreams []LoadBalanceUpstreamMember `yaml:"upstreams"`
+	Strategy  string                      `yaml:"strategy,omitempty"`
+	EWMADecay utils.Duration              `yaml:"ewma-decay,omitempty"`
+}
+
+const LoadBalanceUpstreamType = "loadbalance"
+
+var (
+	_ adapter.Upstream  = (*LoadBalanceUpstream)(nil)
+	_ adapter.Starter   = (*LoadBalanceUpstream)(nil)
+	_ adapter.Validator = (*LoadBalanceUpstream)(nil)
+)
+
+type loadBalanceMember struct {
+	tag      string
+	weight   int
+	upstream adapter.Upstream
+
+	// used by round-robin, guarded by the lock of LoadBalanceUpstream
+	currentWeight int
+
+	outstanding atomic.Int64
+	requests    atomic.Uint64
+
+	ewmaLock sync.Mutex
+	ewma     float64 // nanoseconds
+	ewmaTime time.Time
+}
+
+// observe updates the EWMA latency, the weight of an observation grows with the time since the last one
+func (m *loadBalanceMember) observe(latency time.Duration, decay time.Duration) {
+	m.ewmaLock.Lock()
+	defer m.ewmaLock.Unlock()
+	now := time.Now()
+	if m.ewmaTime.IsZero() {
+		m.ewma = float64(latency)
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(m.ewmaTime))/float64(decay))
+		m.ewma += alpha * (float64(latency) - m.ewma)
+	}
+	m.ewmaTime = now
+}
+
+func (m *loadBalanceMember) latency() float64 {
+	m.ewmaLock.Lock()
+	defer m.ewmaLock.Unlock()
+	return m.ewma
+}
+
+type LoadBalanceUpstream struct {
+	tag    string
+	core   adapter.Core
+	logger log.Logger
+
+	strategy  string
+	ewmaDecay time.Duration
+	members   []*loadBalanceMember
+	lock      sync.Mutex
+
+	reqTotal   atomic.Uint64
+	reqSuccess atomic.Uint64
+}
+
+func NewLoadBalanceUpstream(_ context.Context, core adapter.Core, logger log.Logger, tag string, options LoadBalanceUpstreamOptions) (adapter.Upstream, error) {
+	u := &LoadBalanceUpstream{
+		tag:    tag,
+		core:   core,
+		logger: logger,
+	}
+	if len(options.Upstreams) == 0 {
+		return nil, fmt.Errorf("create loadbalance upstream failed: missing upstreams")
+	}
+	u.members = make([]*loadBalanceMember, 0, len(options.Upstreams))
+	for _, m := range options.Upstreams {
+		if m.Tag == "" {
+			return nil, fmt.Errorf("create loadbalance upstream failed: missing upstream tag")
+		}
+		if m.Weight < 0 {
+			return nil, fmt.Errorf("create loadbalance upstream failed: invalid weight: %d, upstream: %s", m.Weight, m.Tag)
+		}
+		weight := m.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		u.members = append(u.members, &loadBalanceMember{
+			tag:    m.Tag,
+			weight: weight,
+		})
+	}
+	switch options.Strategy {
+	case "":
+		u.strategy = LoadBalanceStrategyRoundRobin
+	case LoadBalanceStrategyRoundRobin, LoadBalanceStrategyLeastOutstanding, LoadBalanceStrategyEWMA:
+		u.strategy = options.Strategy
+	default:
+		return nil, fmt.Errorf("create loadbalance upstream failed: invalid strategy: %s", options.Strategy)
+	}
+	if options.EWMADecay < 0 {
+		return nil, fmt.Errorf("create loadbalance upstream failed: invalid ewma-decay: %s", time.Duration(options.EWMADecay))
+	}
+	if options.EWMADecay > 0 {
+		u.ewmaDecay = time.Duration(options.EWMADecay)
+	} else {
+		u.ewmaDecay = DefaultLoadBalanceEWMADecay
+	}
+	return u, nil
+}
+
+func (u *LoadBalanceUpstream) Tag() string {
+	return u.tag
+}
+
+func (u *LoadBalanceUpstream) Type() string {
+	return LoadBalanceUpstreamType
+}
+
+func (u *LoadBalanceUpstream) Dependencies() []string {
+	tags := make([]string, 0, len(u.members))
+	for _, m := range u.members {
+		tags = append(tags, m.tag)
+	}
+	return tags
+}
+
+func (u *LoadBalanceUpstream) Start() error {
+	return u.Validate()
+}
+
+func (u *LoadBalanceUpstream) Validate() error {
+	for _, m := range u.members {
+		uu := u.core.GetUpstream(m.tag)
+		if uu == nil {
+			return fmt.Errorf("upstream [%s] not found", m.tag)
+		}
+		m.upstream = uu
+	}
+	return nil
+}
+
+// healthyMembers returns the members which are not tripped by their circuit breakers, or all members if all of them are tripped
+func (u *LoadBalanceUpstream) healthyMembers() []*loadBalanceMember {
+	healthy := make([]*loadBalanceMember, 0, len(u.members))
+	for _, m := range u.members {
+		if isHealthy(m.upstream) {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(healthy) == 0 {
+		return u.members
+	}
+	return healthy
+}
+
+func (u *LoadBalanceUpstream) pick() *loadBalanceMember {
+	members := u.healthyMembers()
+	switch u.strategy {
+	case LoadBalanceStrategyLeastOutstanding:
+		return u.pickByCost(members, func(m *loadBalanceMember) float64 {
+			return float64(m.outstanding.Load())
+		})
+	case LoadBalanceStrategyEWMA:
+		return u.pickEWMA(members)
+	default:
+		return u.pickRoundRobin(members)
+	}
+}
+
+// pickRoundRobin is the smooth weighted round-robin of nginx
+func (u *LoadBalanceUpstream) pickRoundRobin(members []*loadBalanceMember) *loadBalanceMember {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	var (
+		best  *loadBalanceMember
+		total int
+	)
+	for _, m := range members {
+		m.currentWeight += m.weight
+		total += m.weight
+		if best == nil || m.currentWeight > best.currentWeight {
+			best = m
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// pickByCost picks the member with the lowest cost per weight, ties are broken by round-robin
+func (u *LoadBalanceUpstream) pickByCost(members []*loadBalanceMember, cost func(m *loadBalanceMember) float64) *loadBalanceMember {
+	var (
+		candidates []*loadBalanceMember
+		lowest     float64
+	)
+	for _, m := range members {
+		c := cost(m) / float64(m.weight)
+		switch {
+		case candidates == nil || c < lowest:
+			candidates = []*loadBalanceMember{m}
+			lowest = c
+		case c == lowest:
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return u.pickRoundRobin(candidates)
+}
+
+// pickEWMA picks a member at random, weighted by weight / (latency * (outstanding + 1)), so that faster members get more requests
+// and slower ones are still measured. Members without latency are picked first, so that every member is measured.
+func (u *LoadBalanceUpstream) pickEWMA(members []*loadBalanceMember) *loadBalanceMember {
+	var (
+		unmeasured []*loadBalanceMember
+		scores     = make([]float64, len(members))
+		total      float64
+	)
+	for i, m := range members {
+		latency := m.latency()
+		if latency == 0 {
+			unmeasured = append(unmeasured, m)
+			continue
+		}
+		scores[i] = float64(m.weight) / (latency * float64(m.outstanding.Load()+1))
+		total += scores[i]
+	}
+	if len(unmeasured) > 0 {
+		return u.pickRoundRobin(unmeasured)
+	}
+	r := rand.Float64() * total
+	for i, m := range members {
+		r -= scores[i]
+		if r < 0 {
+			return m
+		}
+	}
+	return members[len(members)-1]
+}
+
+func (u *LoadBalanceUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	m := u.pick()
+	u.logger.DebugfContext(ctx, "loadbalance upstream [%s] selected", m.tag)
+	m.requests.Add(1)
+	m.outstanding.Add(1)
+	defer m.outstanding.Add(-1)
+	startTime := time.Now()
+	resp, err := m.upstream.Exchange(ctx, req)
+	latency := time.Since(startTime)
+	if err != nil {
+		if classifyError(err) == "canceled" {
+			return nil, err
+		}
+		latency = max(latency, loadBalanceFailurePenalty)
+	}
+	m.observe(latency, u.ewmaDecay)
+	return resp, err
+}
+
+func (u *LoadBalanceUpstream) Exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.exchange(ctx, req)
+	u.reqTotal.Add(1)
+	if err == nil {
+		u.reqSuccess.Add(1)
+	}
+	return
+}
+
+func (u *LoadBalanceUpstream) StatisticalData() map[string]any {
+	total := u.reqTotal.Load()
+	success := u.reqSuccess.Load()
+	members := make([]map[string]any, 0, len(u.members))
+	for _, m := range u.members {
+		members = append(members, map[string]any{
+			"tag":         m.tag,
+			"weight":      m.weight,
+			"requests":    m.requests.Load(),
+			"outstanding": m.outstanding.Load(),
+			"latency":     time.Duration(m.latency()).String(),
+		})
+	}
+	return map[string]any{
+		"total":    total,
+		"success":  success,
+		"strategy": u.strategy,
+		"members":  members,
+	}
+}
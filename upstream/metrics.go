@@ -0,0 +1,139 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rnetx/cdns/utils/metrics"
+
+	"github.com/miekg/dns"
+)
+
+type upstreamMetrics struct {
+	total     atomic.Uint64
+	success   atomic.Uint64
+	errors    *metrics.CounterVec
+	responses *metrics.CounterVec
+	duration  *metrics.Histogram
+	retries   atomic.Uint64
+}
+
+func newUpstreamMetrics() *upstreamMetrics {
+	return &upstreamMetrics{
+		errors:    metrics.NewCounterVec(),
+		responses: metrics.NewCounterVec(),
+		duration:  metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+	}
+}
+
+func (m *upstreamMetrics) observe(d time.Duration, resp *dns.Msg, err error) {
+	m.total.Add(1)
+	m.duration.Observe(d)
+	if err != nil {
+		m.errors.Inc(classifyError(err))
+		return
+	}
+	m.success.Add(1)
+	if resp != nil {
+		rcode, ok := dns.RcodeToString[resp.Rcode]
+		if !ok {
+			rcode = strconv.Itoa(resp.Rcode)
+		}
+		m.responses.Inc(rcode)
+	}
+}
+
+// classifyError returns the error class: timeout, canceled, refused, tls, malformed, rcode, network or other
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	}
+	var (
+		rcodeErr       *RcodeError
+		dnsErr         *dns.Error
+		recordErr      tls.RecordHeaderError
+		alertErr       tls.AlertError
+		certErr        *tls.CertificateVerificationError
+		unknownAuthErr x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		invalidCertErr x509.CertificateInvalidError
+		netErr         net.Error
+	)
+	switch {
+	case errors.As(err, &rcodeErr):
+		return "rcode"
+	case errors.As(err, &dnsErr):
+		return "malformed"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr), errors.As(err, &invalidCertErr):
+		return "tls"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	// Most handshake errors of crypto/tls are not typed
+	if strings.Contains(err.Error(), "tls: ") {
+		return "tls"
+	}
+	return "other"
+}
+
+func (g *GenericUpstream) CollectMetrics(w *metrics.Writer) {
+	tag := metrics.L("upstream", g.Tag())
+	w.Counter("cdns_upstream_requests_total", "Total number of upstream exchanges.", g.metrics.total.Load(), tag)
+	w.Counter("cdns_upstream_success_total", "Total number of successful upstream exchanges.", g.metrics.success.Load(), tag)
+	g.metrics.errors.Range(func(class string, value uint64) {
+		w.Counter("cdns_upstream_errors_total", "Total number of failed upstream exchanges by error class.", value, tag, metrics.L("class", class))
+	})
+	g.metrics.responses.Range(func(rcode string, value uint64) {
+		w.Counter("cdns_upstream_responses_total", "Total number of upstream responses by rcode.", value, tag, metrics.L("rcode", rcode))
+	})
+	w.Histogram("cdns_upstream_request_duration_seconds", "Upstream exchange duration.", g.metrics.duration, tag)
+	w.Counter("cdns_upstream_retries_total", "Total number of upstream exchange retries.", g.metrics.retries.Load(), tag)
+	if g.breaker != nil {
+		var open float64
+		if g.breaker.currentState() == circuitOpen {
+			open = 1
+		}
+		w.Gauge("cdns_upstream_circuit_open", "Whether the circuit breaker of the upstream is open.", open, tag)
+		w.Counter("cdns_upstream_circuit_opens_total", "Total number of times the circuit breaker opens.", g.breaker.opens.Load(), tag)
+		w.Counter("cdns_upstream_circuit_rejected_total", "Total number of requests rejected by the open circuit breaker.", g.breaker.rejected.Load(), tag)
+	}
+	if g.coalescer != nil {
+		w.Counter("cdns_upstream_coalesced_total", "Total number of requests which share an in-flight exchange.", g.coalescer.coalesced.Load(), tag)
+	}
+}
+
+// StatisticalData adds the errors by class and the retries to the data of the upstream
+func (g *GenericUpstream) StatisticalData() map[string]any {
+	data := make(map[string]any)
+	for k, v := range g.Upstream.StatisticalData() {
+		data[k] = v
+	}
+	errs := make(map[string]uint64)
+	g.metrics.errors.Range(func(class string, value uint64) {
+		errs[class] = value
+	})
+	data["errors"] = errs
+	data["retries"] = g.metrics.retries.Load()
+	if g.breaker != nil {
+		data["circuit_breaker"] = g.breaker.statisticalData()
+	}
+	return data
+}
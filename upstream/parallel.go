@@ -75,9 +75,10 @@ func (u *ParallelUpstream) Start() error {
 func (u *ParallelUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	ch := utils.NewSafeChan[utils.Result[*dns.Msg]](len(u.upstreams))
+	upstreams := healthyUpstreams(u.upstreams)
+	ch := utils.NewSafeChan[utils.Result[*dns.Msg]](len(upstreams))
 	defer ch.Close()
-	for _, uu := range u.upstreams {
+	for _, uu := range upstreams {
 		go func(uu adapter.Upstream, ch *utils.SafeChan[utils.Result[*dns.Msg]]) {
 			defer ch.Close()
 			resp, err := uu.Exchange(ctx, req)
@@ -95,7 +96,7 @@ func (u *ParallelUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg
 		}(uu, ch.Clone())
 	}
 	var lastErr error
-	for i := 0; i < len(u.upstreams); i++ {
+	for i := 0; i < len(upstreams); i++ {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -207,6 +207,16 @@ func (u *QueryTestUpstream) test(ctx context.Context) {
 
 func (u *QueryTestUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
 	selected := u.selected
+	if !isHealthy(selected) {
+		// Use the first healthy upstream until the selected one recovers
+		for _, uu := range u.upstreams {
+			if isHealthy(uu) {
+				u.logger.DebugfContext(ctx, "selected upstream [%s] is unhealthy, use upstream [%s]", selected.Tag(), uu.Tag())
+				selected = uu
+				break
+			}
+		}
+	}
 	u.logger.DebugfContext(ctx, "selected upstream: %s", selected.Tag())
 	return selected.Exchange(ctx, req)
 }
@@ -76,18 +76,18 @@ func NewQUICUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 	}
 	socksAddr, err := common.NewSocksAddrFromStringWithDefaultPort(options.Address, 853)
 	if err != nil {
-		return nil, fmt.Errorf("create quic upstream failed: invalid address: %s, error: %s", options.Address, err)
+		return nil, fmt.Errorf("create quic upstream failed: invalid address: %s, error: %w", options.Address, err)
 	}
 	u.address = *socksAddr
 	dialer, err := network.NewDialer(options.DialerOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create quic upstream failed: create dialer: %s", err)
+		return nil, fmt.Errorf("create quic upstream failed: create dialer: %w", err)
 	}
 	u.dialer = dialer
 	if options.BootstrapOptions != nil {
 		b, err := bootstrap.NewBootstrap(ctx, core, *options.BootstrapOptions)
 		if err != nil {
-			return nil, fmt.Errorf("create quic upstream failed: create bootstrap: %s", err)
+			return nil, fmt.Errorf("create quic upstream failed: create bootstrap: %w", err)
 		}
 		u.bootstrap = b
 	}
@@ -106,7 +106,7 @@ func NewQUICUpstream(ctx context.Context, core adapter.Core, logger log.Logger,
 	}
 	tlsConfig, err := newTLSConfig(options.TLSOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create quic upstream failed: create tls config: %s", err)
+		return nil, fmt.Errorf("create quic upstream failed: create tls config: %w", err)
 	}
 	tlsConfig.Time = core.GetTimeFunc()
 	if tlsConfig.ServerName == "" {
@@ -142,7 +142,7 @@ func (u *QUICUpstream) Start() error {
 	if u.bootstrap != nil {
 		err := u.bootstrap.Start()
 		if err != nil {
-			return fmt.Errorf("start bootstrap failed: %s", err)
+			return fmt.Errorf("start bootstrap failed: %w", err)
 		}
 	}
 	u.loopCtx, u.loopCancel = context.WithCancel(u.ctx)
@@ -200,7 +200,7 @@ func (u *QUICUpstream) newUDPPacketConn(ctx context.Context) (net.PacketConn, ne
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, ip, err := network.ListenPacketParallel(ctx, u.dialer, ips, u.address.Port())
 			return conn, &net.UDPAddr{IP: ip.AsSlice(), Port: int(u.address.Port())}, err
@@ -258,12 +258,12 @@ func (u *QUICUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, er
 	}
 	conn, err := u.getQUICConnection(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("get quic connection failed: %s", err)
+		return nil, fmt.Errorf("get quic connection failed: %w", err)
 	}
 	defer conn.Close()
 	stream, err := conn.NewQUICStream(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("create quic stream failed: %s", err)
+		return nil, fmt.Errorf("create quic stream failed: %w", err)
 	}
 	buffer := bytes.NewBuffer(make([]byte, 0, len(raw)+2))
 	binary.Write(buffer, binary.BigEndian, uint16(len(raw)))
@@ -275,17 +275,17 @@ func (u *QUICUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, er
 	err = stream.SetDeadline(deadline)
 	if err != nil {
 		stream.Close()
-		return nil, fmt.Errorf("set quic stream deadline failed: %s", err)
+		return nil, fmt.Errorf("set quic stream deadline failed: %w", err)
 	}
 	_, err = stream.Write(buffer.Bytes())
 	if err != nil {
 		stream.Close()
-		return nil, fmt.Errorf("write quic stream failed: %s", err)
+		return nil, fmt.Errorf("write quic stream failed: %w", err)
 	}
 	stream.Close()
 	buf, err := io.ReadAll(stream)
 	if err != nil {
-		return nil, fmt.Errorf("read quic stream failed: %s", err)
+		return nil, fmt.Errorf("read quic stream failed: %w", err)
 	}
 	resp := &dns.Msg{}
 	err = resp.Unpack(buf[2:])
@@ -74,9 +74,10 @@ func (u *RandomUpstream) Start() error {
 }
 
 func (u *RandomUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	upstreams := healthyUpstreams(u.upstreams)
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	index := r.Intn(len(u.upstreams))
-	uu := u.upstreams[index]
+	index := r.Intn(len(upstreams))
+	uu := upstreams[index]
 	u.logger.DebugfContext(ctx, "random upstream [%s] selected", uu.Tag())
 	return uu.Exchange(ctx, req)
 }
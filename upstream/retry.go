@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/miekg/dns"
+)
+
+type RetryOptions struct {
+	Attempts int                    `yaml:"attempts"`
+	Backoff  utils.Duration         `yaml:"backoff"`
+	Jitter   utils.Duration         `yaml:"jitter"`
+	RCodes   utils.Listable[string] `yaml:"rcodes"`
+}
+
+// RcodeError is returned when the response rcode is configured as a failure
+type RcodeError struct {
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("unexpected rcode: %s", dns.RcodeToString[e.Rcode])
+}
+
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	jitter   time.Duration
+	rcodes   map[int]bool
+}
+
+func newRetryPolicy(options *RetryOptions, attempts int) (*retryPolicy, error) {
+	p := &retryPolicy{
+		attempts: attempts,
+	}
+	if options == nil {
+		return p, nil
+	}
+	if options.Attempts < 0 {
+		return nil, fmt.Errorf("invalid retry attempts: %d", options.Attempts)
+	}
+	if options.Attempts > 0 {
+		p.attempts = options.Attempts
+	}
+	if options.Backoff < 0 {
+		return nil, fmt.Errorf("invalid retry backoff: %s", time.Duration(options.Backoff))
+	}
+	p.backoff = time.Duration(options.Backoff)
+	if options.Jitter < 0 {
+		return nil, fmt.Errorf("invalid retry jitter: %s", time.Duration(options.Jitter))
+	}
+	p.jitter = time.Duration(options.Jitter)
+	if len(options.RCodes) > 0 {
+		p.rcodes = make(map[int]bool, len(options.RCodes))
+		for _, s := range options.RCodes {
+			rcode, ok := dns.StringToRcode[strings.ToUpper(s)]
+			if !ok || rcode == dns.RcodeSuccess {
+				return nil, fmt.Errorf("invalid retry rcode: %s", s)
+			}
+			p.rcodes[rcode] = true
+		}
+	}
+	return p, nil
+}
+
+// checkResponse returns an RcodeError if the rcode of resp is configured as a failure
+func (p *retryPolicy) checkResponse(resp *dns.Msg) error {
+	if p.rcodes != nil && p.rcodes[resp.Rcode] {
+		return &RcodeError{Rcode: resp.Rcode}
+	}
+	return nil
+}
+
+// wait sleeps before the n-th retry (from 1), the backoff is doubled on every retry and a random jitter is added.
+// It returns false if ctx is done.
+func (p *retryPolicy) wait(ctx context.Context, n int) bool {
+	d := p.backoff << (n - 1)
+	if d < p.backoff {
+		// overflow
+		d = p.backoff
+	}
+	if p.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isRetryable reports whether an exchange failed with err may succeed on retry.
+// TLS errors such as certificate errors never succeed, and canceled requests are not retried.
+func isRetryable(err error) bool {
+	switch classifyError(err) {
+	case "tls", "canceled":
+		return false
+	}
+	return true
+}
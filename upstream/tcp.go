@@ -67,18 +67,18 @@ func NewTCPUpstream(ctx context.Context, core adapter.Core, logger log.Logger, t
 	}
 	socksAddr, err := common.NewSocksAddrFromStringWithDefaultPort(options.Address, 53)
 	if err != nil {
-		return nil, fmt.Errorf("create tcp upstream failed: invalid address: %s, error: %s", options.Address, err)
+		return nil, fmt.Errorf("create tcp upstream failed: invalid address: %s, error: %w", options.Address, err)
 	}
 	u.address = *socksAddr
 	dialer, err := network.NewDialer(options.DialerOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create tcp upstream failed: create dialer: %s", err)
+		return nil, fmt.Errorf("create tcp upstream failed: create dialer: %w", err)
 	}
 	u.dialer = dialer
 	if options.BootstrapOptions != nil {
 		b, err := bootstrap.NewBootstrap(ctx, core, *options.BootstrapOptions)
 		if err != nil {
-			return nil, fmt.Errorf("create tcp upstream failed: create bootstrap: %s", err)
+			return nil, fmt.Errorf("create tcp upstream failed: create bootstrap: %w", err)
 		}
 		u.bootstrap = b
 	}
@@ -118,7 +118,7 @@ func (u *TCPUpstream) Start() error {
 	if u.bootstrap != nil {
 		err := u.bootstrap.Start()
 		if err != nil {
-			return fmt.Errorf("start bootstrap failed: %s", err)
+			return fmt.Errorf("start bootstrap failed: %w", err)
 		}
 	}
 	if !u.enablePipeline {
@@ -166,7 +166,7 @@ func (u *TCPUpstream) newTCPConn(ctx context.Context) (net.Conn, error) {
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, _, err := network.DialParallel(ctx, u.dialer, "tcp", ips, u.address.Port())
 			return conn, err
@@ -179,7 +179,7 @@ func (u *TCPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	if !u.enablePipeline {
 		conn, err := u.tcpConnPool.Get(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("get tcp connection failed: %s", err)
+			return nil, fmt.Errorf("get tcp connection failed: %w", err)
 		}
 		deadline, ok := ctx.Deadline()
 		if !ok {
@@ -187,15 +187,15 @@ func (u *TCPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 		}
 		err = conn.SetDeadline(deadline)
 		if err != nil {
-			return nil, fmt.Errorf("set tcp connection deadline failed: %s", err)
+			return nil, fmt.Errorf("set tcp connection deadline failed: %w", err)
 		}
 		err = conn.WriteMsg(req)
 		if err != nil {
-			return nil, fmt.Errorf("send dns message failed: %s", err)
+			return nil, fmt.Errorf("send dns message failed: %w", err)
 		}
 		resp, err := conn.ReadMsg()
 		if err != nil {
-			return nil, fmt.Errorf("receive dns message failed: %s", err)
+			return nil, fmt.Errorf("receive dns message failed: %w", err)
 		}
 		u.tcpConnPool.Put(ctx, conn)
 		return resp, nil
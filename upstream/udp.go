@@ -75,18 +75,18 @@ func NewUDPUpstream(ctx context.Context, core adapter.Core, logger log.Logger, t
 	}
 	socksAddr, err := common.NewSocksAddrFromStringWithDefaultPort(options.Address, 53)
 	if err != nil {
-		return nil, fmt.Errorf("create udp upstream failed: invalid address: %s, error: %s", options.Address, err)
+		return nil, fmt.Errorf("create udp upstream failed: invalid address: %s, error: %w", options.Address, err)
 	}
 	u.address = *socksAddr
 	dialer, err := network.NewDialer(options.DialerOptions)
 	if err != nil {
-		return nil, fmt.Errorf("create udp upstream failed: create dialer: %s", err)
+		return nil, fmt.Errorf("create udp upstream failed: create dialer: %w", err)
 	}
 	u.dialer = dialer
 	if options.BootstrapOptions != nil {
 		b, err := bootstrap.NewBootstrap(ctx, core, *options.BootstrapOptions)
 		if err != nil {
-			return nil, fmt.Errorf("create udp upstream failed: create bootstrap: %s", err)
+			return nil, fmt.Errorf("create udp upstream failed: create bootstrap: %w", err)
 		}
 		u.bootstrap = b
 	}
@@ -128,7 +128,7 @@ func (u *UDPUpstream) Start() error {
 	if u.bootstrap != nil {
 		err := u.bootstrap.Start()
 		if err != nil {
-			return fmt.Errorf("start bootstrap failed: %s", err)
+			return fmt.Errorf("start bootstrap failed: %w", err)
 		}
 	}
 	u.udpConnPool = pool.NewPool(u.ctx, 0, u.idleTimeout, func(ctx context.Context) (*dns.Conn, error) {
@@ -195,7 +195,7 @@ func (u *UDPUpstream) newUDPConn(ctx context.Context) (net.Conn, error) {
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, _, err := network.DialParallel(ctx, u.dialer, "udp", ips, u.address.Port())
 			return conn, err
@@ -210,7 +210,7 @@ func (u *UDPUpstream) newTCPConn(ctx context.Context) (net.Conn, error) {
 			domain := u.address.Domain()
 			ips, err := u.bootstrap.Lookup(ctx, domain)
 			if err != nil {
-				return nil, fmt.Errorf("lookup domain failed: %s, error: %s", domain, err)
+				return nil, fmt.Errorf("lookup domain failed: %s, error: %w", domain, err)
 			}
 			conn, _, err := network.DialParallel(ctx, u.dialer, "tcp", ips, u.address.Port())
 			return conn, err
@@ -223,7 +223,7 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	// UDP
 	conn, err := u.udpConnPool.Get(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("get udp connection failed: %s", err)
+		return nil, fmt.Errorf("get udp connection failed: %w", err)
 	}
 	deadline, ok := ctx.Deadline()
 	if !ok {
@@ -231,7 +231,7 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	}
 	err = conn.SetDeadline(deadline)
 	if err != nil {
-		return nil, fmt.Errorf("set udp connection deadline failed: %s", err)
+		return nil, fmt.Errorf("set udp connection deadline failed: %w", err)
 	}
 	// EDNS0
 	_req := req
@@ -251,14 +251,14 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	}
 	err = conn.WriteMsg(req)
 	if err != nil {
-		return nil, fmt.Errorf("send dns message failed: %s", err)
+		return nil, fmt.Errorf("send dns message failed: %w", err)
 	}
 	var resp *dns.Msg
 	if u.edns0 {
 		for {
 			resp, err = conn.ReadMsg()
 			if err != nil {
-				return nil, fmt.Errorf("receive dns message failed: %s", err)
+				return nil, fmt.Errorf("receive dns message failed: %w", err)
 			}
 			if resp.IsEdns0() != nil {
 				break
@@ -270,7 +270,7 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	} else {
 		resp, err = conn.ReadMsg()
 		if err != nil {
-			return nil, fmt.Errorf("receive dns message failed: %s", err)
+			return nil, fmt.Errorf("receive dns message failed: %w", err)
 		}
 	}
 	u.udpConnPool.Put(ctx, conn)
@@ -285,7 +285,7 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 	if !u.enablePipeline {
 		conn, err := u.tcpConnPool.Get(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("get tcp connection failed: %s", err)
+			return nil, fmt.Errorf("get tcp connection failed: %w", err)
 		}
 		deadline, ok := ctx.Deadline()
 		if !ok {
@@ -293,15 +293,15 @@ func (u *UDPUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, err
 		}
 		err = conn.SetDeadline(deadline)
 		if err != nil {
-			return nil, fmt.Errorf("set tcp connection deadline failed: %s", err)
+			return nil, fmt.Errorf("set tcp connection deadline failed: %w", err)
 		}
 		err = conn.WriteMsg(req)
 		if err != nil {
-			return nil, fmt.Errorf("send dns message failed: %s", err)
+			return nil, fmt.Errorf("send dns message failed: %w", err)
 		}
 		resp, err := conn.ReadMsg()
 		if err != nil {
-			return nil, fmt.Errorf("receive dns message failed: %s", err)
+			return nil, fmt.Errorf("receive dns message failed: %w", err)
 		}
 		u.tcpConnPool.Put(ctx, conn)
 		return resp, nil
@@ -10,6 +10,7 @@ import (
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/utils"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/miekg/dns"
 )
 
@@ -18,9 +19,12 @@ func init() {
 }
 
 type Options struct {
-	Tag          string
-	Type         string
-	QueryTimeout time.Duration
+	Tag            string
+	Type           string
+	QueryTimeout   time.Duration
+	Coalesce       bool
+	Retry          *RetryOptions
+	CircuitBreaker *CircuitBreakerOptions
 
 	UDPOptions   *UDPUpstreamOptions
 	TCPOptions   *TCPUpstreamOptions
@@ -35,12 +39,19 @@ type Options struct {
 	ParallelOptions  *ParallelUpstreamOptions
 	QueryTestOptions *QueryTestUpstreamOptions
 	FallbackOptions  *FallbackUpstreamOptions
+
+	LoadBalanceOptions *LoadBalanceUpstreamOptions
+	ForwardZoneOptions *ForwardZoneUpstreamOptions
+	ZoneOptions        *ZoneUpstreamOptions
 }
 
 type _Options struct {
-	Tag          string         `yaml:"tag"`
-	Type         string         `yaml:"type"`
-	QueryTimeout utils.Duration `yaml:"query-timeout"`
+	Tag            string                 `yaml:"tag"`
+	Type           string                 `yaml:"type"`
+	QueryTimeout   utils.Duration         `yaml:"query-timeout"`
+	Coalesce       bool                   `yaml:"coalesce"`
+	Retry          *RetryOptions          `yaml:"retry"`
+	CircuitBreaker *CircuitBreakerOptions `yaml:"circuit-breaker"`
 }
 
 func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -84,6 +95,15 @@ func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	case FallbackUpstreamType:
 		o.FallbackOptions = &FallbackUpstreamOptions{}
 		data = o.FallbackOptions
+	case LoadBalanceUpstreamType:
+		o.LoadBalanceOptions = &LoadBalanceUpstreamOptions{}
+		data = o.LoadBalanceOptions
+	case ForwardZoneUpstreamType:
+		o.ForwardZoneOptions = &ForwardZoneUpstreamOptions{}
+		data = o.ForwardZoneOptions
+	case ZoneUpstreamType:
+		o.ZoneOptions = &ZoneUpstreamOptions{}
+		data = o.ZoneOptions
 	default:
 		return fmt.Errorf("unknown upstream type: %s", _o.Type)
 	}
@@ -94,6 +114,9 @@ func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	o.Type = _o.Type
 	o.Tag = _o.Tag
 	o.QueryTimeout = time.Duration(_o.QueryTimeout)
+	o.Coalesce = _o.Coalesce
+	o.Retry = _o.Retry
+	o.CircuitBreaker = _o.CircuitBreaker
 	return nil
 }
 
@@ -102,7 +125,10 @@ const DefaultRetry = 3
 type GenericUpstream struct {
 	adapter.Upstream
 	queryTimeout time.Duration
-	retry        int
+	retry        *retryPolicy
+	metrics      *upstreamMetrics
+	coalescer    *coalescer
+	breaker      *circuitBreaker
 }
 
 func (g *GenericUpstream) Start() error {
@@ -121,23 +147,65 @@ func (g *GenericUpstream) Close() error {
 	return nil
 }
 
+func (g *GenericUpstream) APIHandler() chi.Router {
+	apiHandler, isAPIHandler := g.Upstream.(adapter.APIHandler)
+	if isAPIHandler {
+		return apiHandler.APIHandler()
+	}
+	return nil
+}
+
 func (g *GenericUpstream) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
-	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(g.queryTimeout))
-	defer cancel()
-	var lastErr error
-	for i := 0; i < g.retry; i++ {
-		resp, err := g.Upstream.Exchange(ctx, req)
+	if g.coalescer != nil {
+		return g.coalescer.exchange(ctx, req, g.exchange)
+	}
+	return g.exchange(ctx, req)
+}
+
+func (g *GenericUpstream) exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	if g.breaker != nil {
+		probe, ok := g.breaker.allow()
+		if !ok {
+			return nil, ErrCircuitOpen
+		}
+		defer func() {
+			g.breaker.record(probe, err)
+		}()
+	}
+	startTime := time.Now()
+	defer func() {
+		g.metrics.observe(time.Since(startTime), resp, err)
+	}()
+	if g.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(g.queryTimeout))
+		defer cancel()
+	}
+	for i := 0; i < g.retry.attempts; i++ {
+		if i > 0 {
+			if !g.retry.wait(ctx, i) {
+				return nil, ctx.Err()
+			}
+			g.metrics.retries.Add(1)
+		}
+		resp, err = g.Upstream.Exchange(ctx, req)
 		if err == nil {
-			return resp, nil
+			err = g.retry.checkResponse(resp)
+			if err == nil {
+				return resp, nil
+			}
+			resp = nil
 		}
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
-			lastErr = err
+		}
+		if !isRetryable(err) {
+			break
 		}
 	}
-	return nil, lastErr
+	return nil, err
 }
 
 func NewUpstream(ctx context.Context, core adapter.Core, logger log.Logger, tag string, options Options) (adapter.Upstream, error) {
@@ -174,22 +242,46 @@ func NewUpstream(ctx context.Context, core adapter.Core, logger log.Logger, tag
 	case FallbackUpstreamType:
 		noGeneric = true
 		u, err = NewFallbackUpstream(ctx, core, logger, tag, *options.FallbackOptions)
+	case LoadBalanceUpstreamType:
+		noGeneric = true
+		u, err = NewLoadBalanceUpstream(ctx, core, logger, tag, *options.LoadBalanceOptions)
+	case ForwardZoneUpstreamType:
+		noGeneric = true
+		u, err = NewForwardZoneUpstream(ctx, core, logger, tag, *options.ForwardZoneOptions)
+	case ZoneUpstreamType:
+		noGeneric = true
+		u, err = NewZoneUpstream(ctx, core, logger, tag, *options.ZoneOptions)
 	default:
 		return nil, fmt.Errorf("unknown upstream type: %s", options.Type)
 	}
 	if err != nil {
 		return nil, err
 	}
+	g := &GenericUpstream{
+		metrics:  newUpstreamMetrics(),
+		Upstream: u,
+	}
+	// Composite upstreams handle timeout and retry by themselves, only metrics are recorded
+	attempts := 1
 	if !noGeneric {
-		queryTimeout := options.QueryTimeout
-		if queryTimeout <= 0 {
-			queryTimeout = DefaultQueryTimeout
+		g.queryTimeout = options.QueryTimeout
+		if g.queryTimeout <= 0 {
+			g.queryTimeout = DefaultQueryTimeout
 		}
-		u = &GenericUpstream{
-			queryTimeout: queryTimeout,
-			retry:        DefaultRetry,
-			Upstream:     u,
+		attempts = DefaultRetry
+	}
+	g.retry, err = newRetryPolicy(options.Retry, attempts)
+	if err != nil {
+		return nil, fmt.Errorf("create upstream failed: %w", err)
+	}
+	if options.CircuitBreaker != nil {
+		g.breaker, err = newCircuitBreaker(options.CircuitBreaker)
+		if err != nil {
+			return nil, fmt.Errorf("create upstream failed: %w", err)
 		}
 	}
-	return u, nil
+	if options.Coalesce {
+		g.coalescer = newCoalescer()
+	}
+	return g, nil
 }
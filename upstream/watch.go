@@ -0,0 +1,170 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/log"
+)
+
+// fileWatcher finds modified files by their modification time and size, it is not safe for concurrent use
+type fileWatcher struct {
+	files []string
+	stats []fileStat
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(files []string) *fileWatcher {
+	return &fileWatcher{
+		files: files,
+	}
+}
+
+// stat returns the zero value for files which can not be stated
+func (w *fileWatcher) stat() []fileStat {
+	stats := make([]fileStat, len(w.files))
+	for i, file := range w.files {
+		info, err := os.Stat(file)
+		if err == nil {
+			stats[i] = fileStat{
+				modTime: info.ModTime(),
+				size:    info.Size(),
+			}
+		}
+	}
+	return stats
+}
+
+// update records the current state of the files, it should be called before the files are loaded,
+// so that a change during loading is found next time
+func (w *fileWatcher) update() {
+	w.stats = w.stat()
+}
+
+// changed reports whether any file is changed since the last update
+func (w *fileWatcher) changed() bool {
+	for i, s := range w.stat() {
+		if !s.modTime.Equal(w.stats[i].modTime) || s.size != w.stats[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// fileReloader reloads files with the reload callback when they are modified, or by the reload API
+type fileReloader struct {
+	logger   log.Logger
+	name     string
+	interval time.Duration
+	reload   func() error
+
+	// lock guards watcher and serializes reload
+	lock    sync.Mutex
+	watcher *fileWatcher
+	reloads atomic.Uint64
+
+	loopCtx    context.Context
+	loopCancel context.CancelFunc
+	closeDone  chan struct{}
+}
+
+// newFileReloader returns a reloader of files, name describes the files in logs, such as "hosts files"
+func newFileReloader(logger log.Logger, name string, files []string, interval time.Duration, reload func() error) *fileReloader {
+	return &fileReloader{
+		logger:   logger,
+		name:     name,
+		interval: interval,
+		reload:   reload,
+		watcher:  newFileWatcher(files),
+	}
+}
+
+// Load calls reload with the lock held
+func (r *fileReloader) Load() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.load()
+}
+
+// load must be called with the lock held
+func (r *fileReloader) load() error {
+	// A file which fails to load is not loaded again until it is changed
+	r.watcher.update()
+	err := r.reload()
+	if err != nil {
+		return err
+	}
+	r.reloads.Add(1)
+	return nil
+}
+
+// Reloads returns the number of successful loads, including the first one
+func (r *fileReloader) Reloads() uint64 {
+	return r.reloads.Load()
+}
+
+func (r *fileReloader) Start(ctx context.Context) {
+	r.loopCtx, r.loopCancel = context.WithCancel(ctx)
+	r.closeDone = make(chan struct{}, 1)
+	go r.loopWatch()
+}
+
+func (r *fileReloader) Close() {
+	r.loopCancel()
+	<-r.closeDone
+	close(r.closeDone)
+}
+
+func (r *fileReloader) loopWatch() {
+	defer func() {
+		select {
+		case r.closeDone <- struct{}{}:
+		default:
+		}
+	}()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.loopCtx.Done():
+			return
+		case <-ticker.C:
+			r.lock.Lock()
+			if r.watcher.changed() {
+				r.logger.Infof("%s changed, reload", r.name)
+				err := r.load()
+				if err != nil {
+					r.logger.Errorf("reload %s failed: %s", r.name, err)
+				}
+			}
+			r.lock.Unlock()
+		}
+	}
+}
+
+// APIHandler reloads the files, it responds 429 if a reload is running
+func (r *fileReloader) APIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.lock.TryLock() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		defer r.lock.Unlock()
+		r.logger.Infof("reload %s", r.name)
+		err := r.load()
+		if err != nil {
+			r.logger.Errorf("reload %s failed: %s", r.name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
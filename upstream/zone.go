@@ -0,0 +1,458 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+	"github.com/rnetx/cdns/utils"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/miekg/dns"
+)
+
+type ZoneUpstreamFile struct {
+	File   string `yaml:"file"`
+	Origin string `yaml:"origin,omitempty"`
+}
+
+type _ZoneUpstreamFile ZoneUpstreamFile
+
+// UnmarshalYAML accepts a file path, or a file path with origin
+func (f *ZoneUpstreamFile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var file string
+	err := unmarshal(&file)
+	if err == nil {
+		f.File = file
+		return nil
+	}
+	var _f _ZoneUpstreamFile
+	err = unmarshal(&_f)
+	if err != nil {
+		return err
+	}
+	*f = ZoneUpstreamFile(_f)
+	return nil
+}
+
+type ZoneUpstreamOptions struct {
+	Files         utils.Listable[ZoneUpstreamFile] `yaml:"files"`
+	Fallback      string                           `yaml:"fallback,omitempty"`
+	WatchInterval utils.Duration                   `yaml:"watch-interval,omitempty"`
+}
+
+const (
+	ZoneUpstreamType = "zone"
+
+	DefaultZoneWatchInterval = 10 * time.Second
+	// zoneMaxCNAMEChain is the most CNAMEs followed within a zone
+	zoneMaxCNAMEChain = 8
+)
+
+var (
+	_ adapter.Upstream   = (*ZoneUpstream)(nil)
+	_ adapter.Starter    = (*ZoneUpstream)(nil)
+	_ adapter.Closer     = (*ZoneUpstream)(nil)
+	_ adapter.Validator  = (*ZoneUpstream)(nil)
+	_ adapter.APIHandler = (*ZoneUpstream)(nil)
+)
+
+type ZoneUpstream struct {
+	ctx    context.Context
+	tag    string
+	core   adapter.Core
+	logger log.Logger
+
+	files       []ZoneUpstreamFile
+	fallbackTag string
+	fallback    adapter.Upstream
+
+	// zones is keyed by origin
+	zones    atomic.Pointer[map[string]*zone]
+	reloader *fileReloader
+
+	reqTotal   atomic.Uint64
+	reqSuccess atomic.Uint64
+}
+
+type zone struct {
+	origin string
+	file   string
+	soa    *dns.SOA
+	// records is keyed by lower case owner name and type
+	records map[string]map[uint16][]dns.RR
+	// names contains owner names and empty non-terminals
+	names map[string]bool
+	count int
+}
+
+func NewZoneUpstream(ctx context.Context, core adapter.Core, logger log.Logger, tag string, options ZoneUpstreamOptions) (adapter.Upstream, error) {
+	u := &ZoneUpstream{
+		ctx:    ctx,
+		tag:    tag,
+		core:   core,
+		logger: logger,
+	}
+	if len(options.Files) == 0 {
+		return nil, fmt.Errorf("create zone upstream failed: missing files")
+	}
+	for _, f := range options.Files {
+		if f.File == "" {
+			return nil, fmt.Errorf("create zone upstream failed: missing file")
+		}
+	}
+	u.files = options.Files
+	paths := make([]string, 0, len(u.files))
+	for _, f := range u.files {
+		paths = append(paths, f.File)
+	}
+	if options.WatchInterval < 0 {
+		return nil, fmt.Errorf("create zone upstream failed: invalid watch-interval: %s", time.Duration(options.WatchInterval))
+	}
+	watchInterval := DefaultZoneWatchInterval
+	if options.WatchInterval > 0 {
+		watchInterval = time.Duration(options.WatchInterval)
+	}
+	u.reloader = newFileReloader(u.logger, "zone files", paths, watchInterval, u.reload)
+	u.fallbackTag = options.Fallback
+	err := u.reloader.Load()
+	if err != nil {
+		return nil, fmt.Errorf("create zone upstream failed: %w", err)
+	}
+	return u, nil
+}
+
+func (u *ZoneUpstream) Tag() string {
+	return u.tag
+}
+
+func (u *ZoneUpstream) Type() string {
+	return ZoneUpstreamType
+}
+
+func (u *ZoneUpstream) Dependencies() []string {
+	if u.fallbackTag == "" {
+		return nil
+	}
+	return []string{u.fallbackTag}
+}
+
+func (u *ZoneUpstream) Validate() error {
+	if u.fallbackTag != "" {
+		uu := u.core.GetUpstream(u.fallbackTag)
+		if uu == nil {
+			return fmt.Errorf("upstream [%s] not found", u.fallbackTag)
+		}
+		u.fallback = uu
+	}
+	return nil
+}
+
+func (u *ZoneUpstream) Start() error {
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
+	u.reloader.Start(u.ctx)
+	return nil
+}
+
+func (u *ZoneUpstream) Close() error {
+	u.reloader.Close()
+	return nil
+}
+
+// reload loads all zone files, the current zones are kept if any of them fails.
+// It is called by the reloader.
+func (u *ZoneUpstream) reload() error {
+	zones := make(map[string]*zone, len(u.files))
+	for _, f := range u.files {
+		z, err := loadZone(f)
+		if err != nil {
+			return fmt.Errorf("load zone file failed: %s, error: %w", f.File, err)
+		}
+		if zz, ok := zones[z.origin]; ok {
+			return fmt.Errorf("duplicate zone: %s, files: %s, %s", z.origin, zz.file, z.file)
+		}
+		zones[z.origin] = z
+		u.logger.Infof("load zone: %s, serial: %d, records: %d", z.origin, z.soa.Serial, z.count)
+	}
+	u.zones.Store(&zones)
+	return nil
+}
+
+func loadZone(f ZoneUpstreamFile) (*zone, error) {
+	file, err := os.Open(f.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	origin := ""
+	if f.Origin != "" {
+		origin = dns.Fqdn(strings.ToLower(f.Origin))
+	}
+	var (
+		rrs []dns.RR
+		soa *dns.SOA
+	)
+	zp := dns.NewZoneParser(file, origin, f.File)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if s, isSOA := rr.(*dns.SOA); isSOA {
+			if soa != nil {
+				return nil, fmt.Errorf("multiple SOA records")
+			}
+			soa = s
+		}
+		rrs = append(rrs, rr)
+	}
+	err = zp.Err()
+	if err != nil {
+		return nil, err
+	}
+	if soa == nil {
+		return nil, fmt.Errorf("missing SOA record")
+	}
+	soaName := strings.ToLower(soa.Hdr.Name)
+	if origin == "" {
+		origin = soaName
+	} else if soaName != origin {
+		return nil, fmt.Errorf("SOA record is not at origin: %s", soa.Hdr.Name)
+	}
+	z := &zone{
+		origin:  origin,
+		file:    f.File,
+		soa:     soa,
+		records: make(map[string]map[uint16][]dns.RR),
+		names:   make(map[string]bool),
+		count:   len(rrs),
+	}
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(origin, name) {
+			return nil, fmt.Errorf("out of zone record: %s", rr.Header().Name)
+		}
+		rrsets := z.records[name]
+		if rrsets == nil {
+			rrsets = make(map[uint16][]dns.RR)
+			z.records[name] = rrsets
+		}
+		rrsets[rr.Header().Rrtype] = append(rrsets[rr.Header().Rrtype], rr)
+		// Mark the name and its empty non-terminals up to the origin
+		for n := name; !z.names[n]; {
+			z.names[n] = true
+			if n == origin {
+				break
+			}
+			off, end := dns.NextLabel(n, 0)
+			if end {
+				break
+			}
+			n = n[off:]
+		}
+	}
+	for name, rrsets := range z.records {
+		cname := rrsets[dns.TypeCNAME]
+		if len(cname) == 0 {
+			continue
+		}
+		if len(cname) > 1 {
+			return nil, fmt.Errorf("multiple CNAME records at %s", name)
+		}
+		for rrtype := range rrsets {
+			switch rrtype {
+			case dns.TypeCNAME, dns.TypeRRSIG, dns.TypeNSEC:
+			default:
+				return nil, fmt.Errorf("CNAME and other data at %s", name)
+			}
+		}
+	}
+	return z, nil
+}
+
+// findZone returns the zone with the longest origin which contains name, name must be lower case
+func findZone(zones map[string]*zone, name string) *zone {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if z := zones[name[off:]]; z != nil {
+			return z
+		}
+	}
+	return nil
+}
+
+// lookup answers qname in the zone as in RFC 1034 4.3.2, CNAMEs are followed within the zone
+func (z *zone) lookup(resp *dns.Msg, qname string, qtype uint16) {
+	resp.Authoritative = true
+	for i := 0; i <= zoneMaxCNAMEChain; i++ {
+		name := strings.ToLower(qname)
+		if ns := z.delegation(name, qtype); ns != nil {
+			// Referral, the answer is not authoritative for the child zone
+			resp.Authoritative = false
+			resp.Ns = copyRRs(ns, "")
+			resp.Extra = z.glue(ns)
+			return
+		}
+		rrsets, ok := z.records[name]
+		if !ok && !z.names[name] {
+			rrsets, ok = z.wildcard(name)
+			if !ok {
+				resp.Rcode = dns.RcodeNameError
+				resp.Ns = []dns.RR{z.negativeSOA()}
+				return
+			}
+		}
+		if qtype == dns.TypeANY && len(rrsets) > 0 {
+			for _, rrs := range rrsets {
+				resp.Answer = append(resp.Answer, copyRRs(rrs, qname)...)
+			}
+			return
+		}
+		if rrs := rrsets[qtype]; len(rrs) > 0 {
+			resp.Answer = append(resp.Answer, copyRRs(rrs, qname)...)
+			return
+		}
+		cname := rrsets[dns.TypeCNAME]
+		if len(cname) == 0 {
+			// NODATA
+			resp.Ns = []dns.RR{z.negativeSOA()}
+			return
+		}
+		resp.Answer = append(resp.Answer, copyRRs(cname, qname)...)
+		qname = cname[0].(*dns.CNAME).Target
+		if !dns.IsSubDomain(z.origin, strings.ToLower(qname)) {
+			return
+		}
+	}
+}
+
+// delegation returns the NS records of the highest zone cut at or above name, the cut at name does not apply to DS queries
+func (z *zone) delegation(name string, qtype uint16) []dns.RR {
+	indexes := dns.Split(name)
+	for k := len(indexes) - dns.CountLabel(z.origin) - 1; k >= 0; k-- {
+		if k == 0 && qtype == dns.TypeDS {
+			break
+		}
+		if ns := z.records[name[indexes[k]:]][dns.TypeNS]; len(ns) > 0 {
+			return ns
+		}
+	}
+	return nil
+}
+
+// wildcard returns the records of the wildcard at the closest encloser of name
+func (z *zone) wildcard(name string) (map[uint16][]dns.RR, bool) {
+	indexes := dns.Split(name)
+	for _, i := range indexes[1:] {
+		encloser := name[i:]
+		if !z.names[encloser] {
+			continue
+		}
+		source := "*." + encloser
+		if !z.names[source] {
+			return nil, false
+		}
+		return z.records[source], true
+	}
+	return nil, false
+}
+
+// glue returns the address records of the name servers in the zone
+func (z *zone) glue(ns []dns.RR) []dns.RR {
+	var extra []dns.RR
+	for _, rr := range ns {
+		rrsets := z.records[strings.ToLower(rr.(*dns.NS).Ns)]
+		extra = append(extra, copyRRs(rrsets[dns.TypeA], "")...)
+		extra = append(extra, copyRRs(rrsets[dns.TypeAAAA], "")...)
+	}
+	return extra
+}
+
+// negativeSOA returns the SOA record for negative answers, its TTL is the negative caching TTL of RFC 2308
+func (z *zone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa)
+	soa.Header().Ttl = min(z.soa.Hdr.Ttl, z.soa.Minttl)
+	return soa
+}
+
+// copyRRs copies rrs, so that the zone is not modified by the caller, the owner name is replaced if owner is not empty
+func copyRRs(rrs []dns.RR, owner string) []dns.RR {
+	result := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		if owner != "" {
+			rr.Header().Name = owner
+		}
+		result = append(result, rr)
+	}
+	return result
+}
+
+func (u *ZoneUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	question := req.Question[0]
+	if question.Qclass == dns.ClassINET {
+		z := findZone(*u.zones.Load(), strings.ToLower(question.Name))
+		if z != nil {
+			u.logger.DebugfContext(ctx, "zone [%s] matched", z.origin)
+			respMsg := &dns.Msg{}
+			respMsg.SetReply(req)
+			z.lookup(respMsg, question.Name, question.Qtype)
+			return respMsg, nil
+		}
+	}
+	if u.fallback != nil {
+		return u.fallback.Exchange(ctx, req)
+	}
+	respMsg := &dns.Msg{}
+	respMsg.SetRcode(req, dns.RcodeRefused)
+	return respMsg, nil
+}
+
+func (u *ZoneUpstream) Exchange(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error) {
+	u.reqTotal.Add(1)
+	resp, err = u.exchange(ctx, req)
+	if err == nil {
+		u.reqSuccess.Add(1)
+	}
+	return
+}
+
+func (u *ZoneUpstream) StatisticalData() map[string]any {
+	total := u.reqTotal.Load()
+	success := u.reqSuccess.Load()
+	zoneMap := *u.zones.Load()
+	zones := make([]map[string]any, 0, len(zoneMap))
+	for _, z := range zoneMap {
+		zones = append(zones, map[string]any{
+			"origin":  z.origin,
+			"file":    z.file,
+			"serial":  z.soa.Serial,
+			"records": z.count,
+		})
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i]["origin"].(string) < zones[j]["origin"].(string)
+	})
+	return map[string]any{
+		"total":   total,
+		"success": success,
+		"reloads": u.reloader.Reloads(),
+		"zones":   zones,
+	}
+}
+
+func (u *ZoneUpstream) APIHandler() chi.Router {
+	builder := utils.NewChiRouterBuilder()
+	builder.Add(&utils.ChiRouterBuilderItem{
+		Path:        "/reload",
+		Methods:     []string{http.MethodPost},
+		Description: "reload zone files",
+		Handler:     u.reloader.APIHandler(),
+	})
+	return builder.Build()
+}
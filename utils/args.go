@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// RunningArgsMap holds the running args of a plugin by random IDs. It is safe for concurrent use,
+// a plugin reused after reload loads the args of the new workflows while serving requests.
+// The zero value is ready to use.
+type RunningArgsMap[T any] struct {
+	lock sync.RWMutex
+	m    map[uint16]T
+}
+
+// Store saves v with a new ID until ctx is done. ctx is the context passed to LoadRunningArgs,
+// which ends when the workflows loading the args are closed, so that a reused plugin frees the args of retired workflows.
+func (r *RunningArgsMap[T]) Store(ctx context.Context, v T) (uint16, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.m == nil {
+		r.m = make(map[uint16]T)
+	}
+	if len(r.m) > math.MaxUint16 {
+		return 0, fmt.Errorf("too many running args: %d", len(r.m))
+	}
+	id := RandomIDUint16()
+	// A free ID is always found, as the map is not full
+	for {
+		if _, ok := r.m[id]; !ok {
+			break
+		}
+		id++
+	}
+	r.m[id] = v
+	context.AfterFunc(ctx, func() {
+		r.Delete(id)
+	})
+	return id, nil
+}
+
+// Load returns the zero value if id is not found
+func (r *RunningArgsMap[T]) Load(id uint16) T {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.m[id]
+}
+
+func (r *RunningArgsMap[T]) Delete(id uint16) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.m, id)
+}
+
+// Len returns the number of args
+func (r *RunningArgsMap[T]) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.m)
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "github.com/miekg/dns"
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
 
 // from mosdns(https://github.com/IrineSistiana/mosdns), thank for @IrineSistiana
 func FakeSOA(name string) *dns.SOA {
@@ -23,3 +27,134 @@ func FakeSOA(name string) *dns.SOA {
 		Minttl:  86400,
 	}
 }
+
+// IsNegativeResponse reports whether resp is a negative response (RFC 2308), NXDOMAIN or NODATA
+func IsNegativeResponse(resp *dns.Msg) bool {
+	return resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0)
+}
+
+// NegativeTTL returns the TTL of a negative response, which is min(SOA.TTL, SOA.MINIMUM) of the SOA in
+// the authority section (RFC 2308 section 5). It returns 0 if there is no SOA, such responses should not be cached.
+func NegativeTTL(resp *dns.Msg) uint32 {
+	for _, rr := range resp.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if ok {
+			return min(soa.Hdr.Ttl, soa.Minttl)
+		}
+	}
+	return 0
+}
+
+// TTLLimit clamps a TTL into [Min, Max], 0 means no limit
+type TTLLimit struct {
+	Min uint32
+	Max uint32
+}
+
+// Clamp returns 0 if ttl is 0, a response with TTL 0 should not be cached
+func (l TTLLimit) Clamp(ttl uint32) uint32 {
+	if ttl == 0 {
+		return 0
+	}
+	if l.Min > 0 && ttl < l.Min {
+		ttl = l.Min
+	}
+	if l.Max > 0 && ttl > l.Max {
+		ttl = l.Max
+	}
+	return ttl
+}
+
+// ECSOption returns the EDNS Client Subnet option of msg, or nil if not exists
+func ECSOption(msg *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		subnet, ok := o.(*dns.EDNS0_SUBNET)
+		if ok {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// ECSKey appends the address of subnet masked to bits to key. Key is returned as is if bits is 0,
+// as an answer of scope 0 is valid for all clients (RFC 7871 section 7.3.1).
+func ECSKey(key string, subnet *dns.EDNS0_SUBNET, bits uint8) string {
+	if subnet == nil || bits == 0 {
+		return key
+	}
+	addr, ok := netip.AddrFromSlice(subnet.Address)
+	if !ok {
+		return key
+	}
+	addr = addr.Unmap()
+	if int(bits) > addr.BitLen() {
+		bits = uint8(addr.BitLen())
+	}
+	prefix, err := addr.Prefix(int(bits))
+	if err != nil {
+		return key
+	}
+	raw := prefix.Addr().AsSlice()[:(bits+7)/8]
+	buf := make([]byte, 0, len(key)+3+len(raw))
+	buf = append(buf, key...)
+	buf = append(buf, byte(subnet.Family>>8), byte(subnet.Family), bits)
+	buf = append(buf, raw...)
+	return BytesToStringUnsafe(buf)
+}
+
+// ECS modes of cache keys, from RFC 7871 section 7.3
+const (
+	// ECSModeSource uses the source prefix of the request
+	ECSModeSource = "source"
+	// ECSModeScope uses the scope prefix of the response
+	ECSModeScope = "scope"
+)
+
+// ECSLookupKeys returns the cache keys of req from the most specific one, key is the key of req without ECS
+func ECSLookupKeys(key string, req *dns.Msg, ecsMode string) []string {
+	if key == "" {
+		return nil
+	}
+	var subnet *dns.EDNS0_SUBNET
+	if ecsMode != "" {
+		subnet = ECSOption(req)
+	}
+	if subnet == nil {
+		return []string{key}
+	}
+	if ecsMode == ECSModeSource {
+		return []string{ECSKey(key, subnet, subnet.SourceNetmask)}
+	}
+	// The scope of the cached answer is unknown, try all prefixes which contain the source
+	keys := make([]string, 0, int(subnet.SourceNetmask)+1)
+	for bits := int(subnet.SourceNetmask); bits >= 0; bits-- {
+		keys = append(keys, ECSKey(key, subnet, uint8(bits)))
+	}
+	return keys
+}
+
+// ECSStoreKey returns the cache key to store resp of req, key is the key of req without ECS
+func ECSStoreKey(key string, req *dns.Msg, resp *dns.Msg, ecsMode string) string {
+	if key == "" || ecsMode == "" {
+		return key
+	}
+	subnet := ECSOption(req)
+	if subnet == nil {
+		return key
+	}
+	bits := subnet.SourceNetmask
+	if ecsMode == ECSModeScope {
+		// A response without ECS is valid for all clients
+		var scope uint8
+		respSubnet := ECSOption(resp)
+		if respSubnet != nil {
+			scope = respSubnet.SourceScope
+		}
+		bits = min(bits, scope)
+	}
+	return ECSKey(key, subnet, bits)
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are upper bounds in seconds
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Int64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, seconds)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+// CounterVec is a set of counters with one label
+type CounterVec struct {
+	lock     sync.RWMutex
+	counters map[string]*atomic.Uint64
+}
+
+func NewCounterVec() *CounterVec {
+	return &CounterVec{
+		counters: make(map[string]*atomic.Uint64),
+	}
+}
+
+func (v *CounterVec) Inc(label string) {
+	v.lock.RLock()
+	c, ok := v.counters[label]
+	v.lock.RUnlock()
+	if !ok {
+		v.lock.Lock()
+		c, ok = v.counters[label]
+		if !ok {
+			c = &atomic.Uint64{}
+			v.counters[label] = c
+		}
+		v.lock.Unlock()
+	}
+	c.Add(1)
+}
+
+// Range calls f for each label in order
+func (v *CounterVec) Range(f func(label string, value uint64)) {
+	v.lock.RLock()
+	labels := make([]string, 0, len(v.counters))
+	for label := range v.counters {
+		labels = append(labels, label)
+	}
+	v.lock.RUnlock()
+	sort.Strings(labels)
+	for _, label := range labels {
+		v.lock.RLock()
+		c := v.counters[label]
+		v.lock.RUnlock()
+		f(label, c.Load())
+	}
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+func L(name string, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+type family struct {
+	help    string
+	typ     string
+	samples []string
+}
+
+// Writer collects samples and writes them in Prometheus text format, samples of the same metric are grouped together
+type Writer struct {
+	families map[string]*family
+	names    []string
+}
+
+func NewWriter() *Writer {
+	return &Writer{
+		families: make(map[string]*family),
+	}
+}
+
+func (w *Writer) family(name string, help string, typ string) *family {
+	f, ok := w.families[name]
+	if !ok {
+		f = &family{help: help, typ: typ}
+		w.families[name] = f
+		w.names = append(w.names, name)
+	}
+	return f
+}
+
+func (w *Writer) Counter(name string, help string, value uint64, labels ...Label) {
+	f := w.family(name, help, "counter")
+	f.samples = append(f.samples, name+formatLabels(labels)+" "+strconv.FormatUint(value, 10))
+}
+
+func (w *Writer) Gauge(name string, help string, value float64, labels ...Label) {
+	f := w.family(name, help, "gauge")
+	f.samples = append(f.samples, name+formatLabels(labels)+" "+formatFloat(value))
+}
+
+func (w *Writer) Histogram(name string, help string, h *Histogram, labels ...Label) {
+	f := w.family(name, help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		f.samples = append(f.samples, name+"_bucket"+formatLabels(append(labels, L("le", formatFloat(bound))))+" "+strconv.FormatUint(cumulative, 10))
+	}
+	count := h.count.Load()
+	f.samples = append(f.samples, name+"_bucket"+formatLabels(append(labels, L("le", "+Inf")))+" "+strconv.FormatUint(count, 10))
+	f.samples = append(f.samples, name+"_sum"+formatLabels(labels)+" "+formatFloat(time.Duration(h.sum.Load()).Seconds()))
+	f.samples = append(f.samples, name+"_count"+formatLabels(labels)+" "+strconv.FormatUint(count, 10))
+}
+
+func (w *Writer) WriteTo(writer io.Writer) (int64, error) {
+	bw := bufio.NewWriter(writer)
+	var n int64
+	for _, name := range w.names {
+		f := w.families[name]
+		nn, err := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+		for _, sample := range f.samples {
+			nn, err = bw.WriteString(sample + "\n")
+			n += int64(nn)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, bw.Flush()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(label.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,237 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+type Options struct {
+	MaxSize    int64         // rotate when the file is larger than MaxSize, 0 disables
+	MaxAge     time.Duration // rotate when the file has been written for MaxAge, 0 disables
+	MaxBackups int           // number of retained rotated files, 0 retains all
+	Compress   bool          // gzip rotated files
+}
+
+// File is an append-only file which is rotated by size and age. Rotated files are renamed to `<path>.<time>`,
+// with `.gz` suffix if compressed.
+type File struct {
+	path    string
+	options Options
+
+	lock      sync.Mutex
+	file      *os.File
+	size      int64
+	openTime  time.Time
+	compress  sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func Open(path string, options Options) (*File, error) {
+	f := &File{
+		path:    path,
+		options: options,
+	}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	// The age of an existing file counts from its last write, so that restarts do not postpone rotation
+	if f.size > 0 {
+		f.openTime = info.ModTime()
+	} else {
+		f.openTime = time.Now()
+	}
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.needRotate(int64(len(p))) {
+		// Keep writing to the original file if rotation fails, it is tried again on the next write
+		err := f.rotate()
+		if err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) needRotate(n int64) bool {
+	if f.options.MaxSize > 0 && f.size+n > f.options.MaxSize {
+		return true
+	}
+	if f.options.MaxAge > 0 && time.Since(f.openTime) >= f.options.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Rotate rotates the file immediately
+func (f *File) Rotate() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// rotate reopens the original file in append mode if it fails before the file is renamed
+func (f *File) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return f.reopen(err)
+	}
+	backupPath := f.path + "." + time.Now().Format(backupTimeFormat)
+	for i := 1; fileExists(backupPath) || fileExists(backupPath+".gz"); i++ {
+		backupPath = fmt.Sprintf("%s.%s.%d", f.path, time.Now().Format(backupTimeFormat), i)
+	}
+	err = os.Rename(f.path, backupPath)
+	if err != nil {
+		return f.reopen(err)
+	}
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	f.compress.Add(1)
+	go func() {
+		defer f.compress.Done()
+		if f.options.Compress {
+			compressFile(backupPath)
+		}
+		f.removeBackups()
+	}()
+	return nil
+}
+
+// reopen opens the file again after a failed rotation, err is the error of the rotation
+func (f *File) reopen(err error) error {
+	openErr := f.open()
+	if openErr != nil {
+		return fmt.Errorf("%w, reopen failed: %w", err, openErr)
+	}
+	return err
+}
+
+// Reopen closes and opens the file again, for external tools like logrotate which move the file away
+func (f *File) Reopen() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	f.file.Close()
+	f.file = nil
+	return f.open()
+}
+
+func (f *File) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		f.lock.Lock()
+		if f.file != nil {
+			err = f.file.Close()
+			f.file = nil
+		}
+		f.lock.Unlock()
+		f.compress.Wait()
+	})
+	return err
+}
+
+// removeBackups removes the oldest rotated files exceeding MaxBackups
+func (f *File) removeBackups() {
+	if f.options.MaxBackups <= 0 {
+		return
+	}
+	dir := filepath.Dir(f.path)
+	prefix := filepath.Base(f.path) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		suffix := strings.TrimPrefix(name, prefix)
+		if len(suffix) < len(backupTimeFormat) {
+			continue
+		}
+		_, err := time.Parse(backupTimeFormat, suffix[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	if len(backups) <= f.options.MaxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-f.options.MaxBackups] {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(dst)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	dst.Close()
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes, it can be decoded from a number or a string like `512KB`, `10MB`, `1GB`
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+	unit := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			unit = u.size
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return ByteSize(n * unit), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int64
+	err := unmarshal(&n)
+	if err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	err = unmarshal(&s)
+	if err != nil {
+		return err
+	}
+	*b, err = ParseByteSize(s)
+	return err
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	err := json.Unmarshal(data, &n)
+	if err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	*b, err = ParseByteSize(s)
+	return err
+}
@@ -0,0 +1,190 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rnetx/cdns/adapter"
+)
+
+// CheckError is a check error with the YAML path of the failed item, e.g. `exec[1].plugin`.
+type CheckError struct {
+	Path string
+	Err  error
+}
+
+func (e *CheckError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// CheckRule checks the rule like Workflow.Check, but returns all errors instead of stopping at the first one.
+// Paths are relative to the rule.
+func CheckRule(ctx context.Context, core adapter.Core, options RuleOptions) []*CheckError {
+	return checkRule(ctx, core, "", options.rule)
+}
+
+// CheckAll checks all rules like Check, but returns all errors instead of stopping at the first one.
+func (w *Workflow) CheckAll() []*CheckError {
+	var errs []*CheckError
+	for i, rule := range w.rules {
+		errs = append(errs, checkRule(w.ctx, w.core, fmt.Sprintf("rules[%d]", i), rule)...)
+	}
+	return errs
+}
+
+func joinPath(path string, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "." + elem
+}
+
+func checkRule(ctx context.Context, core adapter.Core, path string, rule Rule) []*CheckError {
+	var errs []*CheckError
+	switch r := rule.(type) {
+	case *RuleExec:
+		for i, e := range r.Execs {
+			errs = append(errs, checkItemExec(ctx, core, joinPath(path, fmt.Sprintf("exec[%d]", i)), e)...)
+		}
+	case *RuleMatchAnd:
+		for i, m := range r.MatchAnds {
+			errs = append(errs, checkItemMatch(ctx, core, joinPath(path, fmt.Sprintf("match-and[%d]", i)), m)...)
+		}
+		for i, e := range r.ElseExecs {
+			errs = append(errs, checkItemExec(ctx, core, joinPath(path, fmt.Sprintf("else-exec[%d]", i)), e)...)
+		}
+		for i, e := range r.Execs {
+			errs = append(errs, checkItemExec(ctx, core, joinPath(path, fmt.Sprintf("exec[%d]", i)), e)...)
+		}
+	case *RuleMatchOr:
+		for i, m := range r.MatchOrs {
+			errs = append(errs, checkItemMatch(ctx, core, joinPath(path, fmt.Sprintf("match-or[%d]", i)), m)...)
+		}
+		for i, e := range r.ElseExecs {
+			errs = append(errs, checkItemExec(ctx, core, joinPath(path, fmt.Sprintf("else-exec[%d]", i)), e)...)
+		}
+		for i, e := range r.Execs {
+			errs = append(errs, checkItemExec(ctx, core, joinPath(path, fmt.Sprintf("exec[%d]", i)), e)...)
+		}
+	default:
+		err := rule.Check(ctx, core)
+		if err != nil {
+			errs = append(errs, &CheckError{Path: path, Err: err})
+		}
+	}
+	return errs
+}
+
+func checkItemExec(ctx context.Context, core adapter.Core, path string, e *RuleItemExec) []*CheckError {
+	if r, ok := e.rule.(*itemExecutorWorkflowRulesRule); ok {
+		var errs []*CheckError
+		for i, rule := range r.rules {
+			errs = append(errs, checkRule(ctx, core, joinPath(path, fmt.Sprintf("workflow-rules[%d]", i)), rule)...)
+		}
+		return errs
+	}
+	return newItemCheckError(path, itemExecutorRuleKey(e.rule), e.check(ctx, core))
+}
+
+func checkItemMatch(ctx context.Context, core adapter.Core, path string, m *RuleItemMatch) []*CheckError {
+	switch r := m.rule.(type) {
+	case *itemMatcherMatchAndRule:
+		var errs []*CheckError
+		for i := range r.matchAnd {
+			errs = append(errs, checkItemMatch(ctx, core, joinPath(path, fmt.Sprintf("match-and[%d]", i)), &r.matchAnd[i])...)
+		}
+		return errs
+	case *itemMatcherMatchOrRule:
+		var errs []*CheckError
+		for i := range r.matchOr {
+			errs = append(errs, checkItemMatch(ctx, core, joinPath(path, fmt.Sprintf("match-or[%d]", i)), &r.matchOr[i])...)
+		}
+		return errs
+	}
+	return newItemCheckError(path, itemMatcherRuleKey(m.rule), m.check(ctx, core))
+}
+
+// newItemCheckError moves the rule key prefix of err, e.g. `plugin: `, into the path
+func newItemCheckError(path string, key string, err error) []*CheckError {
+	if err == nil {
+		return nil
+	}
+	if key != "" {
+		msg := err.Error()
+		if strings.HasPrefix(msg, key+": ") {
+			return []*CheckError{{Path: joinPath(path, key), Err: errors.New(strings.TrimPrefix(msg, key+": "))}}
+		}
+	}
+	return []*CheckError{{Path: path, Err: err}}
+}
+
+func itemExecutorRuleKey(rule itemExecutorRule) string {
+	switch rule.(type) {
+	case *itemExecutorMarkRule:
+		return "mark"
+	case *itemExecutorMetadataRule:
+		return "metadata"
+	case *itemExecutorPluginExecutorRule:
+		return "plugin"
+	case *itemExecutorUpstreamRule:
+		return "upstream"
+	case *itemExecutorJumpToRule:
+		return "jump-to"
+	case *itemExecutorGoToRule:
+		return "go-to"
+	case *itemExecutorWorkflowRulesRule:
+		return "workflow-rules"
+	case *itemExecutorFallbackRule:
+		return "fallback"
+	case *itemExecutorParallelRule:
+		return "parallel"
+	case *itemExecutorSetTTLRule:
+		return "set-ttl"
+	case *itemExecutorSetRespIPRule:
+		return "set-resp-ip"
+	case *itemExecutorCleanRule:
+		return "clean"
+	case *itemExecutorReturnRule:
+		return "return"
+	}
+	return ""
+}
+
+func itemMatcherRuleKey(rule itemMatcherRule) string {
+	switch rule.(type) {
+	case *itemMatcherListenerRule:
+		return "listener"
+	case *itemMatcherClientIPRule:
+		return "client-ip"
+	case *itemMatcherQTypeRule:
+		return "qtype"
+	case *itemMatcherQNameRule:
+		return "qname"
+	case *itemMatcherHasRespMsgRule:
+		return "has-resp-msg"
+	case *itemMatcherRespIPRule:
+		return "resp-ip"
+	case *itemMatcherMarkRule:
+		return "mark"
+	case *itemMatcherEnvRule:
+		return "env"
+	case *itemMatcherMetadataRule:
+		return "metadata"
+	case *itemMatcherPluginMatcherRule:
+		return "plugin"
+	case *itemMatcherMatchOrRule:
+		return "match-or"
+	case *itemMatcherMatchAndRule:
+		return "match-and"
+	}
+	return ""
+}
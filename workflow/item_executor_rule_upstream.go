@@ -69,7 +69,34 @@ func (r *itemExecutorUpstreamRule) check(_ context.Context, core adapter.Core) e
 	return nil
 }
 
+// exec serves the fallback response of dnsCtx if the upstream fails, responds with SERVFAIL or REFUSED,
+// or does not respond within the fallback timeout
 func (r *itemExecutorUpstreamRule) exec(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
+	fallbackMsg, timeout := dnsCtx.FallbackRespMsg()
+	if fallbackMsg == nil {
+		return r.exchange(ctx, core, logger, dnsCtx)
+	}
+	exchangeCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		exchangeCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	mode, err := r.exchange(exchangeCtx, core, logger, dnsCtx)
+	if err == nil {
+		respMsg := dnsCtx.RespMsg()
+		if respMsg == nil || (respMsg.Rcode != dns.RcodeServerFailure && respMsg.Rcode != dns.RcodeRefused) {
+			return mode, nil
+		}
+		err = fmt.Errorf("rcode: %s", dns.RcodeToString[respMsg.Rcode])
+	}
+	logger.DebugfContext(ctx, "upstream: upstream [%s] exchange failed: %v, use fallback response", r.upstream.Tag(), err)
+	dnsCtx.SetRespMsg(fallbackMsg)
+	dnsCtx.SetRespUpstreamTag("")
+	return adapter.ReturnModeContinue, nil
+}
+
+func (r *itemExecutorUpstreamRule) exchange(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
 	reqMsg := dnsCtx.ReqMsg()
 	if reqMsg == nil {
 		logger.DebugfContext(ctx, "upstream: request message is nil")
@@ -46,7 +46,7 @@ func (r *itemExecutorWorkflowRulesRule) check(ctx context.Context, core adapter.
 func (r *itemExecutorWorkflowRulesRule) exec(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
 	for i, w := range r.rules {
 		logger.DebugfContext(ctx, "workflow-rules: workflow-rule[%d] exec", i)
-		returnMode, err := w.Exec(ctx, core, logger, dnsCtx)
+		returnMode, err := execRule(traceItemContext(ctx, dnsCtx, "workflow-rules", i), core, logger, dnsCtx, w)
 		if err != nil {
 			logger.ErrorfContext(ctx, "workflow-rules: workflow-rule[%d] exec failed: %v", i, err)
 			return adapter.ReturnModeUnknown, err
@@ -44,7 +44,7 @@ func (r *itemMatcherMatchAndRule) match(ctx context.Context, core adapter.Core,
 	match := true
 	for i, m := range r.matchAnd {
 		logger.DebugfContext(ctx, "match-and: match match-and[%d]", i)
-		matched, err := m.match(ctx, core, logger, dnsCtx)
+		matched, err := m.match(traceItemContext(ctx, dnsCtx, "match-and", i), core, logger, dnsCtx)
 		if err != nil {
 			logger.DebugfContext(ctx, "match-and: match match-and[%d] failed: %v", i, err)
 			return false, err
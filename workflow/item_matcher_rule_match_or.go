@@ -44,7 +44,7 @@ func (r *itemMatcherMatchOrRule) match(ctx context.Context, core adapter.Core, l
 	match := false
 	for i, m := range r.matchOr {
 		logger.DebugfContext(ctx, "match-or: match match-or[%d]", i)
-		matched, err := m.match(ctx, core, logger, dnsCtx)
+		matched, err := m.match(traceItemContext(ctx, dnsCtx, "match-or", i), core, logger, dnsCtx)
 		if err != nil {
 			logger.DebugfContext(ctx, "match-or: match match-or[%d] failed: %v", i, err)
 			return false, err
@@ -13,6 +13,11 @@ type Rule interface {
 	Exec(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error)
 }
 
+// ruleHitCounter is implemented by rules with matchers, hits is the number of matched evaluations
+type ruleHitCounter interface {
+	hits() uint64
+}
+
 type RuleOptions struct {
 	rule Rule
 }
@@ -59,7 +59,7 @@ func (r *RuleExec) Check(ctx context.Context, core adapter.Core) error {
 func (r *RuleExec) Exec(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
 	for i, e := range r.Execs {
 		logger.DebugfContext(ctx, "run exec[%d]", i)
-		returnMode, err := e.exec(ctx, core, logger, dnsCtx)
+		returnMode, err := e.exec(traceItemContext(ctx, dnsCtx, "exec", i), core, logger, dnsCtx)
 		if err != nil {
 			logger.ErrorfContext(ctx, "run exec[%d]: run failed: %s", i, err)
 			return adapter.ReturnModeUnknown, err
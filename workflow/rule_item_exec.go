@@ -3,6 +3,7 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
@@ -53,6 +54,8 @@ func (r *RuleItemExec) UnmarshalYAML(unmarshal func(interface{}) error) error {
 				r.rule = &itemExecutorReturnRule{
 					_return: "all",
 				}
+			default:
+				return fmt.Errorf("exec rule: unknown rule: %s", s)
 			}
 			return nil
 		}
@@ -114,5 +117,21 @@ func (r *RuleItemExec) check(ctx context.Context, core adapter.Core) error {
 }
 
 func (r *RuleItemExec) exec(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
-	return r.rule.exec(ctx, core, logger, dnsCtx)
+	if dnsCtx.Trace() == nil {
+		return r.rule.exec(ctx, core, logger, dnsCtx)
+	}
+	startTime := time.Now()
+	upstreamTag := dnsCtx.RespUpstreamTag()
+	returnMode, err := r.rule.exec(ctx, core, logger, dnsCtx)
+	event := adapter.TraceEvent{
+		Type:   "exec",
+		Rule:   itemExecutorRuleKey(r.rule),
+		Return: returnMode.String(),
+		Error:  traceError(err),
+	}
+	if dnsCtx.RespUpstreamTag() != upstreamTag {
+		event.Upstream = dnsCtx.RespUpstreamTag()
+	}
+	traceAdd(ctx, dnsCtx, startTime, event)
+	return returnMode, err
 }
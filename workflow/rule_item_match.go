@@ -3,6 +3,7 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
@@ -98,13 +99,23 @@ func (r *RuleItemMatch) check(ctx context.Context, core adapter.Core) error {
 }
 
 func (r *RuleItemMatch) match(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext) (bool, error) {
+	startTime := time.Now()
 	matched, err := r.rule.match(ctx, core, logger, dnsCtx)
-	if err != nil {
-		return matched, err
-	}
-	if r.invert {
+	if err == nil && r.invert {
 		logger.DebugfContext(ctx, "invert match: %t => %t", matched, !matched)
-		return !matched, nil
+		matched = !matched
+	}
+	if dnsCtx.Trace() != nil {
+		event := adapter.TraceEvent{
+			Type:   "match",
+			Rule:   itemMatcherRuleKey(r.rule),
+			Invert: r.invert,
+			Error:  traceError(err),
+		}
+		if err == nil {
+			event.Matched = &matched
+		}
+		traceAdd(ctx, dnsCtx, startTime, event)
 	}
-	return matched, nil
+	return matched, err
 }
@@ -3,6 +3,7 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
@@ -16,6 +17,8 @@ type RuleMatchAnd struct {
 	MatchAnds []*RuleItemMatch
 	ElseExecs []*RuleItemExec
 	Execs     []*RuleItemExec
+
+	matched atomic.Uint64
 }
 
 type RuleMatchAndOptions struct {
@@ -109,7 +112,7 @@ func (r *RuleMatchAnd) Exec(ctx context.Context, core adapter.Core, logger log.L
 	match := true
 	for i, m := range r.MatchAnds {
 		logger.DebugfContext(ctx, "run match-and[%d]", i)
-		matched, err := m.match(ctx, core, logger, dnsCtx)
+		matched, err := m.match(traceItemContext(ctx, dnsCtx, "match-and", i), core, logger, dnsCtx)
 		if err != nil {
 			logger.ErrorfContext(ctx, "run match-and[%d]: run failed: %s", i, err)
 			return adapter.ReturnModeUnknown, err
@@ -123,10 +126,11 @@ func (r *RuleMatchAnd) Exec(ctx context.Context, core adapter.Core, logger log.L
 	}
 	logger.DebugfContext(ctx, "run match-and finish")
 	if match {
+		r.matched.Add(1)
 		if len(r.Execs) > 0 {
 			for i, e := range r.Execs {
 				logger.DebugfContext(ctx, "run exec[%d]", i)
-				returnMode, err := e.exec(ctx, core, logger, dnsCtx)
+				returnMode, err := e.exec(traceItemContext(ctx, dnsCtx, "exec", i), core, logger, dnsCtx)
 				if err != nil {
 					logger.ErrorfContext(ctx, "run exec[%d]: run failed: %s", i, err)
 					return adapter.ReturnModeUnknown, err
@@ -147,7 +151,7 @@ func (r *RuleMatchAnd) Exec(ctx context.Context, core adapter.Core, logger log.L
 		if len(r.ElseExecs) > 0 {
 			for i, e := range r.ElseExecs {
 				logger.DebugfContext(ctx, "run else-exec[%d]", i)
-				returnMode, err := e.exec(ctx, core, logger, dnsCtx)
+				returnMode, err := e.exec(traceItemContext(ctx, dnsCtx, "else-exec", i), core, logger, dnsCtx)
 				if err != nil {
 					logger.ErrorfContext(ctx, "run else-exec[%d]: run failed: %s", i, err)
 					return adapter.ReturnModeUnknown, err
@@ -166,3 +170,7 @@ func (r *RuleMatchAnd) Exec(ctx context.Context, core adapter.Core, logger log.L
 		}
 	}
 }
+
+func (r *RuleMatchAnd) hits() uint64 {
+	return r.matched.Load()
+}
@@ -3,6 +3,7 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
@@ -16,6 +17,8 @@ type RuleMatchOr struct {
 	MatchOrs  []*RuleItemMatch
 	ElseExecs []*RuleItemExec
 	Execs     []*RuleItemExec
+
+	matched atomic.Uint64
 }
 
 type RuleMatchOrOptions struct {
@@ -109,7 +112,7 @@ func (o *RuleMatchOr) Exec(ctx context.Context, core adapter.Core, logger log.Lo
 	match := false
 	for i, m := range o.MatchOrs {
 		logger.DebugfContext(ctx, "run match-or[%d]", i)
-		matched, err := m.match(ctx, core, logger, dnsCtx)
+		matched, err := m.match(traceItemContext(ctx, dnsCtx, "match-or", i), core, logger, dnsCtx)
 		if err != nil {
 			logger.ErrorfContext(ctx, "run match-or[%d]: run failed: %s", i, err)
 			return adapter.ReturnModeUnknown, err
@@ -123,10 +126,11 @@ func (o *RuleMatchOr) Exec(ctx context.Context, core adapter.Core, logger log.Lo
 	}
 	logger.DebugfContext(ctx, "run match-or finish")
 	if match {
+		o.matched.Add(1)
 		if len(o.Execs) > 0 {
 			for i, e := range o.Execs {
 				logger.DebugfContext(ctx, "run exec[%d]", i)
-				returnMode, err := e.exec(ctx, core, logger, dnsCtx)
+				returnMode, err := e.exec(traceItemContext(ctx, dnsCtx, "exec", i), core, logger, dnsCtx)
 				if err != nil {
 					logger.ErrorfContext(ctx, "run exec[%d]: run failed: %s", i, err)
 					return adapter.ReturnModeUnknown, err
@@ -147,7 +151,7 @@ func (o *RuleMatchOr) Exec(ctx context.Context, core adapter.Core, logger log.Lo
 		if len(o.ElseExecs) > 0 {
 			for i, e := range o.ElseExecs {
 				logger.DebugfContext(ctx, "run else-exec[%d]", i)
-				returnMode, err := e.exec(ctx, core, logger, dnsCtx)
+				returnMode, err := e.exec(traceItemContext(ctx, dnsCtx, "else-exec", i), core, logger, dnsCtx)
 				if err != nil {
 					logger.ErrorfContext(ctx, "run else-exec[%d]: run failed: %s", i, err)
 					return adapter.ReturnModeUnknown, err
@@ -166,3 +170,7 @@ func (o *RuleMatchOr) Exec(ctx context.Context, core adapter.Core, logger log.Lo
 		}
 	}
 }
+
+func (o *RuleMatchOr) hits() uint64 {
+	return o.matched.Load()
+}
@@ -0,0 +1,73 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rnetx/cdns/adapter"
+	"github.com/rnetx/cdns/log"
+)
+
+type traceContextKey struct{}
+
+type traceContext struct {
+	workflow string
+	path     string
+}
+
+// traceWorkflowContext starts a new path for the workflow, if tracing is enabled
+func traceWorkflowContext(ctx context.Context, dnsCtx *adapter.DNSContext, tag string) context.Context {
+	if dnsCtx.Trace() == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceContextKey{}, &traceContext{workflow: tag})
+}
+
+// traceItemContext appends `elem[i]` to the path, if tracing is enabled
+func traceItemContext(ctx context.Context, dnsCtx *adapter.DNSContext, elem string, i int) context.Context {
+	if dnsCtx.Trace() == nil {
+		return ctx
+	}
+	tc := &traceContext{}
+	parent, ok := ctx.Value(traceContextKey{}).(*traceContext)
+	if ok {
+		*tc = *parent
+	}
+	tc.path = joinPath(tc.path, fmt.Sprintf("%s[%d]", elem, i))
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+func traceAdd(ctx context.Context, dnsCtx *adapter.DNSContext, startTime time.Time, event adapter.TraceEvent) {
+	trace := dnsCtx.Trace()
+	if trace == nil {
+		return
+	}
+	tc, ok := ctx.Value(traceContextKey{}).(*traceContext)
+	if ok {
+		event.Workflow = tc.workflow
+		event.Path = tc.path
+	}
+	trace.Add(startTime, event)
+}
+
+func traceError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func execRule(ctx context.Context, core adapter.Core, logger log.Logger, dnsCtx *adapter.DNSContext, rule Rule) (adapter.ReturnMode, error) {
+	if dnsCtx.Trace() == nil {
+		return rule.Exec(ctx, core, logger, dnsCtx)
+	}
+	startTime := time.Now()
+	returnMode, err := rule.Exec(ctx, core, logger, dnsCtx)
+	traceAdd(ctx, dnsCtx, startTime, adapter.TraceEvent{
+		Type:   "rule",
+		Return: returnMode.String(),
+		Error:  traceError(err),
+	})
+	return returnMode, err
+}
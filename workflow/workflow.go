@@ -3,10 +3,12 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/rnetx/cdns/adapter"
 	"github.com/rnetx/cdns/log"
 	"github.com/rnetx/cdns/utils"
+	"github.com/rnetx/cdns/utils/metrics"
 )
 
 type WorkflowOptions struct {
@@ -20,7 +22,8 @@ type Workflow struct {
 	core   adapter.Core
 	logger log.Logger
 
-	rules []Rule
+	rules       []Rule
+	evaluations []atomic.Uint64
 }
 
 func NewWorkflow(ctx context.Context, core adapter.Core, logger log.Logger, tag string, options WorkflowOptions) (adapter.Workflow, error) {
@@ -37,6 +40,7 @@ func NewWorkflow(ctx context.Context, core adapter.Core, logger log.Logger, tag
 	for _, o := range options.Rules {
 		w.rules = append(w.rules, o.rule)
 	}
+	w.evaluations = make([]atomic.Uint64, len(w.rules))
 	return w, nil
 }
 
@@ -56,9 +60,11 @@ func (w *Workflow) Check() error {
 }
 
 func (w *Workflow) Exec(ctx context.Context, dnsCtx *adapter.DNSContext) (adapter.ReturnMode, error) {
+	ctx = traceWorkflowContext(ctx, dnsCtx, w.tag)
 	for i, rule := range w.rules {
 		w.logger.DebugfContext(ctx, "rule[%d] exec", i)
-		returnMode, err := rule.Exec(ctx, w.core, w.logger, dnsCtx)
+		w.evaluations[i].Add(1)
+		returnMode, err := execRule(traceItemContext(ctx, dnsCtx, "rules", i), w.core, w.logger, dnsCtx, rule)
 		if err != nil {
 			w.logger.ErrorfContext(ctx, "rule[%d] exec failed: %v", i, err)
 			return adapter.ReturnModeUnknown, err
@@ -74,3 +80,17 @@ func (w *Workflow) Exec(ctx context.Context, dnsCtx *adapter.DNSContext) (adapte
 	}
 	return adapter.ReturnModeContinue, nil
 }
+
+func (w *Workflow) CollectMetrics(mw *metrics.Writer) {
+	for i, rule := range w.rules {
+		labels := []metrics.Label{metrics.L("workflow", w.tag), metrics.L("rule", fmt.Sprintf("rules[%d]", i))}
+		evaluations := w.evaluations[i].Load()
+		mw.Counter("cdns_workflow_rule_evaluations_total", "Total number of workflow rule evaluations.", evaluations, labels...)
+		// Rules without matchers hit on every evaluation
+		hits := evaluations
+		if c, ok := rule.(ruleHitCounter); ok {
+			hits = c.hits()
+		}
+		mw.Counter("cdns_workflow_rule_hits_total", "Total number of workflow rule hits.", hits, labels...)
+	}
+}